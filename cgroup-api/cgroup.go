@@ -0,0 +1,214 @@
+package cgroup
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const Root = "/sys/fs/cgroup"
+
+var ErrNoScope = errors.New("process is not in a machine scope")
+
+// Group is the cgroup of a machine scope, in the unified hierarchy or in
+// the controllers of the legacy one.
+type Group struct {
+	Unified string
+	Memory  string
+	CPU     string
+	IO      string
+}
+
+// Counters are the resource counters of a group since it was created.
+type Counters struct {
+	MemoryCurrent uint64
+	MemoryPeak    uint64 // zero if not supported
+	CPUTime       uint64 // in nanoseconds
+	IOReadBytes   uint64
+	IOWriteBytes  uint64
+}
+
+// ScopeOf returns the cgroup of the machine scope the process belongs to,
+// as systemd-nspawn puts the container into machine-NAME.scope.
+func ScopeOf(pid uint32) (*Group, error) {
+	f, err := os.Open("/proc/" + strconv.FormatUint(uint64(pid), 10) + "/cgroup")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	g := &Group{}
+	found := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		scope := machineScope(fields[2])
+		if scope == "" {
+			continue
+		}
+		found = true
+		for _, controller := range strings.Split(fields[1], ",") {
+			switch controller {
+			case "":
+				g.Unified = unifiedRoot() + scope
+			case "memory":
+				g.Memory = path.Join(Root, "memory", scope)
+			case "cpuacct":
+				g.CPU = path.Join(Root, "cpuacct", scope)
+			case "blkio":
+				g.IO = path.Join(Root, "blkio", scope)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNoScope
+	}
+	return g, nil
+}
+
+// machineScope trims a cgroup path to the machine scope in it.
+func machineScope(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, "machine-") && strings.HasSuffix(part, ".scope") {
+			return strings.Join(parts[:i+1], "/")
+		}
+	}
+	return ""
+}
+
+func unifiedRoot() string {
+	if _, err := os.Stat(path.Join(Root, "unified")); err == nil {
+		return path.Join(Root, "unified")
+	}
+	return Root
+}
+
+// Read reads the counters of the group. Counters of missing controllers
+// are left zero.
+func (g *Group) Read() (Counters, error) {
+	var c Counters
+	var err error
+	if g.Unified != "" {
+		if _, err := os.Stat(path.Join(g.Unified, "cpu.stat")); err == nil {
+			return readUnified(g.Unified)
+		}
+	}
+	if g.Memory != "" {
+		if c.MemoryCurrent, err = readUint(path.Join(g.Memory, "memory.usage_in_bytes")); err != nil {
+			return c, err
+		}
+		c.MemoryPeak, _ = readUint(path.Join(g.Memory, "memory.max_usage_in_bytes"))
+	}
+	if g.CPU != "" {
+		if c.CPUTime, err = readUint(path.Join(g.CPU, "cpuacct.usage")); err != nil {
+			return c, err
+		}
+	}
+	if g.IO != "" {
+		p := path.Join(g.IO, "blkio.throttle.io_service_bytes_recursive")
+		if _, err := os.Stat(p); err != nil {
+			p = path.Join(g.IO, "blkio.throttle.io_service_bytes")
+		}
+		if c.IOReadBytes, c.IOWriteBytes, err = readBlkio(p); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+func readUnified(dir string) (Counters, error) {
+	var c Counters
+	var err error
+	if c.MemoryCurrent, err = readUint(path.Join(dir, "memory.current")); err != nil {
+		return c, err
+	}
+	// memory.peak is there since Linux 5.19
+	c.MemoryPeak, _ = readUint(path.Join(dir, "memory.peak"))
+	stat, err := readKeyed(path.Join(dir, "cpu.stat"))
+	if err != nil {
+		return c, err
+	}
+	c.CPUTime = stat["usage_usec"] * 1000
+	b, err := ioutil.ReadFile(path.Join(dir, "io.stat"))
+	if err != nil && !os.IsNotExist(err) {
+		return c, err
+	}
+	// MAJ:MIN rbytes=N wbytes=N rios=N wios=N ...
+	for _, line := range strings.Split(string(b), "\n") {
+		for _, field := range strings.Fields(line) {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			n, _ := strconv.ParseUint(kv[1], 10, 64)
+			switch kv[0] {
+			case "rbytes":
+				c.IOReadBytes += n
+			case "wbytes":
+				c.IOWriteBytes += n
+			}
+		}
+	}
+	return c, nil
+}
+
+// readBlkio sums up the bytes read and written on all devices.
+func readBlkio(p string) (read, write uint64, err error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return 0, 0, err
+	}
+	// MAJ:MIN Read N
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		n, _ := strconv.ParseUint(fields[2], 10, 64)
+		switch fields[1] {
+		case "Read":
+			read += n
+		case "Write":
+			write += n
+		}
+	}
+	return read, write, nil
+}
+
+func readUint(p string) (uint64, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
+
+func readKeyed(p string) (map[string]uint64, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]uint64)
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		n, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		m[fields[0]] = n
+	}
+	return m, nil
+}
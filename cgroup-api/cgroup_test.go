@@ -0,0 +1,218 @@
+package cgroup
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// writeGroup writes fixture files under dir, keyed by their path in it.
+func writeGroup(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		p := path.Join(dir, name)
+		if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGroupRead(t *testing.T) {
+	legacy := Group{Memory: "memory", CPU: "cpuacct", IO: "blkio"}
+	tests := []struct {
+		name  string
+		group Group
+		files map[string]string
+		want  Counters
+		err   bool
+	}{
+		{
+			name:  "unified",
+			group: Group{Unified: "unified"},
+			files: map[string]string{
+				"unified/memory.current": "1048576\n",
+				"unified/memory.peak":    "4194304\n",
+				"unified/cpu.stat":       "usage_usec 2500\nuser_usec 2000\nsystem_usec 500\n",
+				"unified/io.stat": "8:0 rbytes=4096 wbytes=8192 rios=1 wios=2 dbytes=0 dios=0\n" +
+					"259:0 rbytes=100 wbytes=200 rios=1 wios=1 dbytes=0 dios=0\n",
+			},
+			want: Counters{
+				MemoryCurrent: 1048576,
+				MemoryPeak:    4194304,
+				CPUTime:       2500000,
+				IOReadBytes:   4196,
+				IOWriteBytes:  8392,
+			},
+		},
+		{
+			name:  "unified without peak and io",
+			group: Group{Unified: "unified"},
+			files: map[string]string{
+				"unified/memory.current": "1024\n",
+				"unified/cpu.stat":       "usage_usec 7\n",
+			},
+			want: Counters{MemoryCurrent: 1024, CPUTime: 7000},
+		},
+		{
+			name:  "unified without memory",
+			group: Group{Unified: "unified"},
+			files: map[string]string{
+				"unified/cpu.stat": "usage_usec 7\n",
+			},
+			err: true,
+		},
+		{
+			name:  "legacy",
+			group: legacy,
+			files: map[string]string{
+				"memory/memory.usage_in_bytes":     "2048\n",
+				"memory/memory.max_usage_in_bytes": "8192\n",
+				"cpuacct/cpuacct.usage":            "123456789\n",
+				"blkio/blkio.throttle.io_service_bytes_recursive": "8:0 Read 300\n8:0 Write 400\n8:0 Sync 700\n" +
+					"8:16 Read 5\n8:16 Write 6\nTotal 711\n",
+				"blkio/blkio.throttle.io_service_bytes": "8:0 Read 1\n8:0 Write 1\n",
+			},
+			want: Counters{
+				MemoryCurrent: 2048,
+				MemoryPeak:    8192,
+				CPUTime:       123456789,
+				IOReadBytes:   305,
+				IOWriteBytes:  406,
+			},
+		},
+		{
+			name:  "legacy without recursive blkio",
+			group: legacy,
+			files: map[string]string{
+				"memory/memory.usage_in_bytes":          "2048\n",
+				"cpuacct/cpuacct.usage":                 "10\n",
+				"blkio/blkio.throttle.io_service_bytes": "8:0 Read 1\n8:0 Write 2\nTotal 3\n",
+			},
+			want: Counters{MemoryCurrent: 2048, CPUTime: 10, IOReadBytes: 1, IOWriteBytes: 2},
+		},
+		{
+			name:  "legacy with unified hierarchy not delegated",
+			group: Group{Unified: "unified", Memory: "memory"},
+			files: map[string]string{
+				"unified/cgroup.procs":         "1\n",
+				"memory/memory.usage_in_bytes": "64\n",
+			},
+			want: Counters{MemoryCurrent: 64},
+		},
+		{
+			name:  "legacy without cpuacct",
+			group: legacy,
+			files: map[string]string{
+				"memory/memory.usage_in_bytes": "2048\n",
+			},
+			err: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "ciel-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			writeGroup(t, dir, test.files)
+			g := test.group
+			for _, p := range []*string{&g.Unified, &g.Memory, &g.CPU, &g.IO} {
+				if *p != "" {
+					*p = path.Join(dir, *p)
+				}
+			}
+			c, err := g.Read()
+			if test.err {
+				if err == nil {
+					t.Fatalf("read %+v, want error", c)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c != test.want {
+				t.Errorf("read %+v, want %+v", c, test.want)
+			}
+		})
+	}
+}
+
+func TestMachineScope(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/machine.slice/machine-main.scope", "/machine.slice/machine-main.scope"},
+		{"/machine.slice/machine-main.scope/payload", "/machine.slice/machine-main.scope"},
+		{"/machine.slice/machine-a\\x2db.scope/init.scope", "/machine.slice/machine-a\\x2db.scope"},
+		{"/user.slice/user-1000.slice/session-1.scope", ""},
+		{"/machine.slice/machine-main.service", ""},
+		{"/", ""},
+	}
+	for _, test := range tests {
+		if got := machineScope(test.path); got != test.want {
+			t.Errorf("machineScope(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestMeterScopes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ciel-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	scope := func(name string, mem, peak, usec, rbytes string) {
+		writeGroup(t, dir, map[string]string{
+			name + "/memory.current": mem,
+			name + "/memory.peak":    peak,
+			name + "/cpu.stat":       "usage_usec " + usec,
+			name + "/io.stat":        "8:0 rbytes=" + rbytes + " wbytes=0",
+		})
+	}
+	current := "old"
+	locate := func() (*Group, error) {
+		if current == "" {
+			return nil, ErrNoScope
+		}
+		return &Group{Unified: path.Join(dir, current)}, nil
+	}
+	// the peak of a scope older than the meter is not ours
+	scope("old", "100", "9999", "1000", "10")
+	// the meter is driven by hand instead of its ticker
+	m := newMeter(locate)
+	scope("old", "300", "9999", "4000", "50")
+	m.sample()
+	if m.usage.PeakMemory != 300 {
+		t.Errorf("peak %d of the old scope, want 300", m.usage.PeakMemory)
+	}
+
+	// the machine is restarted
+	if err := os.RemoveAll(path.Join(dir, "old")); err != nil {
+		t.Fatal(err)
+	}
+	current = ""
+	m.sample()
+	if m.group != nil {
+		t.Fatal("gone scope still sampled")
+	}
+	current = "new"
+	scope("new", "200", "500", "2000", "7")
+	m.sample()
+	if !m.fresh || m.usage.PeakMemory != 500 {
+		t.Errorf("peak %d of the new scope, want 500", m.usage.PeakMemory)
+	}
+	m.closeScope()
+
+	if m.cpuTime != 5000000 {
+		t.Errorf("cpu time %d, want 5000000", m.cpuTime)
+	}
+	if m.usage.IOReadBytes != 47 {
+		t.Errorf("read %d bytes, want 47", m.usage.IOReadBytes)
+	}
+}
@@ -0,0 +1,123 @@
+package cgroup
+
+import (
+	"time"
+)
+
+const SampleInterval = 500 * time.Millisecond
+
+// Usage is the resource usage of a command run in a machine.
+type Usage struct {
+	PeakMemory   uint64  `json:"peak_memory_bytes"`
+	CPUSeconds   float64 `json:"cpu_seconds"`
+	IOReadBytes  uint64  `json:"io_read_bytes"`
+	IOWriteBytes uint64  `json:"io_write_bytes"`
+	WallSeconds  float64 `json:"wall_seconds"`
+}
+
+// Meter accounts the resource usage of a machine while a command runs in
+// it, by sampling the counters of its scope. The machine may be started,
+// stopped or restarted meanwhile, the usage of its scopes is added up.
+type Meter struct {
+	locate func() (*Group, error)
+	start  time.Time
+
+	group      *Group   // scope being sampled
+	base, last Counters // of the scope
+	fresh      bool     // the scope appeared after the meter started
+	cpuTime    uint64   // of the scopes sampled before
+	usage      Usage
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StartMeter starts sampling the scope found by locate.
+func StartMeter(locate func() (*Group, error)) *Meter {
+	m := newMeter(locate)
+	go m.run()
+	return m
+}
+
+func newMeter(locate func() (*Group, error)) *Meter {
+	m := &Meter{
+		locate: locate,
+		start:  time.Now(),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if g, err := locate(); err == nil {
+		if c, err := g.Read(); err == nil {
+			m.group, m.base, m.last = g, c, c
+			m.update(c)
+		}
+	}
+	return m
+}
+
+func (m *Meter) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(SampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			m.sample()
+			return
+		case <-ticker.C:
+			m.sample()
+		}
+	}
+}
+
+func (m *Meter) sample() {
+	if m.group != nil {
+		c, err := m.group.Read()
+		if err == nil && c.CPUTime >= m.last.CPUTime {
+			m.update(c)
+			return
+		}
+		// the scope is gone or recreated
+		m.closeScope()
+	}
+	g, err := m.locate()
+	if err != nil {
+		return
+	}
+	c, err := g.Read()
+	if err != nil {
+		return
+	}
+	m.group, m.base, m.last, m.fresh = g, Counters{}, Counters{}, true
+	m.update(c)
+}
+
+func (m *Meter) update(c Counters) {
+	m.last = c
+	if c.MemoryCurrent > m.usage.PeakMemory {
+		m.usage.PeakMemory = c.MemoryCurrent
+	}
+	// the peak of an older scope may come from before the meter started
+	if m.fresh && c.MemoryPeak > m.usage.PeakMemory {
+		m.usage.PeakMemory = c.MemoryPeak
+	}
+}
+
+func (m *Meter) closeScope() {
+	m.cpuTime += m.last.CPUTime - m.base.CPUTime
+	m.usage.IOReadBytes += m.last.IOReadBytes - m.base.IOReadBytes
+	m.usage.IOWriteBytes += m.last.IOWriteBytes - m.base.IOWriteBytes
+	m.group = nil
+}
+
+// Stop stops sampling and returns the usage.
+func (m *Meter) Stop() Usage {
+	close(m.stop)
+	<-m.done
+	if m.group != nil {
+		m.closeScope()
+	}
+	m.usage.CPUSeconds = time.Duration(m.cpuTime).Seconds()
+	m.usage.WallSeconds = time.Since(m.start).Seconds()
+	return m.usage
+}
@@ -1,44 +1,62 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
-	"path"
-	"path/filepath"
 	"strings"
-	"syscall"
+	"time"
 
 	d "github.com/AOSC-Dev/ciel/display"
 	"github.com/AOSC-Dev/ciel/internal/ciel"
 	"github.com/AOSC-Dev/ciel/internal/container/instance"
 	"github.com/AOSC-Dev/ciel/internal/packaging"
 	"github.com/AOSC-Dev/ciel/internal/pkgtree"
+	api "github.com/AOSC-Dev/ciel/pkg/ciel"
+	"github.com/AOSC-Dev/ciel/pkg/daemon"
 )
 
+var buildConfigOpts struct {
+	global bool
+}
+
+func buildConfigFlags(fs *flag.FlagSet) {
+	flagCielDir(fs)
+	flagInstance(fs)
+	flagSuite(fs)
+	fs.BoolVar(&buildConfigOpts.global, "g", false, "global, configure for underlying OS")
+	flagTextAnswer(fs, "maintainer", "maintainer `info`, as in \"Foo Bar <myname@example.com>\"")
+	flagAnswer(fs, "disable-dnssec", "disable DNSSEC without asking, the default in batch mode")
+	flagAnswer(fs, "edit-sources", "edit sources.list without asking")
+	flagAnswer(fs, "local-repo", "enable the local packages repository without asking")
+}
+
 func buildConfig() {
-	basePath := flagCielDir()
-	instName := flagInstance()
-	batch := flagBatch()
-	var global = false
-	flag.BoolVar(&global, "g", global, "global, configure for underlying OS")
-	parse()
-
-	i := &ciel.Ciel{BasePath: *basePath}
-	i.Check()
+	global := buildConfigOpts.global
+	suites, err := packaging.ParseSuites(opts.suite)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	i := &ciel.Ciel{BasePath: opts.cielDir}
+	checkCiel(i)
 	c := i.Container()
 
 	var inst *instance.Instance
 
 	if !global {
-		c.CheckInst(*instName)
-		inst = c.Instance(*instName)
-		inst.Unmount()
-		inst.MountLocal()
+		checkInst(c, opts.instName)
+		inst = c.Instance(opts.instName)
+		if err := shutdownInstance(inst.Name, inst); err != nil {
+			os.Exit(1)
+		}
+		if err := inst.MountLocal(); err != nil {
+			log.Fatalln(err)
+		}
 		defer func() {
-			inst.Unmount()
+			shutdownInstance(inst.Name, inst)
 		}()
 	}
 
@@ -47,33 +65,47 @@ func buildConfig() {
 		suffix = ""
 	}
 
-	tc := packaging.DetectToolChain(global, inst, c)
+	tc, err := packaging.DetectToolChain(global, inst, c)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	d.ITEM("detect autobuild3")
+	detected(tc.AB)
+	d.ITEM("detect acbs")
+	detected(tc.ACBS)
 	if tc.ACBS {
-		packaging.SetTreePath(global, inst, c, pkgtree.TreePath)
+		d.ITEM("set tree path")
+		d.ERR(packaging.SetTreePath(global, inst, c, pkgtree.TreePath))
 	}
 
-	var person string
 	if tc.AB {
-		if !*batch {
-			for person == "" {
-				person = d.ASK("Maintainer Info"+suffix, "Foo Bar <myname@example.com>")
-			}
-		} else {
-			person = "Bot <discussions@lists.aosc.io>"
+		person, err := d.INPUT(d.Prompt{
+			Msg:     "Maintainer Info" + suffix,
+			Hint:    "Foo Bar <myname@example.com>",
+			Flag:    "maintainer",
+			Default: "Bot <discussions@lists.aosc.io>",
+		})
+		if err != nil {
+			log.Fatalln(err)
 		}
-		packaging.SetMaintainer(global, inst, c, person)
+		d.ITEM("set maintainer")
+		d.ERR(packaging.SetMaintainer(global, inst, c, person))
 	}
 
-	if *batch || d.ASKLower("Would you like to disable DNSSEC feature"+suffix+"?", "yes/no") == "yes" {
-		packaging.DisableDNSSEC(global, inst, c)
+	if confirm(d.Question{Msg: "Would you like to disable DNSSEC feature" + suffix + "?", Flag: "disable-dnssec", Default: true}) {
+		d.ITEM("disable DNSSEC")
+		d.ERR(packaging.DisableDNSSEC(global, inst, c))
 	}
 
-	if !*batch && d.ASKLower("Would you like to edit sources.list"+suffix+"?", "yes/no") == "yes" {
-		packaging.EditSourceList(global, inst, c)
+	if confirm(d.Question{Msg: "Would you like to edit sources.list" + suffix + "?", Flag: "edit-sources"}) {
+		if err := packaging.EditSourceList(global, inst, c); err != nil {
+			log.Println(err)
+		}
 	}
 
-	if !*batch && d.ASKLower("Do you want to enable local packages repository?", "yes/no") == "yes" {
-		packaging.InitLocalRepo(global, inst, c)
+	if confirm(d.Question{Msg: "Do you want to enable local packages repository?", Flag: "local-repo"}) {
+		d.ITEM("initialize local repository")
+		d.ERR(packaging.InitLocalRepo(global, inst, c, suites))
 		// add the key to the APT trust store
 		d.ITEM("create and import gpg keys")
 		var prefix string
@@ -82,117 +114,271 @@ func buildConfig() {
 		} else {
 			prefix = inst.MountPoint()
 		}
-		exitStatus := refreshLocalRepo(prefix, true)
+		exitStatus := refreshLocalRepo(prefix, "", true)
 		if exitStatus == 0 {
 			d.OK()
 		} else {
 			d.FAILED_BECAUSE(fmt.Sprintf("Script exited with status %d", exitStatus))
 		}
-		d.ITEM("bootstrap local repository")
-		prefix = path.Join(c.GetCiel().GetBasePath(), "/OUTPUT/debs")
-		exitStatus = refreshLocalRepo(prefix, false)
-		if exitStatus == 0 {
-			d.OK()
-		} else {
-			d.FAILED_BECAUSE(fmt.Sprintf("2nd stage script exited with status %d", exitStatus))
+		output := i.Output()
+		for _, suite := range suites {
+			d.ITEM("bootstrap suite " + suite)
+			exitStatus = refreshLocalRepo(output.BasePath, suite, false)
+			if exitStatus == 0 {
+				d.OK()
+			} else {
+				d.FAILED_BECAUSE(fmt.Sprintf("2nd stage script exited with status %d", exitStatus))
+			}
 		}
 	} else {
-		packaging.UnInitLocalRepo(global, inst, c)
+		d.ITEM("un-initialize local repository")
+		d.ERR(packaging.UnInitLocalRepo(global, inst, c))
 	}
 }
 
-func refreshLocalRepo(debsDir string, firstRun bool) int {
-	proc := filepath.Join(PluginDir, PluginPrefix+"localrepo")
-	cmd := exec.Command(proc, debsDir)
-	if firstRun {
-		cmd.Env = []string{"CIEL_LR_FIRST=1"}
-	}
-	cmd.Stdout = nil
-	cmd.Stderr = nil
-	err := cmd.Run()
-	if exitErr, ok := err.(*exec.ExitError); ok {
-		return exitErr.Sys().(syscall.WaitStatus).ExitStatus()
+// detected shows whether a tool is found.
+func detected(found bool) {
+	if found {
+		d.OK()
+	} else {
+		d.FAILED()
 	}
+}
+
+// refreshLocalRepo regenerates the indices of suite in the repository at
+// repoDir, returning the exit status of the plugin.
+func refreshLocalRepo(repoDir string, suite string, firstRun bool) int {
+	exitStatus, err := api.RefreshLocalRepo(repoDir, suite, firstRun)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	return 0
+	return exitStatus
 }
 
-func build() {
-	basePath := flagCielDir()
-	instName := flagInstance()
-	networkFlag := flagNetwork()
-	noBooting := flagNoBooting()
-	usingLocalRepo := false
-	parse()
-
-	i := &ciel.Ciel{BasePath: *basePath}
-	i.Check()
-	c := i.Container()
-	c.CheckInst(*instName)
-	inst := c.Instance(*instName)
-	inst.Mount()
+var buildOpts struct {
+	reportPath, queueFile                  string
+	keepGoing, clean, cleanAfter, hermetic bool
+	parallel                               int
+	baseline                               string
+}
 
-	debsDir := path.Join(i.GetBasePath(), "OUTPUT", "debs")
-	dir, err := os.Getwd()
-	debsDirTarget := path.Join(dir, inst.MountPoint(), "debs")
+func buildFlags(fs *flag.FlagSet) {
+	flagCielDir(fs)
+	flagInstance(fs)
+	flagNetwork(fs)
+	flagLimits(fs)
+	flagNoBooting(fs)
+	flagSuite(fs)
+	flagWait(fs)
+	o := &buildOpts
+	fs.StringVar(&o.reportPath, "report", "", "write the build report to `file`, defaults to the one beside the build log")
+	fs.StringVar(&o.queueFile, "queue", "", "build packages listed in `file` one by one, in dependency order")
+	fs.BoolVar(&o.keepGoing, "keep-going", false, "continue with other packages in the queue if one fails")
+	fs.IntVar(&o.parallel, "parallel", 0, "build the queue in `N` instances at once, creating temporary ones if -i names fewer")
+	fs.BoolVar(&o.clean, "clean", false, "roll the instance back before each build; build.clean in config")
+	fs.BoolVar(&o.cleanAfter, "clean-after", false, "roll the instance back after each build; build.clean_after in config")
+	fs.StringVar(&o.baseline, "baseline", "", "roll back to baseline `name` instead of the underlying OS; build.baseline in config")
+	fs.BoolVar(&o.hermetic, "hermetic", false, "fetch sources with network, then compile without; build.hermetic in config")
+}
+
+func build() {
+	o := &buildOpts
+	suites, err := packaging.ParseSuites(opts.suite)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	err = os.MkdirAll(debsDir, 0755)
-	if err != nil {
-		log.Fatalln(err)
+
+	if socket := daemonSocket(); socket != "" {
+		if o.queueFile != "" || o.parallel > 1 || strings.Contains(opts.instName, ",") {
+			log.Fatalln("queues and parallel builds are not supported through the daemon")
+		}
+		req := daemon.BuildRequest{
+			Instance: opts.instName,
+			Packages: flag.Args(),
+			Suites:   suites,
+			Network:  opts.network,
+			Limits:   opts.limits,
+			NoBoot:   opts.noBooting,
+		}
+		if flagPassed("clean") {
+			req.Clean = &o.clean
+		}
+		if flagPassed("clean-after") {
+			req.CleanAfter = &o.cleanAfter
+		}
+		if flagPassed("baseline") {
+			req.Baseline = &o.baseline
+		}
+		if flagPassed("hermetic") {
+			req.Hermetic = &o.hermetic
+		}
+		buildRemote(socket, req, o.reportPath)
+		return
 	}
-	err = os.MkdirAll(debsDirTarget, 0755)
-	if err != nil {
-		log.Fatalln(err)
+
+	w := openWorkspace(opts.cielDir)
+	instNames := strings.Split(opts.instName, ",")
+	var insts []*api.Instance
+	for _, name := range instNames {
+		insts = append(insts, openInstance(w, name))
 	}
-	err = syscall.Mount(debsDir, debsDirTarget, "", syscall.MS_BIND, "")
+	if len(instNames) > 1 && o.parallel < len(instNames) {
+		o.parallel = len(instNames)
+	}
+
+	requests := flag.Args()
+	if o.queueFile != "" {
+		list, err := pkgtree.ReadList(o.queueFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		requests = append(requests, list...)
+	}
+	var queue []*api.Package
+	if o.queueFile != "" || isPackageList(requests) || o.parallel > 1 {
+		queue = planQueue(w, requests)
+	}
+
+	newBuilder := func(inst *api.Instance) (*api.Builder, error) {
+		b, err := w.NewBuilder(inst)
+		if err != nil {
+			return nil, err
+		}
+		b.Suites = suites
+		b.Network = opts.network
+		b.Limits = opts.limits
+		b.NoBoot = opts.noBooting
+		b.Wait = opts.wait
+		if flagPassed("clean") {
+			b.Clean = o.clean
+		}
+		if flagPassed("clean-after") {
+			b.CleanAfter = o.cleanAfter
+		}
+		if flagPassed("baseline") {
+			b.Baseline = o.baseline
+		}
+		if flagPassed("hermetic") {
+			b.Hermetic = o.hermetic
+		}
+		if b.Hermetic && b.NoBoot {
+			return nil, api.ErrHermeticNoBoot
+		}
+		b.Stdin = os.Stdin
+		b.Stdout = os.Stdout
+		b.Stderr = os.Stderr
+		b.Notify = note
+		b.Trace = traceCommand
+		return b, nil
+	}
+
+	var steps []*queueStep
+	if o.parallel > 1 {
+		steps, err = buildParallel(w, insts, o.parallel, newBuilder, queue, o.keepGoing)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		finishQueue(steps, o.reportPath)
+		return
+	}
+
+	b, err := newBuilder(insts[0])
 	if err != nil {
 		log.Fatalln(err)
 	}
-	aptConfigPath := path.Join(inst.MountPoint(), packaging.DefaultRepoConfig)
-	if _, err = os.Stat(aptConfigPath); err == nil {
-		usingLocalRepo = true
+	if err := b.Lock(); err != nil {
+		log.Fatalln(b.Instance.Name()+":", err)
 	}
-	if _, err := os.Stat(path.Join(debsDirTarget, "InRelease")); err != nil && usingLocalRepo {
-		refreshLocalRepo(debsDir, false)
+	defer b.Unlock()
+	if err := b.Setup(); err != nil {
+		log.Fatalln(err)
 	}
 
-	cmd := `acbs-build ` + strings.Join(flag.Args(), " ")
+	if queue == nil {
+		report := buildAndReport(b, requests, o.reportPath)
+		b.Teardown()
+		if report.ExitStatus != 0 {
+			b.Unlock()
+			os.Exit(report.ExitStatus)
+		}
+		return
+	}
 
-	exitStatus, err := _shellRun(
-		inst,
-		*networkFlag,
-		!*noBooting,
-		cmd,
-	)
-	if err != nil {
-		log.Println(err)
+	steps = buildQueue(b, queue, o.keepGoing)
+	b.Teardown()
+	b.Unlock()
+	finishQueue(steps, o.reportPath)
+}
+
+// buildAndReport builds, shows the resource usage, then saves the report
+// to reportPath, or beside the build log.
+func buildAndReport(b *api.Builder, args []string, reportPath string) *api.Report {
+	report := b.Build(context.TODO(), args)
+	if report.Usage != nil {
+		b.Notify("resource usage", nil, formatUsage(*report.Usage))
 	}
-	if exitStatus != 0 {
-		os.Exit(exitStatus)
+	if report.Log != "" {
+		b.SaveReport(report, reportPath)
 	}
+	return report
+}
 
-	err = syscall.Unmount(debsDirTarget, 0)
+// note shows a step of a build.
+func note(item string, err error, msg string) {
+	d.ITEM(item)
 	if err != nil {
-		log.Fatalln(err)
+		d.FAILED_BECAUSE(err.Error())
+	} else {
+		d.Println(d.C(d.CYAN, msg))
 	}
+}
 
-	if usingLocalRepo {
-		d.Println(d.C0(d.WHITE, "Refreshing local repository... "))
-		refreshLocalRepo(debsDir, false)
+// finishQueue prints the summary of a queue and saves the reports, exiting
+// with failure if not all packages are built.
+func finishQueue(steps []*queueStep, reportPath string) {
+	printQueueSummary(steps)
+	if reportPath != "" {
+		var reports []*api.Report
+		for _, step := range steps {
+			if step.Report != nil {
+				reports = append(reports, step.Report)
+			}
+		}
+		d.ITEM("save queue report")
+		d.ERR(api.SaveReports(reports, reportPath))
+	}
+	for _, step := range steps {
+		if step.Status != stepSucceeded {
+			os.Exit(1)
+		}
 	}
-	//cmd = exec.Command("sh", "-c", "cp -p "+inst.MountPoint()+"/var/log/apt/history.log OUTPUT/")
-	//cmd.Stderr = os.Stderr
-	//err = cmd.Run()
-	//if exitErr, ok := err.(*exec.ExitError); ok {
-	//	os.Exit(exitErr.Sys().(syscall.WaitStatus).ExitStatus())
-	//}
-	//if err != nil {
-	//	log.Fatalln(err)
-	//}
+}
+
+// formatUsage describes the resource usage of a command.
+func formatUsage(usage api.Usage) string {
+	return fmt.Sprintf("peak memory %s, CPU %s, IO %s read / %s written, wall %s",
+		formatSize(int64(usage.PeakMemory)),
+		formatSeconds(usage.CPUSeconds),
+		formatSize(int64(usage.IOReadBytes)),
+		formatSize(int64(usage.IOWriteBytes)),
+		formatSeconds(usage.WallSeconds),
+	)
+}
 
-	// TODO: collect information
+func formatSeconds(seconds float64) string {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second).String()
+}
+
+// isPackageList reports whether the arguments are more than one package,
+// instead of a single package or options for acbs-build.
+func isPackageList(args []string) bool {
+	if len(args) < 2 {
+		return false
+	}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			return false
+		}
+	}
+	return true
 }
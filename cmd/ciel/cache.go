@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+
+	d "github.com/AOSC-Dev/ciel/display"
+	"github.com/AOSC-Dev/ciel/internal/cache"
+	"github.com/AOSC-Dev/ciel/internal/ciel"
+	api "github.com/AOSC-Dev/ciel/pkg/ciel"
+)
+
+func cacheFlags(fs *flag.FlagSet) {
+	flagCielDir(fs)
+	flagFormat(fs)
+}
+
+func cacheCmd() {
+	checkFormat(opts.format)
+
+	i := &ciel.Ciel{BasePath: opts.cielDir}
+	checkCiel(i)
+	caches, err := i.Caches()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	list := caches.List()
+	if name := flag.Arg(1); name != "" {
+		c, ok := caches.Get(name)
+		if !ok {
+			log.Fatalln("cache '" + name + "' does not exist")
+		}
+		list = []cache.Cache{c}
+	}
+
+	switch flag.Arg(0) {
+	case "", "stats":
+		var statuses []api.CacheStatus
+		var rows [][]string
+		for _, c := range list {
+			files, size, err := c.Stats()
+			status := api.CacheStatus{Name: c.Name, Dir: absPath(c.Dir), Target: c.Target, Files: files, Size: size}
+			if err != nil {
+				status.Error = err.Error()
+				if opts.format == formatTable {
+					log.Println(c.Name+":", err)
+				}
+			}
+			statuses = append(statuses, status)
+			rows = append(rows, []string{c.Name, c.Target, strconv.Itoa(files), formatSize(size)})
+		}
+		switch opts.format {
+		case formatJSON:
+			if statuses == nil {
+				statuses = []api.CacheStatus{}
+			}
+			printJSON(statuses)
+		case formatPlain:
+			printPlain(rows)
+		default:
+			printTable([]string{"CACHE", "MOUNT POINT", "FILES", "SIZE"}, rows)
+		}
+	case "clear":
+		d.SECTION("Clear Caches")
+		for _, c := range list {
+			d.ITEM("clear " + c.Name)
+			d.ERR(c.Clear())
+		}
+	default:
+		log.Fatalln("unknown cache command: " + flag.Arg(0))
+	}
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/AOSC-Dev/ciel/config"
 	d "github.com/AOSC-Dev/ciel/display"
 	"github.com/AOSC-Dev/ciel/internal/ciel"
+	api "github.com/AOSC-Dev/ciel/pkg/ciel"
 )
 
 var rawArgs []string
@@ -34,68 +35,37 @@ func main() {
 	router(subCmd)
 }
 
-func parse() {
-	flag.CommandLine.Parse(rawArgs)
-}
-
 func router(subCmd string) {
-	switch subCmd {
-	case "version":
-		fmt.Println("Ciel", config.Version)
-		return
-	case "help":
-		docHelp()
-		return
+	if subCmd == "" {
+		subCmd = "list"
 	}
-	var routeTable = map[string]func(){
-		// Create Directory Structures
-		"init":     initCiel, // here
-		"farewell": farewell, // here
-
-		// Preparing and Removing Instance
-		"add":           add,          // instances.go
-		"load-os":       untarGuestOS, // guest_os.go
-		"factory-reset": factoryReset, // guest_os.go
-		"update-os":     update,       // guest_os.go
-		"rollback":      rollback,     // instances.go
-		"commit":        commit,       // instances.go
-		"del":           del,          // instances.go
-
-		// Maintaining Instance Status
-		"mount": mountCiel, // mount_points.go
-		"stop":  stop,      // instances.go
-		"down":  shutdown,  // mount_points.go
-		"list":  list,      // status.go
-		"":      list,      // status.go
-
-		// Executing Commands
-		"shell": shell, // instances.go
-		"run":   run,   // instances.go
-
-		// Building Configuration
-		"load-tree":   clone,       // tree.go
-		"update-tree": pull,        // tree.go
-		"config":      buildConfig, // build.go
-
-		// Building
-		"build": build, // build.go
-
-		"doctor": doctor, // doctor.go
+	c := findCommand(subCmd)
+	if c == nil {
+		log.Fatalln("unknown command: " + subCmd)
 	}
-	requireEUID0()
-	route, exists := routeTable[subCmd]
-	if exists {
-		route()
-		return
+	if !c.NoRoot && !(c.Remote && daemonSocket() != "") && !helpRequested() {
+		requireEUID0()
 	}
-	plugins := getPlugins()
-	for _, pluginItem := range plugins {
-		if subCmd == pluginItem.Name {
-			plugin(subCmd)
-			return
+	flag.CommandLine.Usage = func() {
+		printCommandHelp(flag.CommandLine.Output(), c, flag.CommandLine)
+	}
+	global := defineFlags(flag.CommandLine, c)
+	flag.CommandLine.Parse(rawArgs)
+	global.apply()
+	c.Run()
+}
+
+// helpRequested reports whether -h is given, which needs no root.
+func helpRequested() bool {
+	for _, arg := range rawArgs {
+		switch arg {
+		case "--":
+			return false
+		case "-h", "-help", "--h", "--help":
+			return true
 		}
 	}
-	log.Fatalln("unknown command: " + subCmd)
+	return false
 }
 
 func requireEUID0() {
@@ -104,32 +74,70 @@ func requireEUID0() {
 	}
 }
 
+func version() {
+	fmt.Println("Ciel", config.Version)
+}
+
 func initCiel() {
-	basePath := flagCielDir()
-	parse()
+	if _, err := api.Init(opts.cielDir); err != nil {
+		log.Fatalln(err)
+	}
+}
 
-	i := &ciel.Ciel{BasePath: *basePath}
-	i.Init()
+var migrateOpts struct {
+	dryRun bool
 }
 
-func farewell() {
-	basePath := flagCielDir()
-	batchFlag := flagBatch()
-	parse()
+func migrateFlags(fs *flag.FlagSet) {
+	flagCielDir(fs)
+	fs.BoolVar(&migrateOpts.dryRun, "dry-run", false, "only show and check the steps")
+}
+
+func migrate() {
+	w := api.New(opts.cielDir)
+	pending, err := w.PendingMigrations()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if len(pending) == 0 {
+		d.Println("your Ciel work directory is up to date")
+		return
+	}
+	if migrateOpts.dryRun {
+		for _, m := range pending {
+			d.ITEM(m.String())
+			if err := w.CheckMigration(m); err != nil {
+				d.FAILED_BECAUSE(err.Error())
+				continue
+			}
+			d.OK()
+		}
+		return
+	}
+	if err := migrateAll(w, pending); err != nil {
+		os.Exit(1)
+	}
+}
 
-	i := &ciel.Ciel{BasePath: *basePath}
-	i.Check()
+func farewellFlags(fs *flag.FlagSet) {
+	flagCielDir(fs)
+	flagAnswer(fs, "delete-all", "delete the workspace without asking, required without a terminal")
+}
+
+func farewell() {
+	i := &ciel.Ciel{BasePath: opts.cielDir}
+	checkCiel(i)
 	c := i.Container()
 
-	if !*batchFlag && d.ASKLower("DELETE ALL CIEL THINGS?", "yes/no") != "yes" {
+	if !confirm(d.Question{Msg: "DELETE ALL CIEL THINGS?", Flag: "delete-all", Destructive: true}) {
 		os.Exit(1)
 	}
 
 	d.SECTION("Farewell To Thee (Good Bye)")
-	instList := c.GetAll()
+	instList := allInstances(c)
 	for _, inst := range instList {
 		d.SECTION("Shutdown Instance " + inst.Name)
-		inst.Unmount()
+		shutdownInstance(inst.Name, inst)
 	}
 	d.SECTION("DELETE .ciel DIRECTORY")
 	d.ITEM("delete")
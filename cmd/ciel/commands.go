@@ -0,0 +1,536 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Command is a subcommand of ciel. Its flags are defined by Flags, which
+// help and completions call too, and parsed before Run.
+type Command struct {
+	Name     string
+	Group    string
+	Usage    string // arguments after the name
+	Summary  string
+	Help     string // details, shown by ciel help NAME
+	Examples []string
+	Args     argKind  // to complete arguments
+	Words    []string // for argWords
+	NoRoot   bool     // runs without root
+	Remote   bool     // runs without root through the daemon, see CIEL_SOCKET
+	Flags    func(fs *flag.FlagSet)
+	Run      func()
+}
+
+// Kinds of arguments of commands.
+type argKind int
+
+const (
+	argNone argKind = iota
+	argInstances
+	argPackages
+	argFiles
+	argCommands
+	argWords
+)
+
+// Groups of commands, in the order of ciel help.
+const (
+	groupWorkspace = "Setting up workspaces"
+	groupInstance  = "Managing instances"
+	groupRun       = "Running commands"
+	groupBuild     = "Building"
+	groupService   = "Diagnosing and serving"
+	groupOther     = "Other"
+	groupPlugin    = "Plugins"
+)
+
+var groups = []string{groupWorkspace, groupInstance, groupRun, groupBuild, groupService, groupOther, groupPlugin}
+
+var commands []*Command
+
+func init() {
+	commands = []*Command{
+		{
+			Name:    "init",
+			Group:   groupWorkspace,
+			Summary: "create a workspace in the directory",
+			Help: `Machines of instances are named INSTANCE-ID, with the random ID of the
+workspace kept in .ciel/id.`,
+			Flags: flagCielDir,
+			Run:   initCiel,
+		},
+		{
+			Name:    "migrate",
+			Group:   groupWorkspace,
+			Usage:   "[-dry-run]",
+			Summary: "upgrade the workspace from an older version of Ciel",
+			Help: `Steps are applied one by one, backing up changed files in .ciel/backup.
+Other commands offer to migrate too, see -migrate.`,
+			Flags: migrateFlags,
+			Run:   migrate,
+		},
+		{
+			Name:    "load-os",
+			Usage:   "[TAR_FILE]",
+			Group:   groupWorkspace,
+			Summary: "unpack an OS tarball, or fetch the latest BuildKit",
+			Help: `Replacing an existing OS deletes all instances and the old OS, which
+-delete-instances and -delete-os confirm without a terminal.`,
+			Examples: []string{"ciel load-os aosc-os_buildkit_latest_amd64.tar.xz"},
+			Args:     argFiles,
+			Flags:    untarGuestOSFlags,
+			Run:      untarGuestOS,
+		},
+		{
+			Name:    "load-tree",
+			Usage:   "[GIT_URL]",
+			Group:   groupWorkspace,
+			Summary: "clone the package tree, AOSC OS ABBS by default",
+			Flags:   flagCielDir,
+			Run:     clone,
+		},
+		{
+			Name:    "update-os",
+			Usage:   "[-stop-instances] [-- APT_ARGS...]",
+			Group:   groupWorkspace,
+			Summary: "update the underlying OS, as apt-get update && apt-get dist-upgrade",
+			Help: `APT_ARGS are appended to apt-get dist-upgrade. APT runs with the host
+network unless -net is given.`,
+			Flags: updateFlags,
+			Run:   update,
+		},
+		{
+			Name:    "update-tree",
+			Group:   groupWorkspace,
+			Summary: "update the package tree, as git pull",
+			Flags:   flagCielDir,
+			Run:     pull,
+		},
+		{
+			Name:    "farewell",
+			Group:   groupWorkspace,
+			Summary: "delete all Ciel things, except OUTPUT, TREE and such",
+			Help: `Equals to 'ciel down && rm -r .ciel', which -delete-all confirms without a
+terminal.`,
+			Flags: farewellFlags,
+			Run:   farewell,
+		},
+
+		{
+			Name:    "list",
+			Usage:   "[-sizes] [-format table|plain|json]",
+			Group:   groupInstance,
+			Summary: "list instances, the default command",
+			Help: `-sizes measures the layers of instances. Plain output prints NAME,
+WORKDIR, STATUS, BOOT, NETWORK, LIMITS and SIZE separated by tabs.`,
+			Remote: true,
+			Flags:  listFlags,
+			Run:    list,
+		},
+		{
+			Name:    "add",
+			Usage:   "INSTANCE",
+			Group:   groupInstance,
+			Summary: "create an instance",
+			Flags:   flagCielDir,
+			Run:     add,
+		},
+		{
+			Name:    "del",
+			Usage:   "INSTANCE",
+			Group:   groupInstance,
+			Summary: "delete an instance",
+			Args:    argInstances,
+			Flags:   flagCielDir,
+			Run:     del,
+		},
+		{
+			Name:    "mount",
+			Usage:   "[-i INSTANCE]",
+			Group:   groupInstance,
+			Summary: "mount all or one instance",
+			Help: `TREE, OUTPUT, caches and extra bind mounts from "mounts" in
+.ciel/config.json and .ciel/container/instances/INSTANCE/config.json are
+mounted along with it.`,
+			Flags: instanceFlags,
+			Run:   mountCiel,
+		},
+		{
+			Name:    "down",
+			Usage:   "[-i INSTANCE]",
+			Group:   groupInstance,
+			Summary: "shut down and unmount all or one instance",
+			Flags:   instanceFlags,
+			Run:     shutdown,
+		},
+		{
+			Name:    "stop",
+			Usage:   "-i INSTANCE",
+			Group:   groupInstance,
+			Summary: "shut an instance down",
+			Flags:   instanceFlags,
+			Run:     stop,
+		},
+		{
+			Name:    "network",
+			Usage:   "-i INSTANCE [-port [tcp:|udp:]HOST[:CONTAINER]]... [-no-ports] [MODE]",
+			Group:   groupInstance,
+			Summary: "show or set the default network mode of an instance",
+			Help: `Ports are forwarded when it boots with a zone, veth or bridge network.
+Instances without a mode of their own use "network" of .ciel/config.json,
+then none.`,
+			Examples: []string{"ciel network -i main zone:ciel", "ciel network -i main -port 8080:80 veth"},
+			Flags:    networkFlags,
+			Run:      network,
+		},
+		{
+			Name:     "limits",
+			Usage:    "-i INSTANCE [-memory SIZE] [-cpus N] [-tasks-max N] [-io-weight W] [-reset]",
+			Group:    groupInstance,
+			Summary:  "show or set the default resource limits of an instance",
+			Examples: []string{"ciel limits -i main -memory 8G -cpus 4"},
+			Flags:    limitsFlags,
+			Run:      limits,
+		},
+		{
+			Name:    "rollback",
+			Usage:   "-i INSTANCE [-baseline NAME]",
+			Group:   groupInstance,
+			Summary: "discard the changes of an instance",
+			Flags:   rollbackFlags,
+			Run:     rollback,
+		},
+		{
+			Name:    "baseline",
+			Usage:   "-i INSTANCE [-d] [NAME]",
+			Group:   groupInstance,
+			Summary: "save the changes of an instance as a baseline, delete or list baselines",
+			Flags:   baselineFlags,
+			Run:     baseline,
+		},
+		{
+			Name:    "commit",
+			Usage:   "-i INSTANCE",
+			Group:   groupInstance,
+			Summary: "commit the changes of an instance onto the shared underlying OS",
+			Flags:   instanceFlags,
+			Run:     commit,
+		},
+		{
+			Name:    "factory-reset",
+			Usage:   "-i INSTANCE",
+			Group:   groupInstance,
+			Summary: "delete all files out of dpkg in an instance",
+			Flags:   instanceFlags,
+			Run:     factoryReset,
+		},
+
+		{
+			Name:    "shell",
+			Usage:   "-i INSTANCE [\"SHELL COMMAND LINE\"]",
+			Group:   groupRun,
+			Summary: "start an interactive shell, or run a command line in the login shell",
+			Remote:  true,
+			Flags:   containerFlags,
+			Run:     shell,
+		},
+		{
+			Name:    "run",
+			Usage:   "-i INSTANCE [-usage] ABSPATH_TO_EXE [ARG...]",
+			Group:   groupRun,
+			Summary: "run a command, without the login environment",
+			Help: `A lower-level version of shell, without sourcing ~/.bash_profile. -usage
+shows the peak memory, CPU time, IO and wall time of the command.`,
+			Examples: []string{"ciel run -i main /usr/bin/uname -a"},
+			Remote:   true,
+			Flags:    runFlags,
+			Run:      run,
+		},
+
+		{
+			Name:    "config",
+			Usage:   "(-i INSTANCE | -g) [-maintainer INFO] [-disable-dnssec] [-edit-sources] [-local-repo]",
+			Group:   groupBuild,
+			Summary: "configure the system and toolchain for building, interactively",
+			Help: `The flags answer the questions, each of them may be =false to answer
+no.`,
+			Flags: buildConfigFlags,
+			Run:   buildConfig,
+		},
+		{
+			Name:    "build",
+			Usage:   "-i INSTANCE[,INSTANCE...] [FLAGS] PACKAGE...",
+			Group:   groupBuild,
+			Summary: "build packages with acbs-build",
+			Help: `Artifacts are put in OUTPUT/pool/SUITE, with all SUITEs of -suite visible
+to APT. A JSON report is written beside the build log in OUTPUT/logs, with
+the peak memory, CPU time, IO and wall time of the build.
+
+Several packages are built one by one in dependency order, and -report
+writes all reports as a JSON array. -parallel N builds the queue in N
+instances at once, creating temporary ones from the first INSTANCE if
+fewer are given.
+
+-clean and -clean-after roll the instance back to the underlying OS, or
+baseline NAME, before and after building, OUTPUT is preserved. -hermetic
+fetches sources and installs build dependencies with network (see -net,
+or "fetch_network"), then compiles in the instance rebooted without
+network, failing if it tries to reach it. Their defaults are read from
+"build" in .ciel/config.json.`,
+			Examples: []string{
+				"ciel build -i main bash",
+				"ciel build -i main -keep-going -queue list.txt",
+				"ciel build -i main -parallel 4 groups/base",
+				"ciel build -i main -clean -hermetic bash",
+			},
+			Args:   argPackages,
+			Remote: true,
+			Flags:  buildFlags,
+			Run:    build,
+		},
+		{
+			Name:    "logs",
+			Usage:   "[-last] [-follow] [-plain] [-format table|plain|json] [LOG]",
+			Group:   groupBuild,
+			Summary: "list build logs in OUTPUT/logs, or show one of them",
+			Flags:   logsFlags,
+			Run:     logs,
+		},
+		{
+			Name:    "cache",
+			Usage:   "[-format table|plain|json] [stats|clear] [NAME]",
+			Group:   groupBuild,
+			Summary: "show or clear the caches shared by all instances",
+			Help:    `Mount points are read from "caches" in .ciel/config.json.`,
+			Args:    argWords,
+			Words:   []string{"stats", "clear"},
+			Flags:   cacheFlags,
+			Run:     cacheCmd,
+		},
+
+		{
+			Name:    "doctor",
+			Usage:   "[-i INSTANCE] [-format table|plain|json]",
+			Group:   groupService,
+			Summary: "diagnose problems, showing the holders of locks and sizes of layers",
+			Help:    `Plain output prints INSTANCE, KEY and VALUE separated by tabs.`,
+			Flags:   doctorFlags,
+			Run:     doctor,
+		},
+		{
+			Name:    "daemon",
+			Usage:   "[-socket PATH] [-group NAME]",
+			Group:   groupService,
+			Summary: "serve the workspace on a Unix socket",
+			Help: `The socket is .ciel/daemon.sock by default. Members of group NAME can
+list instances, run commands and queue builds. With CIEL_SOCKET set to the
+socket, list, run, shell COMMAND and build go through it without root.
+Commands run as root in the instances, so members of the group are as
+powerful as root; they may only choose the network mode none.`,
+			Examples: []string{"ciel daemon -group builders"},
+			Flags:    daemonFlags,
+			Run:      daemonCmd,
+		},
+
+		{
+			Name:    "version",
+			Group:   groupOther,
+			Summary: "show the version of Ciel",
+			NoRoot:  true,
+			Run:     version,
+		},
+		{
+			Name:    "help",
+			Usage:   "[COMMAND]",
+			Group:   groupOther,
+			Summary: "show the commands, or the flags and details of one",
+			Args:    argCommands,
+			NoRoot:  true,
+			Run:     help,
+		},
+		{
+			Name:     "completion",
+			Usage:    "bash|zsh|fish",
+			Group:    groupOther,
+			Summary:  "generate the completion script of a shell",
+			Examples: []string{"ciel completion bash > /usr/share/bash-completion/completions/ciel"},
+			Args:     argWords,
+			Words:    []string{"bash", "zsh", "fish"},
+			NoRoot:   true,
+			Run:      completion,
+		},
+	}
+}
+
+// findCommand returns the command, or the plugin, named name.
+func findCommand(name string) *Command {
+	for _, c := range commands {
+		if c.Name == name {
+			return c
+		}
+	}
+	for _, c := range pluginCommands() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// pluginCommands are the installed plugins.
+func pluginCommands() []*Command {
+	plugins, _ := getPlugins()
+	var list []*Command
+	for _, p := range plugins {
+		name := p.Name
+		list = append(list, &Command{
+			Name:    name,
+			Usage:   "[ARG...]",
+			Group:   groupPlugin,
+			Summary: "plugin " + filepath.Join(PluginDir, PluginPrefix+name),
+			Flags:   pluginFlags,
+			Run:     func() { os.Exit(plugin(name)) },
+		})
+	}
+	return list
+}
+
+// defineFlags defines the flags of the command in fs, along with the ones
+// for the display and questions which all commands take.
+func defineFlags(fs *flag.FlagSet, c *Command) *globalOptions {
+	global := flagGlobal(fs)
+	if c.Flags != nil {
+		c.Flags(fs)
+	}
+	return global
+}
+
+// describe returns the flags of the command.
+func describe(c *Command) *flag.FlagSet {
+	fs := flag.NewFlagSet("ciel "+c.Name, flag.ContinueOnError)
+	defineFlags(fs, c)
+	return fs
+}
+
+// globalFlags are the flags which all commands take.
+func globalFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet("ciel", flag.ContinueOnError)
+	flagGlobal(fs)
+	return fs
+}
+
+// commandFlags are the flags of the command, without the global ones.
+func commandFlags(fs *flag.FlagSet) *flag.FlagSet {
+	global := globalFlags()
+	own := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	fs.VisitAll(func(f *flag.Flag) {
+		if global.Lookup(f.Name) == nil {
+			own.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	return own
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func printFlags(w io.Writer, fs *flag.FlagSet) {
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+}
+
+// printCommandHelp shows the usage, details, flags and examples of the
+// command, with the flags it has defined.
+func printCommandHelp(w io.Writer, c *Command, fs *flag.FlagSet) {
+	fmt.Fprintln(w, "Usage: ciel", strings.TrimSpace(c.Name+" "+c.Usage))
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, strings.ToUpper(c.Summary[:1])+c.Summary[1:]+".")
+	if c.Help != "" {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, c.Help)
+	}
+	own := commandFlags(fs)
+	hasFlags := false
+	own.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Flags:")
+		printFlags(w, own)
+	}
+	if len(c.Examples) != 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Examples:")
+		for _, e := range c.Examples {
+			fmt.Fprintln(w, "  "+e)
+		}
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Global flags are shown by 'ciel help'.")
+}
+
+// printHelp shows the commands by group, and the global flags.
+func printHelp(w io.Writer) {
+	fmt.Fprintln(w, "Usage: ciel COMMAND [FLAGS] [ARGS]")
+	all := append(append([]*Command{}, commands...), pluginCommands()...)
+	width := 0
+	for _, c := range all {
+		if len(c.Name) > width {
+			width = len(c.Name)
+		}
+	}
+	for _, group := range groups {
+		printed := false
+		for _, c := range all {
+			if c.Group != group {
+				continue
+			}
+			if !printed {
+				fmt.Fprintln(w)
+				fmt.Fprintln(w, group+":")
+				printed = true
+			}
+			fmt.Fprintf(w, "  %-*s  %s\n", width, c.Name, c.Summary)
+		}
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Global flags:")
+	printFlags(w, globalFlags())
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Most commands also take -C DIR for the workspace (CIEL_DIR) and -i INSTANCE
+(CIEL_INST). Without -batch, -yes or -assume-no, questions fail instead of
+waiting if stdin is not a terminal. Destructive questions are only answered
+by their own flags, even in batch mode or with -yes.
+
+Run 'ciel help COMMAND' or 'ciel COMMAND -h' for the flags of a command.`)
+}
+
+func help() {
+	if flag.NArg() == 0 {
+		printHelp(os.Stdout)
+		return
+	}
+	c := findCommand(flag.Arg(0))
+	if c == nil {
+		fmt.Fprintln(os.Stderr, "unknown command: "+flag.Arg(0))
+		os.Exit(1)
+	}
+	printCommandHelp(os.Stdout, c, describe(c))
+}
+
+// commandNames are the names of the commands, sorted.
+func commandNames() []string {
+	var names []string
+	for _, c := range commands {
+		names = append(names, c.Name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestCommandFlags(t *testing.T) {
+	usageFlag := regexp.MustCompile(`(?:^|[\s\[(|])-([a-z][a-z-]*)`)
+	for _, c := range commands {
+		fs := describe(c)
+		if fs.Lookup("color") == nil {
+			t.Errorf("%s: no global flags", c.Name)
+		}
+		for _, m := range usageFlag.FindAllStringSubmatch(c.Usage, -1) {
+			if fs.Lookup(m[1]) == nil {
+				t.Errorf("%s: -%s in usage is not defined", c.Name, m[1])
+			}
+		}
+	}
+}
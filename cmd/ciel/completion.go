@@ -0,0 +1,303 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/AOSC-Dev/ciel/systemd-api/nspawn"
+)
+
+// Kinds of values of flags, besides the ones of arguments.
+const argDirs argKind = -1
+
+// flagValue tells what the value of a flag completes to, with the words for
+// argWords. Bool flags take no value.
+func flagValue(f *flag.Flag) (kind argKind, words []string) {
+	switch f.Name {
+	case "i":
+		return argInstances, nil
+	case "C":
+		return argDirs, nil
+	case "report", "queue", "socket":
+		return argFiles, nil
+	case "format":
+		return argWords, []string{formatTable, formatPlain, formatJSON}
+	case "color":
+		return argWords, []string{"auto", "always", "never"}
+	case "net":
+		return argWords, []string{nspawn.NetworkHost, nspawn.NetworkNone, nspawn.NetworkVeth,
+			nspawn.NetworkZone + ":" + nspawn.DefaultZone}
+	}
+	return argNone, nil
+}
+
+// commandFlagList returns the flags of the command, global ones included,
+// sorted by name.
+func commandFlagList(c *Command) []*flag.Flag {
+	var list []*flag.Flag
+	describe(c).VisitAll(func(f *flag.Flag) {
+		list = append(list, f)
+	})
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// flagSummary is the first line of the usage of a flag.
+func flagSummary(f *flag.Flag) string {
+	_, usage := flag.UnquoteUsage(f)
+	return strings.SplitN(usage, "\n", 2)[0]
+}
+
+func completion() {
+	switch flag.Arg(0) {
+	case "bash":
+		completeBash(os.Stdout)
+	case "zsh":
+		completeZsh(os.Stdout)
+	case "fish":
+		completeFish(os.Stdout)
+	default:
+		log.Fatalln("unknown shell '" + flag.Arg(0) + "', expecting bash, zsh or fish")
+	}
+}
+
+// Shell functions listing instances, plugins and packages, as they are
+// used by the completions of all shells.
+const (
+	listInstances = `dir="${CIEL_DIR:-.}/.ciel/container/instances"; [ -d "$dir" ] && find "$dir" -maxdepth 1 -mindepth 1 -type d -printf '%f\n'`
+	listPackages  = `tree="${CIEL_DIR:-.}/TREE"; [ -d "$tree" ] && { find "$tree/groups" -maxdepth 1 -mindepth 1 -type f -printf 'groups/%f\n' 2>/dev/null; find "$tree" -maxdepth 2 -mindepth 2 -type d -not -path "$tree/.git" -printf '%f\n'; }`
+)
+
+func listPlugins() string {
+	dir := filepath.Clean(PluginDir)
+	return `[ -d '` + dir + `' ] && find '` + dir + `' -maxdepth 1 -mindepth 1 -name '` + PluginPrefix + `*' -printf '%f\n' | sed 's/^` + PluginPrefix + `//'`
+}
+
+func completeBash(w io.Writer) {
+	fmt.Fprintf(w, `# bash completion for ciel, generated by 'ciel completion bash'
+
+_ciel_instances() { sh -c '%s'; }
+_ciel_plugins() { sh -c '%s'; }
+_ciel_packages() { sh -c '%s'; }
+
+_ciel() {
+    local cur prev words cword
+    _init_completion || return
+
+    local commands="%s $(_ciel_plugins)"
+    if [[ $cword -eq 1 ]]; then
+        COMPREPLY=($(compgen -W "$commands" -- "$cur"))
+        return
+    fi
+
+`, shellQuoteInner(listInstances), shellQuoteInner(listPlugins()), shellQuoteInner(listPackages),
+		strings.Join(commandNames(), " "))
+
+	// values of flags
+	values := make(map[string]string)
+	for _, c := range commands {
+		for _, f := range commandFlagList(c) {
+			if isBoolFlag(f) {
+				continue
+			}
+			kind, words := flagValue(f)
+			values["-"+f.Name] = bashCompgen(kind, words)
+		}
+	}
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, `    case "$prev" in`)
+	for _, name := range names {
+		fmt.Fprintf(w, "    %s)\n        %s\n        return\n        ;;\n", name, values[name])
+	}
+	fmt.Fprint(w, "    esac\n\n")
+
+	fmt.Fprintln(w, `    local flags`)
+	fmt.Fprintln(w, `    case "${words[1]}" in`)
+	for _, c := range commands {
+		var flags []string
+		for _, f := range commandFlagList(c) {
+			flags = append(flags, "-"+f.Name)
+		}
+		fmt.Fprintf(w, "    %s)\n        flags=\"%s\"\n        ;;\n", c.Name, strings.Join(flags, " "))
+	}
+	fmt.Fprint(w, "    *)\n        _filedir\n        return\n        ;;\n    esac\n\n")
+
+	fmt.Fprint(w, `    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "$flags" -- "$cur"))
+        return
+    fi
+
+    case "${words[1]}" in
+`)
+	for _, c := range commands {
+		if c.Args == argNone {
+			continue
+		}
+		fmt.Fprintf(w, "    %s)\n        %s\n        ;;\n", c.Name, bashCompgen(c.Args, c.Words))
+	}
+	fmt.Fprint(w, `    esac
+}
+
+complete -F _ciel ciel
+`)
+}
+
+func bashCompgen(kind argKind, words []string) string {
+	switch kind {
+	case argInstances:
+		return `COMPREPLY=($(compgen -W "$(_ciel_instances)" -- "$cur"))`
+	case argPackages:
+		return `COMPREPLY=($(compgen -W "$(_ciel_packages)" -- "$cur"))`
+	case argFiles:
+		return `_filedir`
+	case argDirs:
+		return `_filedir -d`
+	case argCommands:
+		return `COMPREPLY=($(compgen -W "$commands" -- "$cur"))`
+	case argWords:
+		return `COMPREPLY=($(compgen -W "` + strings.Join(words, " ") + `" -- "$cur"))`
+	}
+	return `COMPREPLY=()`
+}
+
+func completeZsh(w io.Writer) {
+	fmt.Fprintf(w, `#compdef ciel
+# zsh completion for ciel, generated by 'ciel completion zsh'
+
+_ciel_instances() { compadd -- ${(f)"$(sh -c '%s')"} }
+_ciel_plugins() { print -rl -- ${(f)"$(sh -c '%s')"} }
+_ciel_packages() { compadd -- ${(f)"$(sh -c '%s')"} }
+_ciel_commands() {
+    local -a commands
+    commands=(
+`, shellQuoteInner(listInstances), shellQuoteInner(listPlugins()), shellQuoteInner(listPackages))
+	for _, c := range commands {
+		fmt.Fprintf(w, "        %s\n", zshQuote(c.Name+":"+c.Summary))
+	}
+	fmt.Fprint(w, `    )
+    commands+=(${(f)"$(_ciel_plugins)"})
+    _describe 'command' commands
+}
+
+_ciel() {
+    if (( CURRENT == 2 )); then
+        _ciel_commands
+        return
+    fi
+    local cmd=$words[2]
+    shift words
+    (( CURRENT-- ))
+    case $cmd in
+`)
+	for _, c := range commands {
+		fmt.Fprintf(w, "    %s)\n        _arguments \\\n", c.Name)
+		for _, f := range commandFlagList(c) {
+			summary := strings.NewReplacer("[", "(", "]", ")").Replace(flagSummary(f))
+			spec := "-" + f.Name + "[" + summary + "]"
+			if !isBoolFlag(f) {
+				kind, words := flagValue(f)
+				spec += ":" + f.Name + ":" + zshAction(kind, words)
+			}
+			fmt.Fprintf(w, "            %s \\\n", zshQuote(spec))
+		}
+		fmt.Fprintf(w, "            %s\n        ;;\n", zshQuote("*:argument:"+zshAction(c.Args, c.Words)))
+	}
+	fmt.Fprint(w, `    *)
+        _files
+        ;;
+    esac
+}
+
+_ciel "$@"
+`)
+}
+
+func zshAction(kind argKind, words []string) string {
+	switch kind {
+	case argInstances:
+		return "_ciel_instances"
+	case argPackages:
+		return "_ciel_packages"
+	case argFiles:
+		return "_files"
+	case argDirs:
+		return "_files -/"
+	case argCommands:
+		return "_ciel_commands"
+	case argWords:
+		return "(" + strings.Join(words, " ") + ")"
+	}
+	return " "
+}
+
+func zshQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func completeFish(w io.Writer) {
+	fmt.Fprintf(w, `# fish completion for ciel, generated by 'ciel completion fish'
+
+function __ciel_instances; sh -c '%s'; end
+function __ciel_plugins; sh -c '%s'; end
+function __ciel_packages; sh -c '%s'; end
+
+complete -c ciel -f
+complete -c ciel -n __fish_use_subcommand -a '(__ciel_plugins)' -d plugin
+`, fishQuoteInner(listInstances), fishQuoteInner(listPlugins()), fishQuoteInner(listPackages))
+	for _, c := range commands {
+		fmt.Fprintf(w, "complete -c ciel -n __fish_use_subcommand -a %s -d %s\n", c.Name, fishQuote(c.Summary))
+	}
+	for _, c := range commands {
+		cond := fishQuote("__fish_seen_subcommand_from " + c.Name)
+		fmt.Fprintln(w)
+		for _, f := range commandFlagList(c) {
+			line := "complete -c ciel -n " + cond + " -o " + f.Name
+			if !isBoolFlag(f) {
+				line += " " + fishValue(flagValue(f))
+			}
+			fmt.Fprintln(w, line+" -d "+fishQuote(flagSummary(f)))
+		}
+		if c.Args != argNone {
+			fmt.Fprintf(w, "complete -c ciel -n %s %s\n", cond, fishValue(c.Args, c.Words))
+		}
+	}
+}
+
+func fishValue(kind argKind, words []string) string {
+	switch kind {
+	case argInstances:
+		return "-x -a '(__ciel_instances)'"
+	case argPackages:
+		return "-x -a '(__ciel_packages)'"
+	case argFiles, argDirs:
+		return "-r -F"
+	case argCommands:
+		return "-x -a " + fishQuote(strings.Join(commandNames(), " ")+" (__ciel_plugins)")
+	case argWords:
+		return "-x -a " + fishQuote(strings.Join(words, " "))
+	}
+	return "-x"
+}
+
+func fishQuote(s string) string {
+	return "'" + fishQuoteInner(s) + "'"
+}
+
+func fishQuoteInner(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
+
+// shellQuoteInner escapes s to be put in single quotes of sh, bash or zsh.
+func shellQuoteInner(s string) string {
+	return strings.Replace(s, "'", `'\''`, -1)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"os/user"
+	"path"
+	"strconv"
+	"syscall"
+	"time"
+
+	d "github.com/AOSC-Dev/ciel/display"
+	"github.com/AOSC-Dev/ciel/pkg/daemon"
+)
+
+// daemonSocket is the socket of the daemon to go through, empty to use the
+// workspace directly.
+func daemonSocket() string {
+	return os.Getenv("CIEL_SOCKET")
+}
+
+var daemonOpts struct {
+	socket, group string
+}
+
+func daemonFlags(fs *flag.FlagSet) {
+	flagCielDir(fs)
+	fs.StringVar(&daemonOpts.socket, "socket", "", "listen on `path`, defaults to "+daemon.DefaultSocketName+" in the work directory")
+	fs.StringVar(&daemonOpts.group, "group", "", "allow members of group `name` to use the daemon, besides root")
+}
+
+func daemonCmd() {
+	socket, group := daemonOpts.socket, daemonOpts.group
+	w := openWorkspace(opts.cielDir)
+	if socket == "" {
+		socket = path.Join(w.Dir(), daemon.DefaultSocketName)
+	}
+	gid := -1
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+
+	l, err := daemon.Listen(socket, gid)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	s := daemon.NewServer(w, gid).HTTPServer()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		s.Shutdown(context.Background())
+	}()
+	d.ITEM("listening")
+	d.Println(d.C(d.CYAN, socket))
+	err = s.Serve(l)
+	os.Remove(socket)
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatalln(err)
+	}
+}
+
+// runRemote runs a command through the daemon, exiting with its status.
+func runRemote(socket string, req daemon.RunRequest) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+	exitStatus, err := daemon.NewClient(socket).Run(ctx, req, os.Stdout, os.Stderr)
+	if err != nil {
+		log.Println(err)
+	}
+	os.Exit(exitStatus)
+}
+
+// buildPollInterval is how often the status of a build is polled once its
+// events are no longer followed.
+const buildPollInterval = time.Second
+
+// waitBuild polls the daemon until the build finishes.
+func waitBuild(client *daemon.Client, id int) error {
+	for {
+		b, err := client.Build(id)
+		if err != nil {
+			return err
+		}
+		if b.Status == daemon.BuildSucceeded || b.Status == daemon.BuildFailed {
+			var err error
+			if b.Error != "" {
+				err = daemon.Error{Message: b.Error}
+			}
+			note("finish build", err, b.Status)
+			return nil
+		}
+		time.Sleep(buildPollInterval)
+	}
+}
+
+// buildRemote queues a build in the daemon and shows its steps, exiting
+// with the status of the build.
+func buildRemote(socket string, req daemon.BuildRequest, reportPath string) {
+	client := daemon.NewClient(socket)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Subscribe before queuing, so that no event of the build is missed.
+	events := make(chan daemon.Event, 64)
+	errc := make(chan error, 1)
+	ready := make(chan struct{})
+	go func() {
+		errc <- client.Events(ctx, func() { close(ready) }, func(e daemon.Event) bool {
+			select {
+			case events <- e:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+	select {
+	case <-ready:
+	case err := <-errc:
+		log.Fatalln(err)
+	}
+
+	b, err := client.QueueBuild(req)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	note("queue build", nil, "#"+strconv.Itoa(b.ID)+" in "+req.Instance)
+wait:
+	for {
+		select {
+		case <-errc:
+			// the daemon closes the events of clients not keeping up,
+			// the build goes on
+			if err := waitBuild(client, b.ID); err != nil {
+				log.Fatalln(err)
+			}
+			break wait
+		case e := <-events:
+			if e.Build != b.ID {
+				continue
+			}
+			var err error
+			if e.Error != "" {
+				err = daemon.Error{Message: e.Error}
+			}
+			switch e.Type {
+			case daemon.EventBuildStarted:
+				note("start build", nil, "#"+strconv.Itoa(b.ID))
+			case daemon.EventBuildStep:
+				note(e.Step, err, e.Detail)
+			case daemon.EventBuildFinished:
+				note("finish build", err, e.Detail)
+				break wait
+			}
+		}
+	}
+
+	b, err = client.Build(b.ID)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if b.Report == nil {
+		os.Exit(1)
+	}
+	if b.Report.Usage != nil {
+		note("resource usage", nil, formatUsage(*b.Report.Usage))
+	}
+	if reportPath != "" {
+		d.ITEM("save build report")
+		d.ERR(b.Report.Save(reportPath))
+	}
+	if b.Status != daemon.BuildSucceeded {
+		if b.Report.ExitStatus != 0 {
+			os.Exit(b.Report.ExitStatus)
+		}
+		os.Exit(1)
+	}
+}
@@ -1,92 +1,177 @@
 package main
 
 import (
-	"context"
+	"flag"
+	"log"
+	"path/filepath"
 	"strconv"
+	"strings"
 
 	d "github.com/AOSC-Dev/ciel/display"
-	"github.com/AOSC-Dev/ciel/internal/ciel"
-	"github.com/AOSC-Dev/ciel/internal/container/instance"
+	api "github.com/AOSC-Dev/ciel/pkg/ciel"
+	"github.com/AOSC-Dev/ciel/systemd-api/machined"
 	"github.com/AOSC-Dev/ciel/systemd-api/nspawn"
 )
 
-func doctor() {
-	basePath := flagCielDir()
-	instName := flagInstance()
-	parse()
+func doctorFlags(fs *flag.FlagSet) {
+	instanceFlags(fs)
+	flagFormat(fs)
+}
 
-	i := &ciel.Ciel{BasePath: *basePath}
-	i.Check()
-	c := i.Container()
+func doctor() {
+	checkFormat(opts.format)
 
-	var instList []*instance.Instance
-	if *instName == "" {
-		instList = c.GetAll()
-	} else {
-		c.CheckInst(*instName)
-		instList = []*instance.Instance{c.Instance(*instName)}
+	w := openWorkspace(opts.cielDir)
+	if opts.instName != "" {
+		openInstance(w, opts.instName)
 	}
-	for _, inst := range instList {
-		d.SECTION("Status of " + inst.Name)
-
-		d.ITEM("SEMAPHORES")
-		fl, flErr := inst.FileSystemLock().Get()
-		rl, rlErr := inst.RunLock().Get()
-		flName := "file@" + strconv.FormatUint(uint64(inst.FileSystemLock().S), 16)
-		rlName := "run@" + strconv.FormatUint(uint64(inst.RunLock().S), 16)
-		if flErr == nil && rlErr == nil && fl <= 1 && rl <= 1 {
-			d.Print(d.C0(switchColor(fl == 0), flName))
-			d.Print(" ")
-			d.Print(d.C0(switchColor(rl == 0), rlName))
-			d.Println()
-		} else {
-			d.Println()
-			d.ITEM(flName)
-			if flErr != nil {
-				d.Println(d.C(d.RED, flErr.Error()))
-			} else {
-				d.Println(d.C(d.RED, strconv.Itoa(fl)))
+	status, err := w.Status(api.StatusOptions{Sizes: true, System: true})
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if opts.instName != "" {
+		for _, s := range status.Instances {
+			if s.Name == opts.instName {
+				status.Instances = []api.Status{s}
+				break
 			}
-			d.ITEM(rlName)
-			if rlErr != nil {
-				d.Println(d.C(d.RED, rlErr.Error()))
-			} else {
-				d.Println(d.C(d.RED, strconv.Itoa(rl)))
+		}
+	}
+
+	switch opts.format {
+	case formatJSON:
+		printJSON(status)
+	case formatPlain:
+		printPlain(doctorRows(status))
+	default:
+		printDoctor(status)
+	}
+}
+
+func printDoctor(status *api.WorkspaceStatus) {
+	for _, s := range status.Instances {
+		d.SECTION("Status of " + s.Name)
+
+		d.ITEM("LOCKS")
+		for _, l := range s.Locks {
+			d.Print(d.C0(switchColor(l.Holder != nil), l.Name), " ")
+		}
+		d.Println()
+		for _, l := range s.Locks {
+			if l.Holder != nil {
+				d.ITEM(l.Name)
+				d.Println(d.C(d.YELLOW, "held by "+formatHolder(l.Holder)))
 			}
 		}
 
 		d.ITEM("OVERLAYFS")
-		d.Println(d.C0(switchColor(inst.Mounted()), "mounted"))
+		d.Println(d.C0(switchColor(s.Mounted), "mounted"))
+		d.ITEM("LAYERS")
+		for _, layer := range s.Layers {
+			d.Print(d.C0(d.WHITE, layer.Name+" "+formatLayerSize(layer)), " ")
+		}
+		d.Println()
 
 		d.ITEM("CONTAINER")
-		d.Print(d.C0(switchColor(inst.Running()), "running"))
+		d.Print(d.C0(switchColor(s.Running), "running"))
 		d.Print(" ")
-		d.Print(d.C0(switchColor(inst.RunningAsBootMode()), "bootMode"))
+		d.Print(d.C0(switchColor(s.BootMode == api.BootModeBoot), "bootMode"))
 		d.Print(" ")
-		d.Print(d.C0(switchColor(inst.RunningAsExclusiveMode()), "simpleMode"))
+		d.Print(d.C0(switchColor(s.BootMode == api.BootModeExclusive), "simpleMode"))
 		d.Println()
 
+		d.ITEM("LIMITS")
+		d.Println(d.C(d.WHITE, s.Limits.String()))
+
+		d.ITEM("MACHINE")
+		if m := s.Machine; m == nil {
+			d.Println(d.C(d.WHITE, "not registered"))
+		} else {
+			d.Print(d.C0(switchColor(m.State == machined.StateRunning), m.State))
+			d.Print(" ")
+			d.Print(d.C0(d.WHITE, m.Class))
+			d.Print(" ")
+			mountPoint, _ := filepath.Abs(filepath.Join(status.Dir, s.Name))
+			if m.Root == mountPoint {
+				d.Print(d.C0(d.CYAN, m.Root))
+			} else {
+				d.Print(d.C0(d.RED, m.Root))
+			}
+			d.Println()
+		}
+
 		d.ITEM("SYSTEMD")
-		st := nspawn.MachineStatus(context.Background(), inst.MachineId())
-		d.Print(d.C0(switchColor(nspawn.MachineDead(st)), "dead"))
-		d.Print(" ")
-		d.Print(d.C0(switchColor(nspawn.MachineRunning(st)), "running"))
-		d.Println()
-		d.ITEM("(raw str)")
-		lim := len(st) - 1 - 20
-		pf := "..."
-		if lim < 0 {
-			lim = 0
-			pf = ""
+		if s.Machine != nil && s.Machine.System != "" {
+			d.Println(d.C(switchColor(nspawn.MachineRunning(s.Machine.System)), s.Machine.System))
+		} else {
+			d.Println(d.C(d.WHITE, "unreachable"))
+		}
+
+		for _, e := range s.Errors {
+			d.ITEM("ERROR")
+			d.FAILED_BECAUSE(e)
+		}
+	}
+
+	d.SECTION("Workspace")
+	d.ITEM("underlying OS")
+	d.Println(d.C(d.WHITE, formatLayerSize(status.Dist)))
+	d.ITEM("stray machines")
+	if len(status.StrayMachines) == 0 {
+		d.Println(d.C(d.CYAN, "NO"))
+	} else {
+		d.Println(d.C(d.YELLOW, strings.Join(status.StrayMachines, " ")))
+	}
+	for _, e := range status.Errors {
+		d.ITEM("ERROR")
+		d.FAILED_BECAUSE(e)
+	}
+}
+
+// doctorRows are the fields of the status as rows of an instance (empty
+// for the workspace), a key and a value.
+func doctorRows(status *api.WorkspaceStatus) [][]string {
+	var rows [][]string
+	for _, s := range status.Instances {
+		add := func(key string, value string) {
+			rows = append(rows, []string{s.Name, key, value})
+		}
+		add("mounted", strconv.FormatBool(s.Mounted))
+		add("running", strconv.FormatBool(s.Running))
+		add("boot_mode", s.BootMode)
+		add("network", s.Network)
+		add("limits", s.Limits.String())
+		for _, l := range s.Locks {
+			var holder string
+			if l.Holder != nil {
+				holder = formatHolder(l.Holder)
+			}
+			add("lock."+l.Name, holder)
+		}
+		for _, layer := range s.Layers {
+			add("layer."+layer.Name, strconv.FormatInt(layer.Size, 10))
+		}
+		if m := s.Machine; m != nil {
+			add("machine.state", m.State)
+			add("machine.system", m.System)
+		}
+		for _, e := range s.Errors {
+			add("error", e)
 		}
-		d.Println(d.C(d.WHITE, pf+st[lim:]))
 	}
+	rows = append(rows, []string{"", "dist", strconv.FormatInt(status.Dist.Size, 10)})
+	for _, m := range status.StrayMachines {
+		rows = append(rows, []string{"", "stray_machine", m})
+	}
+	for _, e := range status.Errors {
+		rows = append(rows, []string{"", "error", e})
+	}
+	return rows
 }
 
-//func checkUname() {
-//	uname := syscall.Utsname{}
-//	err := syscall.Uname(&uname)
-//}
+func formatHolder(h *api.Holder) string {
+	return strconv.Itoa(h.PID) + " (" + h.Command + ")"
+}
 
 func switchColor(b bool) d.Color {
 	if b {
@@ -2,54 +2,273 @@ package main
 
 import (
 	"flag"
+	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	d "github.com/AOSC-Dev/ciel/display"
+	"github.com/AOSC-Dev/ciel/internal/packaging"
+	api "github.com/AOSC-Dev/ciel/pkg/ciel"
 	"github.com/AOSC-Dev/ciel/systemd-api/nspawn"
 )
 
-func flagCielDir() *string {
-	basePath := getEnv("CIEL_DIR", ".")
-	flag.StringVar(&basePath, "C", basePath, "Ciel work `directory`; CIEL_DIR")
-	return &basePath
+// opts holds the values of the flags shared by commands, defined by the
+// Flags of the command run.
+var opts struct {
+	cielDir   string
+	instName  string
+	network   string
+	limits    api.Limits
+	wait      time.Duration
+	noBooting bool
+	format    string
+	suite     string
+}
+
+func flagCielDir(fs *flag.FlagSet) {
+	opts.cielDir = getEnv("CIEL_DIR", ".")
+	fs.StringVar(&opts.cielDir, "C", opts.cielDir, "Ciel work `directory`; CIEL_DIR")
 }
 func saveCielDir(basePath string) {
 	saveEnv("CIEL_DIR", basePath)
 }
 
-func flagInstance() *string {
-	instName := getEnv("CIEL_INST", "")
-	flag.StringVar(&instName, "i", instName, "instance `name`; CIEL_INST")
-	return &instName
+// globalOptions are the flags which all commands take.
+type globalOptions struct {
+	color          *string
+	quiet, verbose *bool
+	batch, yes, no *bool
+}
+
+func flagGlobal(fs *flag.FlagSet) *globalOptions {
+	g := &globalOptions{color: flagColor(fs)}
+	g.quiet, g.verbose = flagVerbosity(fs)
+	g.batch, g.yes, g.no = flagAssumption(fs)
+	flagAnswer(fs, "migrate", "migrate the workspace if it is an older version, without asking")
+	return g
+}
+
+func (g *globalOptions) apply() {
+	if err := d.SetColorMode(*g.color); err != nil {
+		log.Fatalln(err)
+	}
+	saveColor(*g.color)
+	d.SetVerbosity(verbosity(*g.quiet, *g.verbose))
+	d.SetAssumption(assumption(*g.batch, *g.yes, *g.no))
+}
+
+func flagColor(fs *flag.FlagSet) *string {
+	color := getEnv("CIEL_COLOR", d.ColorAuto)
+	fs.StringVar(&color, "color", color, "color `mode`: auto, always or never, auto honors NO_COLOR; CIEL_COLOR")
+	return &color
+}
+func saveColor(color string) {
+	saveEnv("CIEL_COLOR", color)
+}
+
+func flagVerbosity(fs *flag.FlagSet) (quiet *bool, verbose *bool) {
+	quiet, verbose = new(bool), new(bool)
+	fs.BoolVar(quiet, "q", false, "only show failures; CIEL_VERBOSITY=quiet")
+	fs.BoolVar(verbose, "v", false, "also show commands run for the instances; CIEL_VERBOSITY=verbose")
+	return quiet, verbose
+}
+
+// verbosity takes the level from the flags, or CIEL_VERBOSITY.
+func verbosity(quiet bool, verbose bool) d.Verbosity {
+	if quiet && verbose {
+		log.Fatalln("-q and -v cannot be used together")
+	}
+	level := getEnv("CIEL_VERBOSITY", "normal")
+	switch {
+	case quiet:
+		level = "quiet"
+	case verbose:
+		level = "verbose"
+	}
+	saveEnv("CIEL_VERBOSITY", level)
+	switch level {
+	case "quiet":
+		return d.QUIET
+	case "verbose":
+		return d.VERBOSE
+	}
+	return d.NORMAL
+}
+
+// instanceFlags are the flags of commands on an instance.
+func instanceFlags(fs *flag.FlagSet) {
+	flagCielDir(fs)
+	flagInstance(fs)
+}
+
+// containerFlags are the flags of commands running in an instance.
+func containerFlags(fs *flag.FlagSet) {
+	instanceFlags(fs)
+	flagNetwork(fs)
+	flagLimits(fs)
+	flagNoBooting(fs)
+}
+
+func flagFormat(fs *flag.FlagSet) {
+	opts.format = formatTable
+	fs.StringVar(&opts.format, "format", opts.format, "output `format`: table, plain or json")
+}
+
+func flagInstance(fs *flag.FlagSet) {
+	opts.instName = getEnv("CIEL_INST", "")
+	fs.StringVar(&opts.instName, "i", opts.instName, "instance `name`; CIEL_INST")
 }
 func saveInstance(instName string) {
 	saveEnv("CIEL_INST", instName)
 }
 
-func flagNetwork() *bool {
-	network := getEnv("CIEL_NET", "false") == "true"
-	flag.BoolVar(&network, "net", network, "create a network zone; CIEL_NET")
-	return &network
+func flagNetwork(fs *flag.FlagSet) {
+	opts.network = getEnv("CIEL_NET", "")
+	if opts.network == "false" {
+		opts.network = ""
+	}
+	fs.Var((*networkValue)(&opts.network), "net", "network `mode`: host, none, zone:NAME, veth, bridge:BRIDGE or macvlan:IFACE,\n"+
+		"defaults to the one of the instance, then of the workspace, then "+nspawn.DefaultNetwork+"; CIEL_NET")
 }
-func saveNetwork(network bool) {
+func saveNetwork(network string) {
 	saveEnv("CIEL_NET", network)
 }
 
-func flagNoBooting() *bool {
-	noBooting := getEnv("CIEL_BOOT", "false") == "true"
-	flag.BoolVar(&noBooting, "n", noBooting, "do not boot the container; CIEL_BOOT")
-	return &noBooting
+// networkValue is a network mode, "true" still means the default zone as
+// the flag used to be a switch, but it takes a value now.
+type networkValue string
+
+func (v *networkValue) String() string { return string(*v) }
+func (v *networkValue) Set(s string) error {
+	if s == "false" {
+		s = ""
+	} else if _, err := nspawn.ParseNetwork(s); err != nil {
+		return err
+	}
+	*v = networkValue(s)
+	return nil
+}
+
+// flagLimits defines flags of resource limits, which override the defaults
+// of the instance.
+func flagLimits(fs *flag.FlagSet) {
+	limits := &opts.limits
+	fs.StringVar(&limits.Memory, "memory", limits.Memory, "limit the memory to `size`, as in 8G")
+	fs.Float64Var(&limits.CPUs, "cpus", limits.CPUs, "limit the CPU time to `N` CPUs")
+	fs.Uint64Var(&limits.TasksMax, "tasks-max", limits.TasksMax, "limit the number of tasks to `N`")
+	fs.Uint64Var(&limits.IOWeight, "io-weight", limits.IOWeight, "set the IO weight to `W`, 1 to 10000")
+}
+
+func flagWait(fs *flag.FlagSet) {
+	opts.wait, _ = time.ParseDuration(getEnv("CIEL_WAIT", "0s"))
+	fs.DurationVar(&opts.wait, "wait", opts.wait, "wait at most `duration` for an instance held by another process, as in 30s; CIEL_WAIT")
+}
+
+func flagNoBooting(fs *flag.FlagSet) {
+	opts.noBooting = getEnv("CIEL_BOOT", "false") == "true"
+	fs.BoolVar(&opts.noBooting, "n", opts.noBooting, "do not boot the container; CIEL_BOOT")
 }
 func saveNoBooting(noBooting bool) {
 	saveEnv("CIEL_BOOT", noBooting)
 }
 
-func flagBatch() *bool {
-	batch := getEnv("CIEL_BATCH_MODE", "false") == "true"
-	flag.BoolVar(&batch, "batch", batch, "do not ask; CIEL_BATCH_MODE")
-	return &batch
+func flagAssumption(fs *flag.FlagSet) (batch *bool, yes *bool, no *bool) {
+	batch, yes, no = new(bool), new(bool), new(bool)
+	fs.BoolVar(batch, "batch", false, "do not ask, taking the defaults; CIEL_BATCH_MODE")
+	fs.BoolVar(yes, "yes", false, "answer yes to questions which are not destructive; CIEL_ASSUME=yes")
+	fs.BoolVar(no, "assume-no", false, "answer no to all questions; CIEL_ASSUME=no")
+	return batch, yes, no
+}
+
+// assumption takes the answers to questions from the flags, or
+// CIEL_BATCH_MODE and CIEL_ASSUME.
+func assumption(batch bool, yes bool, no bool) d.Assumption {
+	given := 0
+	for _, b := range []bool{batch, yes, no} {
+		if b {
+			given++
+		}
+	}
+	if given > 1 {
+		log.Fatalln("-batch, -yes and -assume-no cannot be used together")
+	}
+	assume := getEnv("CIEL_ASSUME", "")
+	if getEnv("CIEL_BATCH_MODE", "false") == "true" {
+		assume = "batch"
+	}
+	switch {
+	case batch:
+		assume = "batch"
+	case yes:
+		assume = "yes"
+	case no:
+		assume = "no"
+	}
+	saveEnv("CIEL_BATCH_MODE", assume == "batch")
+	saveEnv("CIEL_ASSUME", assume)
+	switch assume {
+	case "batch":
+		return d.DEFAULTS
+	case "yes":
+		return d.YES
+	case "no":
+		return d.NO
+	}
+	return d.NOTHING
 }
-func saveBatch(batch bool) {
-	saveEnv("CIEL_BATCH_MODE", batch)
+
+// flagAnswer answers a yes or no question, see d.Question.
+func flagAnswer(fs *flag.FlagSet, name string, usage string) {
+	fs.Var(answerValue(name), name, usage)
+}
+
+// flagTextAnswer answers a prompt, see d.Prompt.
+func flagTextAnswer(fs *flag.FlagSet, name string, usage string) {
+	fs.Var(textAnswerValue(name), name, usage)
+}
+
+type answerValue string
+
+func (v answerValue) String() string   { return "" }
+func (v answerValue) IsBoolFlag() bool { return true }
+func (v answerValue) Set(s string) error {
+	yes, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	if yes {
+		d.SetAnswer(string(v), "yes")
+	} else {
+		d.SetAnswer(string(v), "no")
+	}
+	return nil
+}
+
+type textAnswerValue string
+
+func (v textAnswerValue) String() string { return "" }
+func (v textAnswerValue) Set(s string) error {
+	d.SetAnswer(string(v), s)
+	return nil
+}
+
+// confirm asks the question, exiting if it cannot be answered.
+func confirm(q d.Question) bool {
+	yes, err := d.CONFIRM(q)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return yes
+}
+
+func flagSuite(fs *flag.FlagSet) {
+	opts.suite = getEnv("CIEL_SUITE", packaging.DefaultSuite)
+	fs.StringVar(&opts.suite, "suite", opts.suite, "output `suite`, followed by comma-separated suites to use; CIEL_SUITE")
+}
+func saveSuite(suite string) {
+	saveEnv("CIEL_SUITE", suite)
 }
 
 func flagLocalRepo() *bool {
@@ -60,6 +279,18 @@ func saveLocalRepo(localRepo bool) {
 	saveEnv("CIEL_LOCAL_REPO", localRepo)
 }
 
+// flagPassed reports whether the flag is given on the command line, for
+// defaults coming from the workspace configuration.
+func flagPassed(name string) bool {
+	passed := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			passed = true
+		}
+	})
+	return passed
+}
+
 func getEnv(key, def string) string {
 	v, ok := os.LookupEnv(key)
 	if !ok {
@@ -80,18 +311,21 @@ func saveEnv(key string, value interface{}) {
 	}
 }
 
-func buildContainerInfo(boot bool, network bool) *nspawn.ContainerInfo {
+func buildContainerInfo(boot bool, network *nspawn.NetworkInfo, limits nspawn.Limits) *nspawn.ContainerInfo {
 	ci := &nspawn.ContainerInfo{
-		Init: boot,
-	}
-	if network {
-		ci.Network = &nspawn.NetworkInfo{
-			Zone: "ciel",
-		}
+		Init:       boot,
+		Properties: limits.Properties(),
+		Network:    network,
+		Trace:      traceCommand,
 	}
 	return ci
 }
 
+// traceCommand shows the command lines run for instances in verbose mode.
+func traceCommand(args []string) {
+	d.DEBUG(strings.Join(args, " "))
+}
+
 func buildRunInfo(args []string) *nspawn.RunInfo {
 	ri := &nspawn.RunInfo{
 		App: args[0],
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	d "github.com/AOSC-Dev/ciel/display"
+)
+
+// Output formats of inspection commands. The JSON schemas are the types of
+// pkg/ciel, which fields are only ever added to, and plain output is lines
+// of tab-separated fields without a header or colors.
+const (
+	formatTable = "table"
+	formatPlain = "plain"
+	formatJSON  = "json"
+)
+
+func checkFormat(format string) {
+	switch format {
+	case formatTable, formatPlain, formatJSON:
+	default:
+		log.Fatalln("unknown format '" + format + "', expecting table, plain or json")
+	}
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// printTable prints the rows under the header in aligned columns, cells
+// may be colored.
+func printTable(header []string, rows [][]string) {
+	stripColors(rows)
+	widths := make([]int, len(header))
+	for _, row := range append([][]string{header}, rows...) {
+		for i, cell := range row {
+			if l := d.EscLen(cell); l > widths[i] {
+				widths[i] = l
+			}
+		}
+	}
+	for _, row := range append([][]string{header}, rows...) {
+		var line string
+		for i, cell := range row {
+			if i == len(row)-1 {
+				line += cell
+				break
+			}
+			line += cell + strings.Repeat(" ", widths[i]-d.EscLen(cell)+2)
+		}
+		fmt.Println(line)
+	}
+}
+
+// printPlain prints the rows as tab-separated fields, empty ones as "-".
+func printPlain(rows [][]string) {
+	for _, row := range rows {
+		fields := make([]string, len(row))
+		for i, cell := range row {
+			fields[i] = d.StripEsc(cell)
+			if fields[i] == "" {
+				fields[i] = "-"
+			}
+		}
+		fmt.Println(strings.Join(fields, "\t"))
+	}
+}
+
+// stripColors removes colors from the cells unless stdout is colored.
+func stripColors(rows [][]string) {
+	if d.Colored(os.Stdout) {
+		return
+	}
+	for _, row := range rows {
+		for i := range row {
+			row[i] = d.StripEsc(row[i])
+		}
+	}
+}
+
+// absPath makes paths in JSON output independent of the working directory.
+func absPath(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
@@ -18,6 +18,7 @@ import (
 	d "github.com/AOSC-Dev/ciel/display"
 	"github.com/AOSC-Dev/ciel/internal/ciel"
 	"github.com/AOSC-Dev/ciel/internal/container/instance"
+	api "github.com/AOSC-Dev/ciel/pkg/ciel"
 	"github.com/AOSC-Dev/ciel/systemd-api/nspawn"
 )
 
@@ -26,13 +27,15 @@ const (
 	LatestTarballURL = "https://releases.aosc.io/os-amd64/buildkit/aosc-os_buildkit_latest_amd64.tar.xz"
 )
 
-func untarGuestOS() {
-	basePath := flagCielDir()
-	batchFlag := flagBatch()
-	parse()
+func untarGuestOSFlags(fs *flag.FlagSet) {
+	flagCielDir(fs)
+	flagAnswer(fs, "delete-instances", "delete all instances without asking, required without a terminal")
+	flagAnswer(fs, "delete-os", "delete the old OS without asking, required without a terminal")
+}
 
-	i := &ciel.Ciel{BasePath: *basePath}
-	i.Check()
+func untarGuestOS() {
+	i := &ciel.Ciel{BasePath: opts.cielDir}
+	checkCiel(i)
 	c := i.Container()
 
 	tar := flag.Arg(0)
@@ -63,18 +66,13 @@ func untarGuestOS() {
 	d.SECTION("Load OS From Compressed File")
 	d.ITEM("are there any instances?")
 
-	if instList := c.GetAllNames(); len(instList) != 0 {
+	if instList := allInstanceNames(c); len(instList) != 0 {
 		d.Println(d.C(d.YELLOW, strings.Join(instList, " ")))
-		if !*batchFlag && d.ASKLower("DELETE ALL INSTANCES?", "yes/no") != "yes" {
+		if !confirm(d.Question{Msg: "DELETE ALL INSTANCES?", Flag: "delete-instances", Destructive: true}) {
 			os.Exit(1)
 		}
-		for _, inst := range c.GetAll() {
-			if inst.Running() {
-				inst.Stop(context.TODO())
-			}
-			if inst.Mounted() {
-				inst.Unmount()
-			}
+		for _, inst := range allInstances(c) {
+			shutdownInstance(inst.Name, inst)
 			d.ITEM("delete " + inst.Name)
 			err := c.DelInst(inst.Name)
 			d.ERR(err)
@@ -88,7 +86,7 @@ func untarGuestOS() {
 	list, err := ioutil.ReadDir(c.DistDir())
 	if len(list) != 0 {
 		d.Println(d.C(d.YELLOW, "NO"))
-		if !*batchFlag && d.ASKLower("DELETE the old OS?", "yes/no") != "yes" {
+		if !confirm(d.Question{Msg: "DELETE the old OS?", Flag: "delete-os", Destructive: true}) {
 			os.Exit(1)
 		}
 		d.ITEM("remove dist dir")
@@ -120,6 +118,12 @@ func untarGuestOS() {
 	d.OK()
 }
 
+func updateFlags(fs *flag.FlagSet) {
+	flagCielDir(fs)
+	flagNetwork(fs)
+	flagAnswer(fs, "stop-instances", "stop and unmount all instances without asking")
+}
+
 func update() {
 	var runErr error
 	var exitStatus int
@@ -131,19 +135,14 @@ func update() {
 		}
 	}()
 
-	basePath := flagCielDir()
-	networkFlag := flagNetwork()
-	batchFlag := flagBatch()
-	parse()
-
-	i := &ciel.Ciel{BasePath: *basePath}
-	i.Check()
+	i := &ciel.Ciel{BasePath: opts.cielDir}
+	checkCiel(i)
 	c := i.Container()
 
 	d.SECTION("Update Guest Operating System")
 	d.ITEM("are there online instances?")
 	ready := true
-	for _, inst := range c.GetAll() {
+	for _, inst := range allInstances(c) {
 		if inst.Running() || inst.Mounted() {
 			ready = false
 			d.Print(d.C(d.YELLOW, inst.Name) + " ")
@@ -155,16 +154,11 @@ func update() {
 	d.Println()
 
 	if !ready {
-		if !*batchFlag && d.ASKLower("Stop all instances?", "yes/no") != "yes" {
+		if !confirm(d.Question{Msg: "Stop all instances?", Flag: "stop-instances", Default: true}) {
 			os.Exit(1)
 		}
-		for _, inst := range c.GetAll() {
-			if inst.Running() {
-				inst.Stop(context.TODO())
-			}
-			if inst.Mounted() {
-				inst.Unmount()
-			}
+		for _, inst := range allInstances(c) {
+			shutdownInstance(inst.Name, inst)
 		}
 	}
 
@@ -179,15 +173,24 @@ func update() {
 	}()
 	inst := c.Instance(instName)
 	d.ITEM("mount temporary instance")
-	inst.Mount()
+	if err := inst.Mount(); err != nil {
+		d.FAILED_BECAUSE(err.Error())
+		os.Exit(1)
+	}
 	d.OK()
 	defer func() {
-		inst.Unmount()
+		shutdownInstance(instName, inst)
 	}()
 
+	apiInst := openInstance(openWorkspace(opts.cielDir), instName)
+	network := opts.network
+	if network == "" {
+		// APT fetches packages, whatever the default of builders is
+		network = nspawn.NetworkHost
+	}
 	type ExitError struct{}
 	var run = func(cmd string) (int, error) {
-		return _shellRun(inst, *networkFlag, true, cmd)
+		return apiInst.Run(context.TODO(), api.RunOptions{Command: cmd, Network: network, Trace: traceCommand})
 	}
 	defer func() {
 		p := recover()
@@ -235,21 +238,17 @@ func factoryReset() {
 		}
 	}()
 
-	basePath := flagCielDir()
-	instName := flagInstance()
-	parse()
-
-	i := &ciel.Ciel{BasePath: *basePath}
-	i.Check()
+	i := &ciel.Ciel{BasePath: opts.cielDir}
+	checkCiel(i)
 	c := i.Container()
-	c.CheckInst(*instName)
-	inst := c.Instance(*instName)
+	checkInst(c, opts.instName)
+	inst := c.Instance(opts.instName)
 
 	d.SECTION("Factory Reset Guest Operating System")
 
-	inst.Stop(context.TODO())
+	stopInstance(inst.Name, inst)
 
-	ctnInfo := buildContainerInfo(false, false)
+	ctnInfo := buildContainerInfo(false, nil, nspawn.Limits{})
 	runInfo := buildRunInfo([]string{
 		"/bin/apt-gen-list",
 	})
@@ -257,9 +256,12 @@ func factoryReset() {
 		log.Println(err)
 	}
 
-	inst.Stop(context.TODO())
+	stopInstance(inst.Name, inst)
 	d.ITEM("mount instance")
-	inst.Mount()
+	if err := inst.Mount(); err != nil {
+		d.FAILED_BECAUSE(err.Error())
+		os.Exit(1)
+	}
 	d.OK()
 
 	d.ITEM("collect package list in dpkg")
@@ -270,8 +272,9 @@ func factoryReset() {
 	fileSet := dpkgPackageFiles(inst, pkgList)
 	d.OK()
 
-	i.GetTree().MountHandler(inst, false)
-	i.GetOutput().MountHandler(inst, false)
+	if err := inst.UnmountBinds(); err != nil {
+		log.Fatalln(err)
+	}
 
 	d.ITEM("remove out-of-package files")
 	err := clean(inst.MountPoint(), fileSet,
@@ -367,7 +370,7 @@ func wrapWalkFunc(root string, fn filepath.WalkFunc) filepath.WalkFunc {
 }
 
 func dpkgPackages(i *instance.Instance) []string {
-	ctnInfo := buildContainerInfo(false, false)
+	ctnInfo := buildContainerInfo(false, nil, nspawn.Limits{})
 
 	stdout := new(bytes.Buffer)
 	var args []string
@@ -393,7 +396,7 @@ func dpkgPackages(i *instance.Instance) []string {
 }
 
 func dpkgPackageFiles(i *instance.Instance, packages []string) map[string]bool {
-	ctnInfo := buildContainerInfo(false, false)
+	ctnInfo := buildContainerInfo(false, nil, nspawn.Limits{})
 
 	stdout := new(bytes.Buffer)
 	var args []string
@@ -3,21 +3,17 @@ package main
 import (
 	"context"
 	"flag"
-	"io/ioutil"
+	"fmt"
 	"log"
 	"os"
-	"path"
-	"strconv"
 	"strings"
 
 	d "github.com/AOSC-Dev/ciel/display"
-	"github.com/AOSC-Dev/ciel/internal/ciel"
-	"github.com/AOSC-Dev/ciel/internal/container/instance"
+	api "github.com/AOSC-Dev/ciel/pkg/ciel"
+	"github.com/AOSC-Dev/ciel/pkg/daemon"
 )
 
 func add() {
-	basePath := flagCielDir()
-	parse()
 	instName := flag.Arg(0)
 
 	if instName == "" {
@@ -27,96 +23,117 @@ func add() {
 		log.Fatalln("do not contain white space")
 	}
 
-	i := &ciel.Ciel{BasePath: *basePath}
-	i.Check()
-	c := i.Container()
-
-	if c.InstExists(instName) {
+	w := openWorkspace(opts.cielDir)
+	inst, err := w.AddInstance(instName)
+	if err == api.ErrInstExists {
 		log.Fatalln("already has " + instName)
+	} else if err != nil {
+		log.Fatalln(err)
+	}
+	if err := inst.Mount(); err != nil {
+		log.Fatalln(err)
 	}
-	c.AddInst(instName)
-	c.Instance(instName).Mount()
 }
 
 func del() {
-	basePath := flagCielDir()
-	parse()
 	instName := flag.Arg(0)
 
-	i := &ciel.Ciel{BasePath: *basePath}
-	i.Check()
-	c := i.Container()
-	c.CheckInst(instName)
-
-	c.Instance(instName).Unmount()
-	c.DelInst(instName)
+	w := openWorkspace(opts.cielDir)
+	inst := openInstance(w, instName)
+	if err := shutdownInstance(instName, inst); err != nil {
+		os.Exit(1)
+	}
+	d.ITEM("delete " + instName)
+	err := w.RemoveInstance(instName)
+	d.ERR(err)
+	if err != nil {
+		os.Exit(1)
+	}
 }
 
 func shell() {
-	basePath := flagCielDir()
-	instName := flagInstance()
-	networkFlag := flagNetwork()
-	noBooting := flagNoBooting()
-	parse()
-
 	if flag.NArg() > 1 {
 		log.Fatalln("you must pass one argument only")
 	}
+	if socket := daemonSocket(); socket != "" {
+		if flag.NArg() == 0 {
+			log.Fatalln("interactive shells are not supported through the daemon")
+		}
+		runRemote(socket, daemon.RunRequest{
+			Instance: opts.instName,
+			Command:  flag.Arg(0),
+			Network:  opts.network,
+			Limits:   opts.limits,
+			NoBoot:   opts.noBooting,
+		})
+	}
 
-	i := &ciel.Ciel{BasePath: *basePath}
-	i.Check()
-	c := i.Container()
-	c.CheckInst(*instName)
-
-	inst := c.Instance(*instName)
-	inst.Mount()
+	w := openWorkspace(opts.cielDir)
+	inst := openInstance(w, opts.instName)
+	if err := inst.Mount(); err != nil {
+		log.Fatalln(err)
+	}
 
-	if flag.NArg() == 0 {
-		exitStatus, err := _openShell(
-			inst,
-			*networkFlag,
-			!*noBooting,
-		)
-		if err != nil {
-			log.Println(err)
-		}
-		os.Exit(exitStatus)
-	}
-	exitStatus, err := _shellRun(
-		inst,
-		*networkFlag,
-		!*noBooting,
-		flag.Arg(0),
-	)
+	exitStatus, err := inst.Run(context.TODO(), api.RunOptions{
+		Command: flag.Arg(0),
+		Network: opts.network,
+		Limits:  opts.limits,
+		NoBoot:  opts.noBooting,
+		Trace:   traceCommand,
+	})
 	if err != nil {
 		log.Println(err)
 	}
 	os.Exit(exitStatus)
 }
 
+var runOpts struct {
+	showUsage bool
+}
+
+func runFlags(fs *flag.FlagSet) {
+	containerFlags(fs)
+	fs.BoolVar(&runOpts.showUsage, "usage", false, "show the resource usage of the instance when the command exits")
+}
+
 func run() {
-	basePath := flagCielDir()
-	instName := flagInstance()
-	networkFlag := flagNetwork()
-	noBooting := flagNoBooting()
-	parse()
-
-	i := &ciel.Ciel{BasePath: *basePath}
-	i.Check()
-	c := i.Container()
-	c.CheckInst(*instName)
-
-	inst := c.Instance(*instName)
-	inst.Mount()
-
-	ctnInfo := buildContainerInfo(!*noBooting, *networkFlag)
-	runInfo := buildRunInfo(flag.Args())
-	runInfo.UseSystemdRun = true
-	exitStatus, err := inst.Run(
-		context.TODO(),
-		ctnInfo,
-		runInfo,
-	)
+	if flag.NArg() == 0 {
+		log.Fatalln("you must give a command to run")
+	}
+	if socket := daemonSocket(); socket != "" {
+		if runOpts.showUsage {
+			log.Fatalln("-usage is not supported through the daemon")
+		}
+		runRemote(socket, daemon.RunRequest{
+			Instance: opts.instName,
+			Args:     flag.Args(),
+			Network:  opts.network,
+			Limits:   opts.limits,
+			NoBoot:   opts.noBooting,
+		})
+	}
+
+	w := openWorkspace(opts.cielDir)
+	inst := openInstance(w, opts.instName)
+	if err := inst.Mount(); err != nil {
+		log.Fatalln(err)
+	}
+
+	var meter *api.Meter
+	if runOpts.showUsage {
+		meter = inst.StartMeter()
+	}
+	exitStatus, err := inst.Run(context.TODO(), api.RunOptions{
+		Args:    flag.Args(),
+		Network: opts.network,
+		Limits:  opts.limits,
+		NoBoot:  opts.noBooting,
+		Trace:   traceCommand,
+	})
+	if meter != nil {
+		d.ITEM("resource usage")
+		d.Println(d.C(d.CYAN, formatUsage(meter.Stop())))
+	}
 
 	if err != nil {
 		log.Println(err)
@@ -124,132 +141,151 @@ func run() {
 	os.Exit(exitStatus)
 }
 
-func _openShell(inst *instance.Instance, network bool, boot bool) (int, error) {
-	inst.Mount()
-	rootShell, err := inst.Shell("root")
-	if err != nil {
-		return -1, err
-	}
-	ctnInfo := buildContainerInfo(boot, network)
-	runInfo := buildRunInfo([]string{rootShell})
+// stoppable is an instance of either the internal or the public API.
+type stoppable interface {
+	Running() bool
+	Mounted() bool
+	Stop(ctx context.Context) error
+	Unmount() error
+}
 
-	exitStatus, err := inst.Run(
-		context.TODO(),
-		ctnInfo,
-		runInfo,
-	)
-	if err != nil {
-		return -1, err
+// stopInstance stops the instance, showing the step.
+func stopInstance(name string, inst stoppable) error {
+	d.ITEM("stop " + name)
+	if !inst.Running() {
+		d.SKIPPED()
+		return nil
 	}
-	return exitStatus, nil
+	err := inst.Stop(context.TODO())
+	d.ERR(err)
+	return err
 }
 
-func _shellRun(inst *instance.Instance, network bool, boot bool, cmd string) (int, error) {
-	inst.Mount()
-	var args []string
-	rootShell, err := inst.Shell("root")
-	if err != nil {
-		return -1, err
-	}
-	if cmd != "" {
-		cmd = cmd + "; echo $?>/.ciel-exit-status"
-		args = []string{
-			rootShell,
-			"--login",
-			"-c", cmd,
-		}
-	} else {
-		args = []string{
-			rootShell,
-		}
+// shutdownInstance stops the instance and unmounts it, showing each step.
+func shutdownInstance(name string, inst stoppable) error {
+	if err := stopInstance(name, inst); err != nil {
+		return err
 	}
-	ctnInfo := buildContainerInfo(boot, network)
-	runInfo := buildRunInfo(args)
-	exitStatus, err := inst.Run(
-		context.TODO(),
-		ctnInfo,
-		runInfo,
-	)
-	exitStatusFile := path.Join(inst.MountPoint(), ".ciel-exit-status")
-	if b, err := ioutil.ReadFile(exitStatusFile); err == nil {
-		os.Remove(exitStatusFile)
-		if realExitStatus, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil {
-			return realExitStatus, nil
-		}
-		log.Println(err)
-		return exitStatus, nil
-	}
-	if os.IsNotExist(err) {
-		log.Println("session was accidentally terminated")
-	} else {
-		log.Println(err)
+	d.ITEM("unmount " + name)
+	if !inst.Mounted() {
+		d.SKIPPED()
+		return nil
 	}
-	if exitStatus == 0 {
-		exitStatus = 1
-	}
-	return exitStatus, nil
+	err := inst.Unmount()
+	d.ERR(err)
+	return err
 }
 
 func stop() {
-	basePath := flagCielDir()
-	instName := flagInstance()
-	parse()
-
-	i := &ciel.Ciel{BasePath: *basePath}
-	i.Check()
-	c := i.Container()
-	c.CheckInst(*instName)
+	w := openWorkspace(opts.cielDir)
+	inst := openInstance(w, opts.instName)
 
-	inst := c.Instance(*instName)
-
-	err := inst.Stop(context.TODO())
-	if err != nil {
-		log.Fatal(err)
+	if err := stopInstance(inst.Name(), inst); err != nil {
+		os.Exit(1)
 	}
 }
 
-func rollback() {
-	basePath := flagCielDir()
-	instName := flagInstance()
-	parse()
+var rollbackOpts struct {
+	baseline string
+}
 
-	i := &ciel.Ciel{BasePath: *basePath}
-	i.Check()
-	c := i.Container()
-	c.CheckInst(*instName)
+func rollbackFlags(fs *flag.FlagSet) {
+	instanceFlags(fs)
+	flagWait(fs)
+	fs.StringVar(&rollbackOpts.baseline, "baseline", "", "roll back to baseline `name` instead of the underlying OS")
+}
 
-	inst := c.Instance(*instName)
+func rollback() {
+	baseline := rollbackOpts.baseline
+	w := openWorkspace(opts.cielDir)
+	inst := openInstance(w, opts.instName)
+	if err := inst.Lock(opts.wait); err != nil {
+		log.Fatalln(err)
+	}
+	defer inst.Unlock()
 
 	d.SECTION("Rollback Changes")
 	d.ITEM("is running?")
 	if inst.Running() {
 		d.Println(d.C(d.YELLOW, "ONLINE"))
-		inst.Unmount()
 	} else {
 		d.Println(d.C(d.CYAN, "OFFLINE"))
 	}
-	inst.FileSystem().Rollback()
+	if err := shutdownInstance(inst.Name(), inst); err != nil {
+		inst.Unlock()
+		os.Exit(1)
+	}
+	if baseline == "" {
+		d.ITEM("discard changes")
+	} else {
+		d.ITEM("restore baseline " + baseline)
+	}
+	if err := inst.Rollback(baseline); err != nil {
+		d.FAILED_BECAUSE(err.Error())
+		inst.Unlock()
+		os.Exit(1)
+	}
+	d.OK()
 }
 
-func commit() {
-	basePath := flagCielDir()
-	instName := flagInstance()
-	parse()
+var baselineOpts struct {
+	remove bool
+}
 
-	i := &ciel.Ciel{BasePath: *basePath}
-	i.Check()
-	c := i.Container()
-	c.CheckInst(*instName)
+func baselineFlags(fs *flag.FlagSet) {
+	instanceFlags(fs)
+	flagWait(fs)
+	fs.BoolVar(&baselineOpts.remove, "d", false, "delete the baseline")
+}
 
-	inst := c.Instance(*instName)
+func baseline() {
+	w := openWorkspace(opts.cielDir)
+	inst := openInstance(w, opts.instName)
+
+	name := flag.Arg(0)
+	if name == "" {
+		names, err := inst.Baselines()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return
+	}
+	if baselineOpts.remove {
+		d.ITEM("delete baseline " + name)
+		d.ERR(inst.RemoveBaseline(name))
+		return
+	}
+
+	if err := inst.Lock(opts.wait); err != nil {
+		log.Fatalln(err)
+	}
+	defer inst.Unlock()
+	d.SECTION("Save Baseline")
+	if err := shutdownInstance(inst.Name(), inst); err != nil {
+		inst.Unlock()
+		os.Exit(1)
+	}
+	d.ITEM("save baseline " + name)
+	d.ERR(inst.SaveBaseline(name))
+}
+
+func commit() {
+	w := openWorkspace(opts.cielDir)
+	inst := openInstance(w, opts.instName)
 
 	d.SECTION("Commit Changes")
 	d.ITEM("is running?")
 	if inst.Running() {
 		d.Println(d.C(d.YELLOW, "ONLINE"))
-		inst.Unmount()
 	} else {
 		d.Println(d.C(d.CYAN, "OFFLINE"))
 	}
-	inst.FileSystem().Merge()
+	if err := shutdownInstance(inst.Name(), inst); err != nil {
+		os.Exit(1)
+	}
+	d.ITEM("merge changes")
+	d.ERR(inst.Commit())
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	d "github.com/AOSC-Dev/ciel/display"
+	"github.com/AOSC-Dev/ciel/internal/ciel"
+	"github.com/AOSC-Dev/ciel/systemd-api/nspawn"
+)
+
+var limitsOpts struct {
+	reset bool
+}
+
+func limitsFlags(fs *flag.FlagSet) {
+	instanceFlags(fs)
+	flagLimits(fs)
+	fs.BoolVar(&limitsOpts.reset, "reset", false, "remove all default limits")
+}
+
+func limits() {
+	reset := limitsOpts.reset
+	i := &ciel.Ciel{BasePath: opts.cielDir}
+	checkCiel(i)
+	c := i.Container()
+	checkInst(c, opts.instName)
+	inst := c.Instance(opts.instName)
+
+	config, err := inst.Config()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if !reset && opts.limits.Empty() {
+		fmt.Println(config.Limits)
+		return
+	}
+	if reset {
+		config.Limits = nspawn.Limits{}
+	}
+	config.Limits = config.Limits.Merge(nspawn.Limits(opts.limits))
+	if err := config.Limits.Check(); err != nil {
+		log.Fatalln(err)
+	}
+	if err := inst.SaveConfig(config); err != nil {
+		log.Fatalln(err)
+	}
+	if inst.Running() {
+		d.Println(d.C(d.YELLOW, inst.Name+" is running, the limits change on its next start"))
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	d "github.com/AOSC-Dev/ciel/display"
+	"github.com/AOSC-Dev/ciel/internal/ciel"
+	"github.com/AOSC-Dev/ciel/internal/packaging"
+	api "github.com/AOSC-Dev/ciel/pkg/ciel"
+)
+
+const followInterval = 200 * time.Millisecond
+
+var logsOpts struct {
+	last, follow, plain bool
+}
+
+func logsFlags(fs *flag.FlagSet) {
+	flagCielDir(fs)
+	fs.BoolVar(&logsOpts.last, "last", false, "show the latest build log")
+	fs.BoolVar(&logsOpts.follow, "follow", false, "keep showing new output until the build finishes")
+	fs.BoolVar(&logsOpts.plain, "plain", false, "show the copy without escape sequences")
+	flagFormat(fs)
+}
+
+func logs() {
+	last, follow, plain := logsOpts.last, logsOpts.follow, logsOpts.plain
+	checkFormat(opts.format)
+
+	i := &ciel.Ciel{BasePath: opts.cielDir}
+	checkCiel(i)
+	output := i.Output()
+
+	logList, err := output.BuildLogs()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var rawPath string
+	switch {
+	case flag.NArg() > 0:
+		rawPath = path.Join(output.LogDir(), path.Base(flag.Arg(0)))
+	case last || follow:
+		if len(logList) == 0 {
+			log.Fatalln("no build log yet")
+		}
+		rawPath = logList[len(logList)-1]
+	default:
+		statuses := []api.LogStatus{}
+		var rows [][]string
+		for _, logFile := range logList {
+			status := api.LogStatus{
+				Name:     path.Base(logFile),
+				Path:     absPath(logFile),
+				Building: packaging.LogWriting(logFile),
+			}
+			state := ""
+			if status.Building {
+				state = d.C(d.YELLOW, "building")
+			}
+			statuses = append(statuses, status)
+			rows = append(rows, []string{status.Name, state})
+		}
+		switch opts.format {
+		case formatJSON:
+			printJSON(statuses)
+		case formatPlain:
+			printPlain(rows)
+		default:
+			stripColors(rows)
+			for _, row := range rows {
+				fmt.Printf("%s\t%s\n", row[0], row[1])
+			}
+		}
+		return
+	}
+	logPath := rawPath
+	if plain {
+		logPath = packaging.PlainLogPath(rawPath)
+	}
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(os.Stdout, f); err != nil {
+		log.Fatalln(err)
+	}
+	if !follow {
+		return
+	}
+	// the raw log is locked by the build until it finishes
+	for packaging.LogWriting(rawPath) {
+		time.Sleep(followInterval)
+		if _, err := io.Copy(os.Stdout, f); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	io.Copy(os.Stdout, f)
+}
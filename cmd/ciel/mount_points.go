@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"github.com/AOSC-Dev/ciel/internal/ciel"
 
@@ -9,16 +10,12 @@ import (
 )
 
 func mountCiel() {
-	basePath := flagCielDir()
-	instName := flagInstance()
-	parse()
-
-	i := &ciel.Ciel{BasePath: *basePath}
-	i.Check()
+	i := &ciel.Ciel{BasePath: opts.cielDir}
+	checkCiel(i)
 	c := i.Container()
 
-	if *instName == "" {
-		instList := c.GetAll()
+	if opts.instName == "" {
+		instList := allInstances(c)
 		for _, inst := range instList {
 			err := inst.Mount()
 			if err != nil {
@@ -27,28 +24,28 @@ func mountCiel() {
 		}
 		return
 	}
-	c.CheckInst(*instName)
-	c.Instance(*instName).Mount()
+	checkInst(c, opts.instName)
+	if err := c.Instance(opts.instName).Mount(); err != nil {
+		log.Fatalln(err)
+	}
 }
 
 func shutdown() {
-	basePath := flagCielDir()
-	instName := flagInstance()
-	parse()
-
-	i := &ciel.Ciel{BasePath: *basePath}
-	i.Check()
+	i := &ciel.Ciel{BasePath: opts.cielDir}
+	checkCiel(i)
 	c := i.Container()
 
-	if *instName == "" {
-		instList := c.GetAll()
+	if opts.instName == "" {
+		instList := allInstances(c)
 		for _, inst := range instList {
 			d.SECTION("Shutdown Instance " + inst.Name)
-			inst.Unmount()
+			shutdownInstance(inst.Name, inst)
 		}
 		return
 	}
-	c.CheckInst(*instName)
-	d.SECTION("Shutdown Instance " + *instName)
-	c.Instance(*instName).Unmount()
+	checkInst(c, opts.instName)
+	d.SECTION("Shutdown Instance " + opts.instName)
+	if err := shutdownInstance(opts.instName, c.Instance(opts.instName)); err != nil {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	d "github.com/AOSC-Dev/ciel/display"
+	"github.com/AOSC-Dev/ciel/internal/ciel"
+	"github.com/AOSC-Dev/ciel/systemd-api/nspawn"
+)
+
+// stringList collects the values of a flag given more than once.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(s string) error { *l = append(*l, s); return nil }
+
+var networkOpts struct {
+	ports   stringList
+	noPorts bool
+}
+
+func networkFlags(fs *flag.FlagSet) {
+	instanceFlags(fs)
+	fs.Var(&networkOpts.ports, "port", "forward `[tcp:|udp:]HOST[:CONTAINER]` when booted, may be repeated")
+	fs.BoolVar(&networkOpts.noPorts, "no-ports", false, "stop forwarding ports")
+}
+
+func network() {
+	ports, noPorts := networkOpts.ports, networkOpts.noPorts
+	if flag.NArg() > 1 {
+		log.Fatalln("you must pass one network mode only")
+	}
+
+	i := &ciel.Ciel{BasePath: opts.cielDir}
+	checkCiel(i)
+	c := i.Container()
+	checkInst(c, opts.instName)
+	inst := c.Instance(opts.instName)
+
+	config, err := inst.Config()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if flag.NArg() == 0 && len(ports) == 0 && !noPorts {
+		info, err := inst.Network("")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Println(info)
+		for _, port := range config.Ports {
+			fmt.Println("port", port)
+		}
+		return
+	}
+
+	if flag.NArg() == 1 {
+		if _, err := nspawn.ParseNetwork(flag.Arg(0)); err != nil {
+			log.Fatalln(err)
+		}
+		config.Network = flag.Arg(0)
+	}
+	if noPorts {
+		config.Ports = nil
+	}
+	config.Ports = append(config.Ports, ports...)
+	info, err := nspawn.ParseNetwork(config.Network)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	info.Ports = config.Ports
+	if err := info.Check(); err != nil {
+		log.Fatalln(err)
+	}
+	if err := inst.SaveConfig(config); err != nil {
+		log.Fatalln(err)
+	}
+	if inst.Running() {
+		d.Println(d.C(d.YELLOW, inst.Name+" is running, the network changes on its next start"))
+	}
+}
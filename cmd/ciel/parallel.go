@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	d "github.com/AOSC-Dev/ciel/display"
+	api "github.com/AOSC-Dev/ciel/pkg/ciel"
+)
+
+const parallelInstSuffix = "--parallel-"
+
+var prefixColors = []d.Color{d.CYAN, d.GREEN, d.YELLOW, d.BLUE, d.PURPLE}
+
+// buildParallel builds the queue in n instances at once. The instances
+// given are used first, then temporary ones are created from the first
+// one, and deleted afterwards. A package starts as soon as the packages
+// before it in the queue, which it depends on, are built.
+func buildParallel(w *api.Workspace, given []*api.Instance, n int, newBuilder func(*api.Instance) (*api.Builder, error), queue []*api.Package, keepGoing bool) ([]*queueStep, error) {
+	insts, temporary, err := parallelInstances(w, given, n)
+	defer func() {
+		for _, inst := range temporary {
+			d.SECTION("Delete Temporary Instance " + inst.Name())
+			shutdownInstance(inst.Name(), inst)
+			d.ITEM("delete " + inst.Name())
+			d.ERR(w.RemoveInstance(inst.Name()))
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	var outputMutex sync.Mutex
+	var builders []*api.Builder
+	var outs []*prefixWriter
+	release := func() {
+		for _, b := range builders {
+			b.Teardown()
+			b.Unlock()
+		}
+	}
+	for index, inst := range insts {
+		out := &prefixWriter{
+			Mutex:  &outputMutex,
+			Out:    os.Stdout,
+			Prefix: d.C(prefixColors[index%len(prefixColors)], "["+inst.Name()+"]") + " ",
+		}
+		b, err := prepareParallel(inst, newBuilder, out)
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("%s: %w", inst.Name(), err)
+		}
+		builders = append(builders, b)
+		outs = append(outs, out)
+	}
+
+	s := &scheduler{steps: make([]*queueStep, len(queue))}
+	s.cond = sync.NewCond(&s.mutex)
+	for index, pkg := range queue {
+		s.steps[index] = &queueStep{Package: pkg}
+	}
+
+	d.SECTION("Build In Parallel")
+	var wg sync.WaitGroup
+	for index, b := range builders {
+		wg.Add(1)
+		go func(b *api.Builder, out *prefixWriter) {
+			defer wg.Done()
+			defer out.Flush()
+			s.work(b, out, keepGoing)
+		}(b, outs[index])
+	}
+	wg.Wait()
+	release()
+	return s.steps, nil
+}
+
+// prepareParallel locks and sets up a builder for inst, whose output and
+// steps all go to out.
+func prepareParallel(inst *api.Instance, newBuilder func(*api.Instance) (*api.Builder, error), out io.Writer) (*api.Builder, error) {
+	b, err := newBuilder(inst)
+	if err != nil {
+		return nil, err
+	}
+	b.Stdin = nil
+	b.Stdout = out
+	b.Stderr = out
+	b.Notify = func(item string, err error, msg string) {
+		if err != nil {
+			msg = err.Error()
+		}
+		fmt.Fprintln(out, item+": "+msg)
+	}
+	// not shown on the terminal, among the progress of builds
+	b.Trace = nil
+	if err := b.Lock(); err != nil {
+		return nil, err
+	}
+	if err := b.Setup(); err != nil {
+		b.Teardown()
+		b.Unlock()
+		return nil, err
+	}
+	return b, nil
+}
+
+// parallelInstances returns n instances to build in, and which of them are
+// temporary. Temporary instances created before an error are returned, for
+// the caller to delete.
+func parallelInstances(w *api.Workspace, given []*api.Instance, n int) (insts, temporary []*api.Instance, err error) {
+	insts = append(insts, given...)
+	base := insts[0]
+	for k := 1; len(insts) < n; k++ {
+		name := base.Name() + parallelInstSuffix + strconv.Itoa(k)
+		d.ITEM("create temporary instance " + name)
+		inst, err := w.CloneInstance(base, name)
+		if err == api.ErrInstExists {
+			d.FAILED()
+			return insts, temporary, fmt.Errorf("instance %s is left from an earlier parallel build, delete it with 'ciel del %s' first", name, name)
+		}
+		if inst != nil {
+			temporary = append(temporary, inst)
+		}
+		d.ERR(err)
+		if err != nil {
+			return insts, temporary, err
+		}
+		insts = append(insts, inst)
+	}
+	return insts, temporary, nil
+}
+
+// scheduler hands out steps of a queue to builders running in parallel.
+type scheduler struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	steps   []*queueStep
+	stopped bool
+}
+
+func (s *scheduler) work(b *api.Builder, out io.Writer, keepGoing bool) {
+	for {
+		step := s.next()
+		if step == nil {
+			return
+		}
+		fmt.Fprintf(out, "build %s\n", step.Package.Name)
+		s.finish(step, buildAndReport(b, []string{step.Package.Name}, ""), keepGoing)
+	}
+}
+
+// finish records the report of a step, waking up the builders waiting for
+// it.
+func (s *scheduler) finish(step *queueStep, report *api.Report, keepGoing bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	step.Report = report
+	if report.ExitStatus == 0 {
+		step.Status = stepSucceeded
+	} else {
+		step.Status = stepFailed
+		step.Reason = "exit status " + strconv.Itoa(report.ExitStatus)
+		if !keepGoing {
+			s.stopped = true
+		}
+	}
+	s.cond.Broadcast()
+}
+
+// next waits for a step which is ready to build, and returns nil when there
+// is nothing left to build.
+func (s *scheduler) next() *queueStep {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for {
+		running := false
+		for n, step := range s.steps {
+			switch step.Status {
+			case stepRunning:
+				running = true
+				continue
+			case stepPending:
+			default:
+				continue
+			}
+			if s.stopped {
+				step.Status = stepSkipped
+				step.Reason = "queue stopped"
+				continue
+			}
+			ready := true
+			for _, prev := range s.steps[:n] {
+				if !step.Package.DependsOn(prev.Package) {
+					continue
+				}
+				switch prev.Status {
+				case stepSucceeded:
+				case stepPending, stepRunning:
+					ready = false
+				default:
+					step.Status = stepSkipped
+					step.Reason = "depends on " + prev.Package.Name
+					ready = false
+				}
+				if step.Status == stepSkipped {
+					break
+				}
+			}
+			if ready {
+				step.Status = stepRunning
+				return step
+			}
+		}
+		if !running {
+			for _, step := range s.steps {
+				if step.Status == stepPending {
+					// only possible with failed steps in a cycle
+					step.Status = stepSkipped
+					step.Reason = "dependency not built"
+				}
+			}
+			return nil
+		}
+		s.cond.Wait()
+	}
+}
+
+// prefixWriter multiplexes output of builds line by line, each line is
+// prefixed with the instance it comes from.
+type prefixWriter struct {
+	Mutex  *sync.Mutex
+	Out    io.Writer
+	Prefix string
+
+	buf []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.Mutex.Lock()
+	defer w.Mutex.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if _, err := io.WriteString(w.Out, w.Prefix+string(w.buf[:i+1])); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes the last incomplete line.
+func (w *prefixWriter) Flush() {
+	w.Mutex.Lock()
+	defer w.Mutex.Unlock()
+	if len(w.buf) != 0 {
+		io.WriteString(w.Out, w.Prefix+string(w.buf)+"\n")
+		w.buf = nil
+	}
+}
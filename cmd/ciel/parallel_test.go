@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+
+	d "github.com/AOSC-Dev/ciel/display"
+	api "github.com/AOSC-Dev/ciel/pkg/ciel"
+)
+
+func newScheduler(specs ...string) *scheduler {
+	s := &scheduler{}
+	s.cond = sync.NewCond(&s.mutex)
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 2)
+		pkg := &api.Package{Name: parts[0], Names: []string{parts[0]}}
+		if len(parts) == 2 {
+			pkg.Deps = strings.Split(parts[1], ",")
+		}
+		s.steps = append(s.steps, &queueStep{Package: pkg})
+	}
+	return s
+}
+
+// runScheduler runs n workers, failing the packages in failing, and
+// returns the order packages were built in.
+func runScheduler(s *scheduler, n int, failing map[string]bool, keepGoing bool) []string {
+	var mutex sync.Mutex
+	var built []string
+	var wg sync.WaitGroup
+	for k := 0; k < n; k++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				step := s.next()
+				if step == nil {
+					return
+				}
+				d.ITEM("build " + step.Package.Name)
+				d.OK()
+				mutex.Lock()
+				built = append(built, step.Package.Name)
+				mutex.Unlock()
+				report := &api.Report{}
+				if failing[step.Package.Name] {
+					report.ExitStatus = 1
+				}
+				s.finish(step, report, keepGoing)
+			}
+		}()
+	}
+	wg.Wait()
+	return built
+}
+
+func TestSchedulerDependencies(t *testing.T) {
+	s := newScheduler("a", "b", "c:a,b", "d:c", "e")
+	built := runScheduler(s, 3, nil, false)
+	position := make(map[string]int)
+	for n, name := range built {
+		position[name] = n
+	}
+	if len(built) != 5 {
+		t.Fatalf("built %q, want all packages", built)
+	}
+	for _, dep := range [][2]string{{"a", "c"}, {"b", "c"}, {"c", "d"}} {
+		if position[dep[0]] > position[dep[1]] {
+			t.Errorf("%s built before its dependency %s: %q", dep[1], dep[0], built)
+		}
+	}
+}
+
+func TestSchedulerFailure(t *testing.T) {
+	s := newScheduler("a", "b:a", "c:b", "d")
+	runScheduler(s, 2, map[string]bool{"a": true}, true)
+	want := []stepStatus{stepFailed, stepSkipped, stepSkipped, stepSucceeded}
+	for n, step := range s.steps {
+		if step.Status != want[n] {
+			t.Errorf("%s: %s, want %s", step.Package.Name, step.Status, want[n])
+		}
+	}
+}
+
+func TestSchedulerStop(t *testing.T) {
+	s := newScheduler("a", "b:a", "c:b")
+	runScheduler(s, 2, map[string]bool{"a": true}, false)
+	for _, step := range s.steps[1:] {
+		if step.Status != stepSkipped {
+			t.Errorf("%s: %s, want skipped", step.Package.Name, step.Status)
+		}
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, prefix := range []string{"[a] ", "[b] "} {
+		w := &prefixWriter{Mutex: &mutex, Out: &out, Prefix: prefix}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 100; k++ {
+				w.Write([]byte("some "))
+				w.Write([]byte("line\n"))
+			}
+			w.Write([]byte("last"))
+			w.Flush()
+		}()
+	}
+	wg.Wait()
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 202 {
+		t.Fatalf("%d lines, want 202", len(lines))
+	}
+	for _, line := range lines {
+		if line != "[a] some line" && line != "[b] some line" && line != "[a] last" && line != "[b] last" {
+			t.Errorf("interleaved line %q", line)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
@@ -15,9 +16,8 @@ import (
 
 // constants definitions for the plugin paths
 var (
-	LibExecDir   = config.Prefix + "/libexec"
-	PluginDir    = LibExecDir + "/ciel-plugin"
-	PluginPrefix = "ciel-"
+	PluginDir    = config.PluginDir
+	PluginPrefix = config.PluginPrefix
 )
 
 // Plugin defines a ciel plugin
@@ -27,18 +27,17 @@ type Plugin struct {
 	Usage string
 }
 
+func pluginFlags(fs *flag.FlagSet) {
+	containerFlags(fs)
+	flagSuite(fs)
+}
+
 func plugin(subCmd string) int {
-	basePath := flagCielDir()
-	instName := flagInstance()
-	networkFlag := flagNetwork()
-	noBooting := flagNoBooting()
-	batchFlag := flagBatch()
-	parse()
-	saveCielDir(*basePath)
-	saveInstance(*instName)
-	saveNetwork(*networkFlag)
-	saveNoBooting(*noBooting)
-	saveBatch(*batchFlag)
+	saveCielDir(opts.cielDir)
+	saveInstance(opts.instName)
+	saveNetwork(opts.network)
+	saveNoBooting(opts.noBooting)
+	saveSuite(opts.suite)
 
 	proc := filepath.Join(PluginDir, PluginPrefix+subCmd)
 	cmd := exec.Command(proc, flag.Args()...)
@@ -58,11 +57,15 @@ func plugin(subCmd string) int {
 	return 0
 }
 
-func getPlugins() []Plugin {
+// getPlugins returns the installed plugins, none if the plugin directory
+// does not exist.
+func getPlugins() ([]Plugin, error) {
 	var Plugins []Plugin
 	files, err := ioutil.ReadDir(PluginDir)
-	if err != nil {
-		log.Fatalf("failed to get files under plugin directory: %v\n", err)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get files under plugin directory: %v", err)
 	}
 	for _, f := range files {
 		if f.IsDir() {
@@ -74,5 +77,5 @@ func getPlugins() []Plugin {
 			}
 		}
 	}
-	return Plugins
+	return Plugins, nil
 }
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	d "github.com/AOSC-Dev/ciel/display"
+	api "github.com/AOSC-Dev/ciel/pkg/ciel"
+)
+
+type stepStatus int
+
+const (
+	stepPending stepStatus = iota
+	stepRunning
+	stepSucceeded
+	stepFailed
+	stepSkipped
+)
+
+func (s stepStatus) String() string {
+	switch s {
+	case stepSucceeded:
+		return "ok"
+	case stepFailed:
+		return "failed"
+	case stepSkipped:
+		return "skipped"
+	case stepRunning:
+		return "running"
+	default:
+		return "pending"
+	}
+}
+
+// queueStep is a package in a build queue, and how it went.
+type queueStep struct {
+	Package *api.Package
+	Status  stepStatus
+	Reason  string
+	Report  *api.Report
+}
+
+// planQueue looks up the requested packages in the tree and sorts them in
+// dependency order.
+func planQueue(w *api.Workspace, requests []string) []*api.Package {
+	plan, err := w.PlanQueue(requests)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	d.SECTION("Build Queue")
+	for n, pkg := range plan.Ordered {
+		d.ITEM(strconv.Itoa(n + 1))
+		d.Println(pkg.Name)
+	}
+	if len(plan.Cycle) != 0 {
+		d.ITEM("dependency cycle")
+		d.Println(d.C(d.YELLOW, packageNames(plan.Cycle)))
+	}
+	if len(plan.Blocked) != 0 {
+		d.ITEM("blocked by the cycle")
+		d.Println(d.C(d.YELLOW, packageNames(plan.Blocked)))
+	}
+	return plan.Queue()
+}
+
+func packageNames(list []*api.Package) string {
+	var names []string
+	for _, pkg := range list {
+		names = append(names, pkg.Name)
+	}
+	return strings.Join(names, " ")
+}
+
+// buildQueue builds packages one at a time, so that each of them sees the
+// artifacts of the ones before it. Packages depending on a failed one are
+// skipped; without keepGoing, the queue stops at the first failure.
+func buildQueue(b *api.Builder, queue []*api.Package, keepGoing bool) []*queueStep {
+	steps := make([]*queueStep, len(queue))
+	for n, pkg := range queue {
+		steps[n] = &queueStep{Package: pkg}
+	}
+	stopped := false
+	for n, step := range steps {
+		if stopped {
+			step.Status = stepSkipped
+			step.Reason = "queue stopped"
+			continue
+		}
+		for _, prev := range steps[:n] {
+			if prev.Status != stepSucceeded && step.Package.DependsOn(prev.Package) {
+				step.Status = stepSkipped
+				step.Reason = "depends on " + prev.Package.Name
+				break
+			}
+		}
+		if step.Status == stepSkipped {
+			continue
+		}
+
+		d.SECTION(fmt.Sprintf("Build %s (%d/%d)", step.Package.Name, n+1, len(steps)))
+		step.Report = buildAndReport(b, []string{step.Package.Name}, "")
+		if step.Report.ExitStatus == 0 {
+			step.Status = stepSucceeded
+			continue
+		}
+		step.Status = stepFailed
+		step.Reason = "exit status " + strconv.Itoa(step.Report.ExitStatus)
+		if !keepGoing {
+			stopped = true
+		}
+	}
+	return steps
+}
+
+func printQueueSummary(steps []*queueStep) {
+	d.SECTION("Summary")
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "PACKAGE\tSTATUS\tTIME\tCPU\tPEAK MEMORY\tDETAIL")
+	for _, step := range steps {
+		var elapsed, cpu, memory, detail string
+		if step.Report != nil {
+			elapsed = step.Report.EndTime.Sub(step.Report.StartTime).Round(time.Second).String()
+			detail = step.Report.Log
+		}
+		if step.Report != nil && step.Report.Usage != nil {
+			cpu = formatSeconds(step.Report.Usage.CPUSeconds)
+			memory = formatSize(int64(step.Report.Usage.PeakMemory))
+		}
+		if step.Reason != "" {
+			detail = step.Reason
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", step.Package.Name, step.Status, elapsed, cpu, memory, detail)
+	}
+	w.Flush()
+}
@@ -1,54 +1,114 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-
-	"github.com/AOSC-Dev/ciel/internal/ciel"
+	"log"
 
 	d "github.com/AOSC-Dev/ciel/display"
+	api "github.com/AOSC-Dev/ciel/pkg/ciel"
+	"github.com/AOSC-Dev/ciel/pkg/daemon"
+	"github.com/AOSC-Dev/ciel/systemd-api/nspawn"
 )
 
-func list() {
-	basePath := flagCielDir()
-	parse()
+var listOpts struct {
+	sizes bool
+}
 
-	i := &ciel.Ciel{BasePath: *basePath}
-	i.Check()
-	c := i.Container()
+func listFlags(fs *flag.FlagSet) {
+	flagCielDir(fs)
+	flagFormat(fs)
+	fs.BoolVar(&listOpts.sizes, "sizes", false, "measure the layers of instances")
+}
 
-	fmt.Printf("%s\t%s\t%s\t%s\n", "INSTANCE", "WORKDIR", "STATUS", "BOOT")
-	for _, inst := range c.GetAll() {
-		showInst := inst.Name
-		tabs := "\t\t"
-		if len(showInst) > 7 {
-			tabs = "\t"
+func list() {
+	sizes := listOpts.sizes
+	checkFormat(opts.format)
+
+	var statuses []api.Status
+	if socket := daemonSocket(); socket != "" {
+		var err error
+		statuses, err = daemon.NewClient(socket).Instances()
+		if err != nil {
+			log.Fatalln(err)
+		}
+	} else {
+		w := openWorkspace(opts.cielDir)
+		list, err := w.Instances()
+		if err != nil {
+			log.Fatalln(err)
 		}
-		if len(showInst) > 14 {
-			showInst = showInst[:12] + ".."
+		for _, inst := range list {
+			statuses = append(statuses, inst.Status(api.StatusOptions{Sizes: sizes}))
 		}
-		var fsStatus, ctnStatus, boot string
-		if inst.Running() {
-			ctnStatus = d.C0(d.GREEN, "running")
-			if inst.RunningAsBootMode() {
-				boot = d.C(d.CYAN, "yes")
-			} else {
-				boot = d.C(d.PURPLE, "no")
-			}
-		} else {
-			ctnStatus = d.C0(d.WHITE, "offline")
-		}
-		if inst.Mounted() {
+	}
+	if statuses == nil {
+		statuses = []api.Status{}
+	}
+	printStatuses(statuses, opts.format, sizes)
+}
+
+// printStatuses prints the instances in the format, with the sizes of
+// their layers if measured.
+func printStatuses(statuses []api.Status, format string, sizes bool) {
+	if format == formatJSON {
+		printJSON(statuses)
+		return
+	}
+
+	var rows [][]string
+	for _, s := range statuses {
+		fsStatus, ctnStatus := "free", d.C0(d.WHITE, "offline")
+		if s.Mounted {
 			fsStatus = d.C0(d.GREEN, "mounted")
-		} else {
-			fsStatus = "free"
 		}
-		fmt.Printf("%s%s%s\t%s\t%s\n", showInst, tabs, fsStatus, ctnStatus, boot)
+		if s.Running {
+			ctnStatus = d.C0(d.GREEN, "running")
+		}
+		var boot string
+		switch s.BootMode {
+		case api.BootModeBoot:
+			boot = d.C(d.CYAN, "yes")
+		case api.BootModeExclusive:
+			boot = d.C(d.PURPLE, "no")
+		}
+		network := s.Network
+		if network == "" {
+			network = nspawn.DefaultNetwork
+		}
+		row := []string{s.Name, fsStatus, ctnStatus, boot, network, s.Limits.String()}
+		if sizes {
+			row = append(row, formatLayerSize(s.Layers...))
+		}
+		rows = append(rows, row)
+	}
+	if format == formatPlain {
+		printPlain(rows)
+		return
 	}
+
+	header := []string{"INSTANCE", "WORKDIR", "STATUS", "BOOT", "NETWORK", "LIMITS"}
+	if sizes {
+		header = append(header, "SIZE")
+	}
+	printTable(header, rows)
 	fmt.Println()
-	count := len(c.GetAllNames())
-	if count <= 1 {
-		fmt.Printf("%d instance listed.\n", count)
+	if len(statuses) <= 1 {
+		fmt.Printf("%d instance listed.\n", len(statuses))
 	} else {
-		fmt.Printf("%d instances listed.\n", count)
+		fmt.Printf("%d instances listed.\n", len(statuses))
+	}
+}
+
+// formatLayerSize is the total size of the layers, "?" if any of them is
+// not measured.
+func formatLayerSize(layers ...api.LayerStatus) string {
+	var size int64
+	for _, layer := range layers {
+		if layer.Size < 0 {
+			return "?"
+		}
+		size += layer.Size
 	}
+	return formatSize(size)
 }
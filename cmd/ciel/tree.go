@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"log"
 	"os"
 
 	"github.com/AOSC-Dev/ciel/internal/ciel"
@@ -13,27 +14,29 @@ const (
 )
 
 func clone() {
-	basePath := flagCielDir()
-	parse()
-
-	i := &ciel.Ciel{BasePath: *basePath}
-	i.Check()
+	i := &ciel.Ciel{BasePath: opts.cielDir}
+	checkCiel(i)
 	t := i.Tree()
 
 	tree := flag.Arg(0)
 	if tree == "" {
 		tree = GitAOSCOSABBS
 	}
-	os.Exit(t.Clone(tree))
+	exitStatus, err := t.Clone(tree)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	os.Exit(exitStatus)
 }
 
 func pull() {
-	basePath := flagCielDir()
-	parse()
-
-	i := &ciel.Ciel{BasePath: *basePath}
-	i.Check()
+	i := &ciel.Ciel{BasePath: opts.cielDir}
+	checkCiel(i)
 	t := i.Tree()
 
-	os.Exit(t.Pull())
+	exitStatus, err := t.Pull()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	os.Exit(exitStatus)
 }
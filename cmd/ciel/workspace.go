@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	d "github.com/AOSC-Dev/ciel/display"
+	"github.com/AOSC-Dev/ciel/internal/ciel"
+	"github.com/AOSC-Dev/ciel/internal/container"
+	"github.com/AOSC-Dev/ciel/internal/container/instance"
+	api "github.com/AOSC-Dev/ciel/pkg/ciel"
+)
+
+// openWorkspace exits unless the workspace is usable, offering to migrate
+// it if it is an older version.
+func openWorkspace(basePath string) *api.Workspace {
+	w, err := api.Open(basePath)
+	if older, ok := err.(api.ErrOlderVersion); ok {
+		d.Println(d.C(d.YELLOW, "your Ciel work directory is an older version, it needs migrating:"))
+		for _, m := range older.Pending {
+			d.Println("\t" + m.String())
+		}
+		if !confirm(d.Question{Msg: "MIGRATE NOW?", Flag: "migrate"}) {
+			log.Fatalln(err)
+		}
+		if migrateAll(w, older.Pending) != nil {
+			os.Exit(1)
+		}
+		err = w.Check()
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return w
+}
+
+// checkCiel is openWorkspace, for commands not covered by the API.
+func checkCiel(i *ciel.Ciel) {
+	openWorkspace(i.BasePath)
+}
+
+// migrateAll applies migrations in order, stopping at the first failure.
+func migrateAll(w *api.Workspace, list []api.Migration) error {
+	for _, m := range list {
+		d.ITEM(m.String())
+		backup, err := w.Migrate(m)
+		if err != nil {
+			d.FAILED_BECAUSE(err.Error())
+			return err
+		}
+		d.OK()
+		d.Println(d.C(d.WHITE, "  backup in "+backup))
+	}
+	return nil
+}
+
+func openInstance(w *api.Workspace, name string) *api.Instance {
+	inst, err := w.Instance(name)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return inst
+}
+
+func checkInst(c *container.Container, name string) {
+	if err := c.CheckInst(name); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func allInstances(c *container.Container) []*instance.Instance {
+	instList, err := c.GetAll()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return instList
+}
+
+func allInstanceNames(c *container.Container) []string {
+	names, err := c.GetAllNames()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return names
+}
@@ -4,3 +4,10 @@ var (
 	Version = "development version"
 	Prefix  = "/usr/local/"
 )
+
+// Plugins are the executables in PluginDir named PluginPrefix followed by
+// the name of the command.
+var (
+	PluginDir    = Prefix + "/libexec/ciel-plugin"
+	PluginPrefix = "ciel-"
+)
@@ -0,0 +1,119 @@
+package d
+
+import (
+	"os"
+)
+
+// Assumption answers questions which are not asked.
+type Assumption int
+
+const (
+	NOTHING  Assumption = iota // ask, failing if stdin is not a terminal
+	DEFAULTS                   // batch mode, take the defaults of questions
+	YES                        // answer yes, but not to destructive questions
+	NO                         // answer no
+)
+
+var assumption = NOTHING
+
+func SetAssumption(a Assumption) { assumption = a }
+func GetAssumption() Assumption  { return assumption }
+
+// answers are given for questions by their flags.
+var answers = make(map[string]string)
+
+// SetAnswer answers the question with the flag, as "yes" or "no" for
+// Question, or any text for Prompt.
+func SetAnswer(flag string, answer string) {
+	answers[flag] = answer
+}
+
+// Question is a yes or no question, which may be answered by -FLAG or
+// -FLAG=false on the command line.
+type Question struct {
+	Msg     string
+	Flag    string
+	Default bool // the answer in batch mode
+	// Destructive questions are only answered yes by their flags, or by
+	// the user if nothing is assumed; batch mode and -yes fail on them.
+	Destructive bool
+}
+
+// ErrUnanswered is returned for a question which cannot be asked.
+type ErrUnanswered struct {
+	Msg         string
+	Flag        string
+	Destructive bool
+}
+
+func (e ErrUnanswered) Error() string {
+	switch {
+	case e.Destructive && assumption != NOTHING:
+		return "refusing to assume an answer to '" + e.Msg + "' as it is destructive; pass -" + e.Flag + " to confirm"
+	case e.Destructive:
+		return "cannot ask '" + e.Msg + "' as stdin is not a terminal; pass -" + e.Flag + " to confirm"
+	}
+	return "cannot ask '" + e.Msg + "' as stdin is not a terminal; pass -" + e.Flag + ", -yes, -assume-no or -batch"
+}
+
+// Interactive reports whether questions can be asked.
+func Interactive() bool {
+	return IsTerminal(os.Stdin)
+}
+
+// CONFIRM answers the question from its flag, the assumption or the user,
+// in this order.
+func CONFIRM(q Question) (bool, error) {
+	if answer, ok := answers[q.Flag]; ok {
+		return answer == "yes", nil
+	}
+	switch {
+	case assumption == NO:
+		return false, nil
+	case assumption != NOTHING && q.Destructive:
+		return false, ErrUnanswered{q.Msg, q.Flag, q.Destructive}
+	case assumption == YES:
+		return true, nil
+	case assumption == DEFAULTS:
+		return q.Default, nil
+	}
+	if !Interactive() {
+		return false, ErrUnanswered{q.Msg, q.Flag, q.Destructive}
+	}
+	for {
+		switch ASKLower(q.Msg, "yes/no") {
+		case "yes", "y":
+			return true, nil
+		case "no", "n", "":
+			return false, nil
+		}
+	}
+}
+
+// Prompt asks for a line of text, which may be given by -FLAG=TEXT on the
+// command line.
+type Prompt struct {
+	Msg     string
+	Hint    string // an example of the answer
+	Flag    string
+	Default string // the answer in batch mode or with -yes
+}
+
+// INPUT answers the prompt from its flag, the assumption or the user, in
+// this order. Empty answers are not accepted from the user.
+func INPUT(p Prompt) (string, error) {
+	if answer, ok := answers[p.Flag]; ok {
+		return answer, nil
+	}
+	if assumption != NOTHING {
+		return p.Default, nil
+	}
+	if !Interactive() {
+		return "", ErrUnanswered{Msg: p.Msg, Flag: p.Flag}
+	}
+	var answer string
+	for answer == "" {
+		answer = ASK(p.Msg, p.Hint)
+	}
+	return answer, nil
+}
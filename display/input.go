@@ -2,18 +2,20 @@ package d
 
 import (
 	"bufio"
+	"fmt"
 	"os"
 	"strings"
 )
 
+// ASK shows the question in any verbosity.
 func ASK(msg string, options string) string {
-	Println()
-	Println(strings.Repeat(" ", MaxLength-3) + C(WHITE, "=== "+msg+" ==="))
-	Print(strings.Repeat(" ", MaxLength-3) + ">>> (" + options + "): " + Clr(YELLOW))
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, strings.Repeat(" ", MaxLength-3)+C(WHITE, "=== "+msg+" ==="))
+	fmt.Fprint(os.Stderr, strings.Repeat(" ", MaxLength-3)+">>> ("+options+"): "+Clr(YELLOW))
 	buf := bufio.NewReader(os.Stdin)
 
 	answer, _, _ := buf.ReadLine()
-	Println(ClrRst())
+	fmt.Fprintln(os.Stderr, ClrRst())
 	return strings.TrimSpace(string(answer))
 }
 
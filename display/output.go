@@ -5,12 +5,29 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
 type Color int
 
 const MaxLength = 30
 
+// Verbosity is how much is shown: QUIET only shows failures, along with the
+// items failing, and VERBOSE adds DEBUG messages.
+type Verbosity int
+
+const (
+	QUIET Verbosity = iota - 1
+	NORMAL
+	VERBOSE
+)
+
+var verbosity = NORMAL
+
+func SetVerbosity(v Verbosity) { verbosity = v }
+func GetVerbosity() Verbosity  { return verbosity }
+
 const (
 	_ Color = 30 + iota
 	RED
@@ -22,8 +39,44 @@ const (
 	WHITE
 )
 
-func Println(v ...interface{}) { fmt.Fprintln(os.Stderr, v...) }
-func Print(v ...interface{})   { fmt.Fprint(os.Stderr, v...) }
+// mutex guards the output and the state below, helpers may be called from
+// several goroutines.
+var mutex sync.Mutex
+
+func Println(v ...interface{}) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if verbosity > QUIET {
+		fmt.Fprintln(os.Stderr, v...)
+	}
+}
+func Print(v ...interface{}) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if verbosity > QUIET {
+		fmt.Fprint(os.Stderr, v...)
+	}
+}
+
+// pendingItem is the last item in quiet mode, shown if it fails.
+var pendingItem string
+
+// alert shows a failure in any verbosity.
+func alert(s string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	fmt.Fprint(os.Stderr, pendingItem)
+	pendingItem = ""
+	fmt.Fprintln(os.Stderr, s)
+}
+
+// DEBUG shows a message in verbose mode.
+func DEBUG(s string) {
+	if verbosity >= VERBOSE {
+		ITEM("debug")
+		Println(C0(WHITE, s))
+	}
+}
 
 func C0(cid Color, s string) string {
 	return Clr0(cid) + s + ClrRst()
@@ -32,18 +85,27 @@ func C(cid Color, s string) string {
 	return Clr(cid) + s + ClrRst()
 }
 func Clr0(cid Color) string {
+	if !colored {
+		return ""
+	}
 	if cid == WHITE {
 		return "\033[0m"
 	}
 	return "\033[0;" + strconv.Itoa(int(cid)) + "m"
 }
 func Clr(cid Color) string {
+	if !colored {
+		return ""
+	}
 	if cid == WHITE {
 		return "\033[1m"
 	}
 	return "\033[1;" + strconv.Itoa(int(cid)) + "m"
 }
 func ClrRst() string {
+	if !colored {
+		return ""
+	}
 	return "\033[0m"
 }
 
@@ -87,26 +149,46 @@ func StripEsc(s string) string {
 	return pt
 }
 
+// EscLen is the number of characters shown for s.
 func EscLen(s string) int {
 	plainText := StripEsc(s)
-	return len(plainText)
+	return utf8.RuneCountInString(plainText)
+}
+
+// Truncate cuts s to n characters, ending with "..", dropping escapes if
+// it is cut.
+func Truncate(s string, n int) string {
+	if EscLen(s) <= n {
+		return s
+	}
+	runes := []rune(StripEsc(s))
+	if n < 2 {
+		return string(runes[:n])
+	}
+	return string(runes[:n-2]) + ".."
 }
 
 func ITEM(s string) {
-	l := MaxLength - EscLen(s)
-	if l < 0 {
-		l = 0
-		s = s[:MaxLength-2] + ".."
+	s = Truncate(s, MaxLength)
+	item := strings.Repeat(" ", MaxLength-EscLen(s)) + s + " "
+	mutex.Lock()
+	defer mutex.Unlock()
+	if verbosity <= QUIET {
+		pendingItem = item
+		return
 	}
-	Print(strings.Repeat(" ", l) + s + " ")
+	pendingItem = ""
+	fmt.Fprint(os.Stderr, item)
 }
 
 var firstSection = true
 
 func SECTION(s string) {
-	if firstSection {
-		firstSection = false
-	} else {
+	mutex.Lock()
+	first := firstSection
+	firstSection = false
+	mutex.Unlock()
+	if !first {
 		Println()
 	}
 	Println(strings.Repeat(" ", MaxLength+1) + C(WHITE, s))
@@ -117,11 +199,11 @@ func OK() {
 }
 
 func FAILED() {
-	Println(C(RED, "FAILED"))
+	alert(C(RED, "FAILED"))
 }
 
 func FAILED_BECAUSE(s string) {
-	Println(C(RED, s))
+	alert(C(RED, s))
 }
 
 func SKIPPED() {
@@ -140,6 +222,6 @@ func WARN(err error) {
 	if err == nil {
 		OK()
 	} else {
-		Println(C(YELLOW, err.Error()))
+		alert(C(YELLOW, err.Error()))
 	}
 }
@@ -0,0 +1,59 @@
+package d
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Color modes, auto colors terminals unless NO_COLOR is set.
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+var ErrColorMode = errors.New("invalid color mode, expecting auto, always or never")
+
+var colorMode = ColorAuto
+
+// colored caches whether the messages on stderr are colored.
+var colored = Colored(os.Stderr)
+
+func SetColorMode(mode string) error {
+	switch mode {
+	case ColorAuto, ColorAlways, ColorNever:
+	default:
+		return ErrColorMode
+	}
+	colorMode = mode
+	colored = Colored(os.Stderr)
+	return nil
+}
+
+func ColorMode() string { return colorMode }
+
+// Colored reports whether output to f should be colored.
+func Colored(f *os.File) bool {
+	switch colorMode {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return IsTerminal(f)
+}
+
+func IsTerminal(f *os.File) bool {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(),
+		syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+	return errno == 0
+}
@@ -2,4 +2,4 @@ module github.com/AOSC-Dev/ciel
 
 go 1.13
 
-require github.com/godbus/dbus/v5 v5.0.3
+require github.com/godbus/dbus/v5 v5.1.0
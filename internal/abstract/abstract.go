@@ -1,10 +1,13 @@
 package abstract
 
+import "github.com/AOSC-Dev/ciel/internal/mounts"
+
 type Ciel interface {
 	GetBasePath() string
-	GetTree() Tree
-	GetOutput() Tree
 	GetContainer() Container
+	ID() string
+	Mounts() (mounts.Registry, error)
+	DefaultNetwork() (string, error)
 }
 
 type Container interface {
@@ -17,7 +20,3 @@ type Instance interface {
 	MountPoint() string
 	GetContainer() Container
 }
-
-type Tree interface {
-	MountHandler(instance Instance, mount bool)
-}
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/AOSC-Dev/ciel/internal/abstract"
+	"github.com/AOSC-Dev/ciel/internal/mounts"
+)
+
+// Defaults maps names of caches to where they are mounted in instances,
+// used if the workspace configuration does not list caches.
+var Defaults = map[string]string{
+	"sources": "/var/cache/acbs/tarballs",
+	"ccache":  "/root/.ccache",
+	"sccache": "/root/.cache/sccache",
+	"cargo":   "/root/.cargo/registry",
+	"go":      "/root/go/pkg/mod",
+}
+
+// Cache is a directory in the workspace, shared by all instances, which
+// survives rollbacks.
+type Cache struct {
+	Name   string
+	Dir    string // directory in the workspace
+	Target string // mount point in instances
+}
+
+// Caches are the caches of a workspace, mounted in every instance.
+type Caches struct {
+	Parent   abstract.Ciel
+	BasePath string
+	Targets  map[string]string
+}
+
+// List returns caches sorted by name.
+func (c *Caches) List() []Cache {
+	var list []Cache
+	for name, target := range c.Targets {
+		if target == "" {
+			continue
+		}
+		list = append(list, Cache{
+			Name:   name,
+			Dir:    path.Join(c.BasePath, name),
+			Target: target,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Get returns the cache named name.
+func (c *Caches) Get(name string) (Cache, bool) {
+	for _, cache := range c.List() {
+		if cache.Name == name {
+			return cache, true
+		}
+	}
+	return Cache{}, false
+}
+
+// Mounts binds the caches into instances.
+func (c *Caches) Mounts() mounts.Registry {
+	var r mounts.Registry
+	for _, cache := range c.List() {
+		r = append(r, mounts.Entry{
+			Name:   "cache " + cache.Name,
+			Source: cache.Dir,
+			Target: cache.Target,
+			Create: true,
+		})
+	}
+	return r
+}
+
+// Stats returns the number of files in the cache and their total size.
+func (c Cache) Stats() (files int, size int64, err error) {
+	err = filepath.Walk(c.Dir, func(p string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			files++
+			size += info.Size()
+		}
+		return nil
+	})
+	return files, size, err
+}
+
+// Clear removes everything in the cache.
+func (c Cache) Clear() error {
+	list, err := ioutil.ReadDir(c.Dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, fi := range list {
+		if err := os.RemoveAll(path.Join(c.Dir, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
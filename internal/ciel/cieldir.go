@@ -1,41 +1,50 @@
 package ciel
 
 import (
-	"io/ioutil"
-	"log"
+	"fmt"
+	"os"
 	"path"
-	"strings"
 
 	"github.com/AOSC-Dev/ciel/internal/abstract"
+	"github.com/AOSC-Dev/ciel/internal/cache"
 	"github.com/AOSC-Dev/ciel/internal/container"
+	"github.com/AOSC-Dev/ciel/internal/mounts"
 	"github.com/AOSC-Dev/ciel/internal/packaging"
 	"github.com/AOSC-Dev/ciel/internal/pkgtree"
-	"github.com/AOSC-Dev/ciel/internal/utils"
 )
 
 const (
 	DotCielDirName = ".ciel"
 
 	ContainerDirName = DotCielDirName + "/container"
+	CacheDirName     = DotCielDirName + "/cache"
 	TreeDirName      = "TREE"
-	OutputDirName    = "OUTPUT/debs"
+	OutputDirName    = "OUTPUT"
 
 	VersionFile = DotCielDirName + "/version"
-	Version     = "2"
+	Version     = 4
 )
 
 type Ciel struct {
 	BasePath string
+
+	id string
 }
 
-func (i *Ciel) Check() {
-	ver, err := ioutil.ReadFile(i.VerFile())
+// Check returns an error unless the workspace is usable, ErrOlderVersion
+// if it needs migrating.
+func (i *Ciel) Check() error {
+	pending, err := i.PendingMigrations()
 	if err != nil {
-		log.Fatalln("not a Ciel work directory here")
+		return err
+	}
+	if len(pending) != 0 {
+		return ErrOlderVersion{pending}
 	}
-	if strings.TrimSpace(string(ver)) != Version {
-		log.Fatalln("your Ciel work directory is an incompatible version")
+	if err := i.loadID(); err != nil {
+		return fmt.Errorf("workspace ID: %w", err)
 	}
+	return nil
 }
 func (i *Ciel) CielDir() string {
 	return path.Join(i.BasePath, DotCielDirName)
@@ -52,13 +61,21 @@ func (i *Ciel) treeDir() string {
 func (i *Ciel) outDir() string {
 	return path.Join(i.BasePath, OutputDirName)
 }
+func (i *Ciel) cacheDir() string {
+	return path.Join(i.BasePath, CacheDirName)
+}
 
-func (i *Ciel) Init() {
-	utils.MustMkdir(i.CielDir())
-	if err := ioutil.WriteFile(i.VerFile(), []byte(Version), 0644); err != nil {
-		log.Panic(err)
+func (i *Ciel) Init() error {
+	if err := os.Mkdir(i.CielDir(), 0755); err != nil {
+		return err
+	}
+	if err := i.writeVersion(Version); err != nil {
+		return err
+	}
+	if err := i.initID(); err != nil {
+		return err
 	}
-	i.Container().Init()
+	return i.Container().Init()
 }
 
 func (i *Ciel) Container() *container.Container {
@@ -70,13 +87,42 @@ func (i *Ciel) Tree() *pkgtree.Tree {
 func (i *Ciel) Output() *packaging.Tree {
 	return &packaging.Tree{Parent: i, BasePath: i.outDir()}
 }
-func (i *Ciel) GetContainer() abstract.Container {
-	return i.Container()
+
+// Caches returns the caches configured for the workspace, or the default
+// ones.
+func (i *Ciel) Caches() (*cache.Caches, error) {
+	config, err := i.Config()
+	if err != nil {
+		return nil, err
+	}
+	targets := cache.Defaults
+	if config.Caches != nil {
+		targets = config.Caches
+	}
+	return &cache.Caches{Parent: i, BasePath: i.cacheDir(), Targets: targets}, nil
 }
-func (i *Ciel) GetTree() abstract.Tree {
-	return i.Tree()
+
+// Mounts returns bind mounts of every instance: TREE, OUTPUT, caches, then
+// the ones in the workspace configuration.
+func (i *Ciel) Mounts() (mounts.Registry, error) {
+	config, err := i.Config()
+	if err != nil {
+		return nil, err
+	}
+	caches, err := i.Caches()
+	if err != nil {
+		return nil, err
+	}
+	var r mounts.Registry
+	r = append(r, i.Tree().Mounts()...)
+	r = append(r, i.Output().Mounts()...)
+	r = append(r, caches.Mounts()...)
+	for _, e := range config.Mounts {
+		r = append(r, e.Resolve(i.BasePath))
+	}
+	return r, nil
 }
-func (i *Ciel) GetOutput() abstract.Tree {
-	return i.Output()
+func (i *Ciel) GetContainer() abstract.Container {
+	return i.Container()
 }
 func (i *Ciel) GetBasePath() string { return i.BasePath }
@@ -0,0 +1,80 @@
+package ciel
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/AOSC-Dev/ciel/internal/mounts"
+)
+
+const (
+	ConfigFile = DotCielDirName + "/config.json"
+)
+
+// Config holds workspace-wide defaults, it is optional.
+type Config struct {
+	Build BuildConfig `json:"build"`
+	// Caches maps names of caches to where they are mounted in instances,
+	// cache.Defaults are used if it is absent.
+	Caches map[string]string `json:"caches,omitempty"`
+	// Mounts are bound into every instance, after the built-in ones.
+	Mounts mounts.Registry `json:"mounts,omitempty"`
+	// Network is the network mode of instances without one of their own,
+	// see nspawn.ParseNetwork.
+	Network string `json:"network,omitempty"`
+}
+
+// BuildConfig holds defaults of 'ciel build'.
+type BuildConfig struct {
+	// Clean rolls the instance back before each build.
+	Clean bool `json:"clean"`
+	// CleanAfter rolls the instance back after each build.
+	CleanAfter bool `json:"clean_after"`
+	// Baseline is the baseline to roll back to, instead of the underlying OS.
+	Baseline string `json:"baseline,omitempty"`
+	// Hermetic fetches sources with network, then compiles without.
+	Hermetic bool `json:"hermetic,omitempty"`
+	// FetchNetwork is the network mode hermetic builds fetch with, the one
+	// of the build if empty.
+	FetchNetwork string `json:"fetch_network,omitempty"`
+}
+
+func (i *Ciel) ConfigFile() string {
+	return path.Join(i.BasePath, ConfigFile)
+}
+
+// Config reads the workspace configuration, a missing file means defaults.
+func (i *Ciel) Config() (*Config, error) {
+	config := &Config{}
+	b, err := ioutil.ReadFile(i.ConfigFile())
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// SaveConfig writes the workspace configuration.
+func (i *Ciel) SaveConfig(config *Config) error {
+	b, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(i.ConfigFile(), append(b, '\n'), 0644)
+}
+
+// DefaultNetwork returns the network mode of instances without one of their
+// own, empty for the built-in default.
+func (i *Ciel) DefaultNetwork() (string, error) {
+	config, err := i.Config()
+	if err != nil {
+		return "", err
+	}
+	return config.Network, nil
+}
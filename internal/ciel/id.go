@@ -0,0 +1,110 @@
+package ciel
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/AOSC-Dev/ciel/ipc"
+	"github.com/AOSC-Dev/ciel/systemd-api/machined"
+)
+
+const (
+	IDFile = DotCielDirName + "/id"
+	IDSize = 8 // bytes
+)
+
+// The workspace ID is generated at 'ciel init', machine names of instances
+// are derived from it, so that they stay the same if the workspace moves.
+
+func (i *Ciel) IDFile() string {
+	return path.Join(i.BasePath, IDFile)
+}
+
+func newID() (string, error) {
+	b := make([]byte, IDSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ID returns the workspace ID, it is read in Check. Workspaces from before
+// it was introduced get the key of the workspace path until migrated.
+func (i *Ciel) ID() string {
+	if i.id == "" && i.loadID() != nil {
+		id, _ := i.legacyID()
+		return id
+	}
+	return i.id
+}
+
+func (i *Ciel) loadID() error {
+	b, err := ioutil.ReadFile(i.IDFile())
+	if err != nil {
+		return err
+	}
+	i.id = strings.TrimSpace(string(b))
+	return nil
+}
+
+func (i *Ciel) legacyID() (string, error) {
+	key, err := ipc.GenFileKey(i.BasePath, 0)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", key), nil
+}
+
+func (i *Ciel) initID() error {
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(i.IDFile(), []byte(id+"\n"), 0644); err != nil {
+		return err
+	}
+	i.id = id
+	return nil
+}
+
+// checkMigrateID refuses to migrate while instances run under machine
+// names from the old scheme, they could not be stopped afterwards.
+func (i *Ciel) checkMigrateID() error {
+	m, err := machined.NewManager()
+	if err != nil {
+		return err
+	}
+	names, err := i.Container().GetAllNames()
+	if err != nil {
+		return err
+	}
+	legacyID, err := i.legacyID()
+	if err != nil {
+		return err
+	}
+	var running []string
+	for _, name := range names {
+		machineId := name + "-" + legacyID
+		if _, err := m.GetMachine(machineId); err == nil {
+			running = append(running, machineId)
+		}
+	}
+	if len(running) != 0 {
+		return fmt.Errorf("instances are running under old machine names, stop them first: %s",
+			strings.Join(running, " "))
+	}
+	return nil
+}
+
+// migrateID generates the workspace ID for a workspace without one.
+func (i *Ciel) migrateID() error {
+	if _, err := os.Stat(i.IDFile()); err == nil {
+		return nil
+	}
+	return i.initID()
+}
@@ -0,0 +1,177 @@
+package ciel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AOSC-Dev/ciel/internal/packaging"
+)
+
+const BackupDirName = DotCielDirName + "/backup"
+
+var ErrNotCiel = errors.New("not a Ciel work directory here")
+
+// ErrNewerVersion is returned for workspaces written by a newer Ciel.
+type ErrNewerVersion struct {
+	Version int
+}
+
+func (e ErrNewerVersion) Error() string {
+	return fmt.Sprintf("your Ciel work directory is version %d, newer than this Ciel supports (%d), please upgrade Ciel",
+		e.Version, Version)
+}
+
+// ErrOlderVersion is returned for workspaces which need migrating.
+type ErrOlderVersion struct {
+	Pending []Migration
+}
+
+func (e ErrOlderVersion) Error() string {
+	return "your Ciel work directory is an older version, see 'ciel migrate'"
+}
+
+// Migration upgrades a workspace from version From to From+1.
+type Migration struct {
+	From    int
+	Summary string
+	// Files are backed up before applying, relative to the workspace.
+	Files []string
+	// Check tells whether the migration can be applied now, it must not
+	// change anything.
+	Check func(i *Ciel) error
+	Apply func(i *Ciel) error
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%d -> %d: %s", m.From, m.From+1, m.Summary)
+}
+
+// Migrations must be kept in order, one for each version since 2.
+var Migrations = []Migration{
+	{
+		From:    2,
+		Summary: "generate the workspace ID for machine names",
+		Files:   []string{VersionFile},
+		Check:   (*Ciel).checkMigrateID,
+		Apply:   (*Ciel).migrateID,
+	},
+	{
+		From:    3,
+		Summary: "move artifacts of OUTPUT/debs into the pool of suite " + packaging.DefaultSuite,
+		// artifacts are renamed into the pool, never copied or deleted,
+		// only the indices of the flat repository are
+		Files: legacyIndexFiles(),
+		Check: (*Ciel).checkMigrateOutput,
+		Apply: (*Ciel).migrateOutput,
+	},
+}
+
+// WorkspaceVersion reads the version of the workspace on disk.
+func (i *Ciel) WorkspaceVersion() (int, error) {
+	b, err := ioutil.ReadFile(i.VerFile())
+	if os.IsNotExist(err) {
+		return 0, ErrNotCiel
+	} else if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("bad version of Ciel work directory: %v", err)
+	}
+	return v, nil
+}
+
+func (i *Ciel) writeVersion(v int) error {
+	return ioutil.WriteFile(i.VerFile(), []byte(strconv.Itoa(v)), 0644)
+}
+
+// PendingMigrations returns the migrations needed to bring the workspace
+// to the current version.
+func (i *Ciel) PendingMigrations() ([]Migration, error) {
+	v, err := i.WorkspaceVersion()
+	if err != nil {
+		return nil, err
+	}
+	if v > Version {
+		return nil, ErrNewerVersion{v}
+	}
+	var list []Migration
+	next := v
+	for _, m := range Migrations {
+		if m.From < v {
+			continue
+		}
+		if m.From != next {
+			break
+		}
+		list = append(list, m)
+		next++
+	}
+	if next != Version {
+		return nil, fmt.Errorf("no migration from version %d of Ciel work directory", next)
+	}
+	return list, nil
+}
+
+// Migrate backs up the files of m, applies it, then records the new
+// version. It returns the backup directory.
+func (i *Ciel) Migrate(m Migration) (string, error) {
+	if err := m.Check(i); err != nil {
+		return "", err
+	}
+	backup, err := i.backup(m)
+	if err != nil {
+		return "", err
+	}
+	if err := m.Apply(i); err != nil {
+		return backup, err
+	}
+	return backup, i.writeVersion(m.From + 1)
+}
+
+func (i *Ciel) backup(m Migration) (string, error) {
+	dir := path.Join(i.BasePath, BackupDirName,
+		fmt.Sprintf("v%d-%s", m.From, time.Now().Format("20060102-150405")))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	for _, file := range m.Files {
+		dst := path.Join(dir, file)
+		if err := os.MkdirAll(path.Dir(dst), 0755); err != nil {
+			return "", err
+		}
+		err := copyFile(path.Join(i.BasePath, file), dst)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
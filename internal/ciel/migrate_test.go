@@ -0,0 +1,89 @@
+package ciel
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func froms(list []Migration) []int {
+	var out []int
+	for _, m := range list {
+		out = append(out, m.From)
+	}
+	return out
+}
+
+func TestPendingMigrations(t *testing.T) {
+	i := tempWorkspace(t)
+	defer os.RemoveAll(i.BasePath)
+	touch(t, i.VerFile())
+	defer func(m []Migration) { Migrations = m }(Migrations)
+
+	var all, gap []Migration
+	for v := 2; v < Version; v++ {
+		all = append(all, Migration{From: v})
+		if v != Version-1 {
+			gap = append(gap, Migration{From: v})
+		}
+	}
+	tests := []struct {
+		name       string
+		version    string
+		migrations []Migration
+		want       []int
+		err        bool
+	}{
+		{"current", strconv.Itoa(Version), all, nil, false},
+		{"previous", strconv.Itoa(Version - 1), all, []int{Version - 1}, false},
+		{"oldest", "2", all, froms(all), false},
+		{"newline", strconv.Itoa(Version) + "\n", all, nil, false},
+		{"newer", strconv.Itoa(Version + 1), all, nil, true},
+		{"unsupported", "1", all, nil, true},
+		{"gap", "2", gap, nil, true},
+		{"garbage", "two", all, nil, true},
+	}
+	for _, test := range tests {
+		if err := ioutil.WriteFile(i.VerFile(), []byte(test.version), 0644); err != nil {
+			t.Fatal(err)
+		}
+		Migrations = test.migrations
+		list, err := i.PendingMigrations()
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: no error, got %v", test.name, froms(list))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := froms(list); !equalInts(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	Migrations = all
+	ioutil.WriteFile(i.VerFile(), []byte(strconv.Itoa(Version+1)), 0644)
+	if _, err := i.PendingMigrations(); err != (ErrNewerVersion{Version + 1}) {
+		t.Errorf("newer version returns %v", err)
+	}
+	os.Remove(i.VerFile())
+	if _, err := i.PendingMigrations(); err != ErrNotCiel {
+		t.Errorf("missing version returns %v", err)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if a[k] != b[k] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+package ciel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/AOSC-Dev/ciel/internal/packaging"
+)
+
+// LegacyOutputDirName is where artifacts were put before OUTPUT was laid
+// out as a repository, with the indices of a flat repository.
+const LegacyOutputDirName = OutputDirName + "/debs"
+
+// legacyIndices are the files of the flat repository, regenerated for the
+// pool instead of being moved into it.
+var legacyIndices = map[string]bool{
+	"Packages":    true,
+	"Packages.gz": true,
+	"Release":     true,
+	"InRelease":   true,
+}
+
+// legacyIndexFiles are the indices removed by migrateOutput, relative to
+// the workspace, to be backed up.
+func legacyIndexFiles() []string {
+	var files []string
+	for name := range legacyIndices {
+		files = append(files, path.Join(LegacyOutputDirName, name))
+	}
+	sort.Strings(files)
+	return files
+}
+
+func (i *Ciel) legacyOutDir() string {
+	return path.Join(i.BasePath, LegacyOutputDirName)
+}
+
+// checkMigrateOutput refuses to mix both layouts of OUTPUT, and to move
+// artifacts while instances have them mounted.
+func (i *Ciel) checkMigrateOutput() error {
+	if _, err := os.Stat(i.legacyOutDir()); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	pool := path.Join(i.outDir(), packaging.PoolDirName)
+	if _, err := os.Stat(pool); err == nil {
+		return fmt.Errorf("both %s and %s exist, merge them by hand first",
+			LegacyOutputDirName, path.Join(OutputDirName, packaging.PoolDirName))
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	names, err := i.Container().GetAllNames()
+	if err != nil {
+		return err
+	}
+	var mounted []string
+	for _, name := range names {
+		if i.Container().Instance(name).Mounted() {
+			mounted = append(mounted, name)
+		}
+	}
+	if len(mounted) != 0 {
+		return fmt.Errorf("instances are mounted, unmount them first: %s",
+			strings.Join(mounted, " "))
+	}
+	return nil
+}
+
+// migrateOutput moves artifacts of OUTPUT/debs into the pool of the
+// default suite, then generates its indices. Artifacts are renamed within
+// OUTPUT, so none is lost if it fails halfway, and running it again
+// finishes the job.
+func (i *Ciel) migrateOutput() error {
+	output := i.Output()
+	pool := output.PoolDir(packaging.DefaultSuite)
+	if err := i.moveLegacyOutput(pool); err != nil {
+		return err
+	}
+	list, err := ioutil.ReadDir(pool)
+	if os.IsNotExist(err) || len(list) == 0 {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path.Join(output.SuiteDir(packaging.DefaultSuite), "InRelease")); err == nil {
+		return nil
+	}
+	exitStatus, err := packaging.RefreshLocalRepo(output.BasePath, packaging.DefaultSuite, false)
+	if err != nil {
+		return fmt.Errorf("regenerate indices of %s: %w", packaging.DefaultSuite, err)
+	}
+	if exitStatus != 0 {
+		return fmt.Errorf("regenerate indices of %s: ciel-localrepo exited with status %d",
+			packaging.DefaultSuite, exitStatus)
+	}
+	return nil
+}
+
+func (i *Ciel) moveLegacyOutput(pool string) error {
+	legacy := i.legacyOutDir()
+	list, err := ioutil.ReadDir(legacy)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(pool, 0755); err != nil {
+		return err
+	}
+	for _, fi := range list {
+		src := path.Join(legacy, fi.Name())
+		if legacyIndices[fi.Name()] {
+			if err := os.Remove(src); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.Rename(src, path.Join(pool, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return os.Remove(legacy)
+}
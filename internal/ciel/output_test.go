@@ -0,0 +1,89 @@
+package ciel
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/AOSC-Dev/ciel/internal/packaging"
+)
+
+func tempWorkspace(t *testing.T) *Ciel {
+	dir, err := ioutil.TempDir("", "ciel-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Ciel{BasePath: dir}
+}
+
+func touch(t *testing.T, file string) {
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
+
+func TestMigrateOutput(t *testing.T) {
+	i := tempWorkspace(t)
+	defer os.RemoveAll(i.BasePath)
+	refreshed := path.Join(i.BasePath, "refreshed")
+	plugin := path.Join(i.BasePath, "ciel-localrepo")
+	script := "#!/bin/sh\ntouch " + refreshed + "\n"
+	if err := ioutil.WriteFile(plugin, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(p string) { packaging.LocalRepoPlugin = p }(packaging.LocalRepoPlugin)
+	packaging.LocalRepoPlugin = plugin
+
+	legacy := i.legacyOutDir()
+	touch(t, path.Join(legacy, "main", "foo_1.0_amd64.deb"))
+	touch(t, path.Join(legacy, "Packages"))
+	touch(t, path.Join(legacy, "InRelease"))
+	if err := i.migrateOutput(); err != nil {
+		t.Fatal(err)
+	}
+	pool := i.Output().PoolDir(packaging.DefaultSuite)
+	if !exists(path.Join(pool, "main", "foo_1.0_amd64.deb")) {
+		t.Error("artifact not moved into the pool")
+	}
+	if exists(path.Join(pool, "Packages")) || exists(path.Join(pool, "InRelease")) {
+		t.Error("indices of the flat repository moved into the pool")
+	}
+	if exists(legacy) {
+		t.Error("legacy output directory not removed")
+	}
+	if !exists(refreshed) {
+		t.Error("indices not regenerated")
+	}
+}
+
+func TestCheckMigrateOutputBothLayouts(t *testing.T) {
+	i := tempWorkspace(t)
+	defer os.RemoveAll(i.BasePath)
+	touch(t, path.Join(i.legacyOutDir(), "foo_1.0_amd64.deb"))
+	touch(t, path.Join(i.Output().PoolDir(packaging.DefaultSuite), "bar_1.0_amd64.deb"))
+	if err := i.checkMigrateOutput(); err == nil {
+		t.Error("both layouts of OUTPUT accepted")
+	}
+}
+
+func TestBackupLegacyIndices(t *testing.T) {
+	i := tempWorkspace(t)
+	defer os.RemoveAll(i.BasePath)
+	touch(t, path.Join(i.legacyOutDir(), "Packages"))
+	backup, err := i.backup(Migrations[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists(path.Join(backup, LegacyOutputDirName, "Packages")) {
+		t.Error("index of the flat repository not backed up")
+	}
+}
@@ -2,15 +2,16 @@ package container
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/AOSC-Dev/ciel/internal/abstract"
 	"github.com/AOSC-Dev/ciel/internal/container/instance"
-	"github.com/AOSC-Dev/ciel/internal/utils"
+	"github.com/AOSC-Dev/ciel/systemd-api/machined"
 )
 
 const (
@@ -20,8 +21,18 @@ const (
 
 var (
 	ErrInvalidInstName = errors.New("invalid instance name")
+	ErrNoInstName      = errors.New("you must specify a instance")
 )
 
+// ErrNoSuchInstance is returned for instances not in the workspace.
+type ErrNoSuchInstance struct {
+	Name string
+}
+
+func (e ErrNoSuchInstance) Error() string {
+	return "instance '" + e.Name + "' does not exist"
+}
+
 type Container struct {
 	Parent   abstract.Ciel
 	BasePath string
@@ -34,10 +45,13 @@ func (i *Container) InstDir() string {
 	return path.Join(i.BasePath, InstDirName)
 }
 
-func (i *Container) Init() {
-	utils.MustMkdir(i.BasePath)
-	utils.MustMkdir(i.DistDir())
-	utils.MustMkdir(i.InstDir())
+func (i *Container) Init() error {
+	for _, dir := range []string{i.BasePath, i.DistDir(), i.InstDir()} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (i *Container) Instance(name string) *instance.Instance {
@@ -48,12 +62,12 @@ func (i *Container) AddInst(name string) error {
 	if strings.ContainsAny(name, "/\\ ") {
 		return ErrInvalidInstName
 	}
-	utils.MustMkdir(path.Join(i.InstDir(), name))
+	if err := os.Mkdir(path.Join(i.InstDir(), name), 0755); err != nil {
+		return err
+	}
 	return i.Instance(name).Init()
 }
 func (i *Container) DelInst(name string) error {
-	i.Instance(name).RunLock().Remove()
-	i.Instance(name).FileSystemLock().Remove()
 	return os.RemoveAll(path.Join(i.InstDir(), name))
 }
 
@@ -70,27 +84,31 @@ func (i *Container) InstExists(name string) bool {
 	}
 	return true
 }
-func (i *Container) CheckInst(name string) {
+func (i *Container) CheckInst(name string) error {
 	if name == "" {
-		log.Fatalln("you must specify a instance")
+		return ErrNoInstName
 	}
 	if !i.InstExists(name) {
-		log.Fatalln("instance '" + name + "' does not exist")
+		return ErrNoSuchInstance{name}
 	}
+	return nil
 }
 
-func (i *Container) GetAll() []*instance.Instance {
-	list := i.GetAllNames()
+func (i *Container) GetAll() ([]*instance.Instance, error) {
+	list, err := i.GetAllNames()
+	if err != nil {
+		return nil, err
+	}
 	var instList []*instance.Instance
 	for _, name := range list {
 		instList = append(instList, i.Instance(name))
 	}
-	return instList
+	return instList, nil
 }
-func (i *Container) GetAllNames() []string {
+func (i *Container) GetAllNames() ([]string, error) {
 	subDirs, err := ioutil.ReadDir(i.InstDir())
 	if err != nil {
-		log.Panic(err)
+		return nil, fmt.Errorf("list instances: %w", err)
 	}
 	var subDirNames []string
 	for _, subDirs := range subDirs {
@@ -98,7 +116,48 @@ func (i *Container) GetAllNames() []string {
 			subDirNames = append(subDirNames, subDirs.Name())
 		}
 	}
-	return subDirNames
+	return subDirNames, nil
+}
+
+// StrayMachines returns running machines rooted in the workspace, which do
+// not belong to any instance.
+func (i *Container) StrayMachines() ([]string, error) {
+	m, err := machined.NewManager()
+	if err != nil {
+		return nil, err
+	}
+	list, err := m.ListMachines()
+	if err != nil {
+		return nil, err
+	}
+	base, err := filepath.Abs(i.Parent.GetBasePath())
+	if err != nil {
+		return nil, err
+	}
+	instList, err := i.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool)
+	for _, inst := range instList {
+		known[inst.MachineId()] = true
+	}
+	var stray []string
+	for _, info := range list {
+		if known[info.Name] {
+			continue
+		}
+		machine, err := machined.NewMachine(info.Path)
+		if err != nil {
+			return nil, err
+		}
+		root, err := machine.RootDirectory()
+		if err != nil || path.Dir(root) != base {
+			continue
+		}
+		stray = append(stray, info.Name)
+	}
+	return stray, nil
 }
 
 func (i *Container) GetBasePath() string    { return i.BasePath }
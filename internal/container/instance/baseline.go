@@ -0,0 +1,126 @@
+package instance
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"unicode"
+)
+
+const (
+	BaselineDirName = "baselines"
+)
+
+var (
+	ErrBusy             = errors.New("instance is held by another process")
+	ErrInvalidBaseline  = errors.New("invalid baseline name")
+	ErrBaselineNotFound = errors.New("baseline does not exist")
+)
+
+// A baseline is a saved copy of the diff layer of an instance, which the
+// instance can be rolled back to instead of the underlying OS.
+
+func (i *Instance) BaselineDir(name string) string {
+	return path.Join(i.Dir(), LayerDirName, BaselineDirName, name)
+}
+
+func (i *Instance) diffDir() string {
+	return path.Join(i.Dir(), LayerDirName, "diff")
+}
+
+func (i *Instance) Baselines() ([]string, error) {
+	list, err := ioutil.ReadDir(path.Join(i.Dir(), LayerDirName, BaselineDirName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range list {
+		if fi.IsDir() {
+			names = append(names, fi.Name())
+		}
+	}
+	return names, nil
+}
+
+// validBaselineName tells whether name can be used as a directory name in
+// the baselines of an instance.
+func validBaselineName(name string) bool {
+	return name != "" && name != "." && name != ".." &&
+		!strings.ContainsAny(name, "/\\") &&
+		strings.IndexFunc(name, unicode.IsSpace) < 0
+}
+
+// CheckBaseline returns ErrInvalidBaseline if name cannot be a baseline.
+func CheckBaseline(name string) error {
+	if !validBaselineName(name) {
+		return ErrInvalidBaseline
+	}
+	return nil
+}
+
+// SaveBaseline saves the current changes of the instance as a baseline,
+// the instance must be unmounted.
+func (i *Instance) SaveBaseline(name string) error {
+	if !validBaselineName(name) {
+		return ErrInvalidBaseline
+	}
+	if i.Mounted() {
+		return ErrBusy
+	}
+	dir := i.BaselineDir(name)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return copyTree(i.diffDir(), dir)
+}
+
+// RemoveBaseline deletes a baseline.
+func (i *Instance) RemoveBaseline(name string) error {
+	if !validBaselineName(name) {
+		return ErrInvalidBaseline
+	}
+	return os.RemoveAll(i.BaselineDir(name))
+}
+
+// RollbackTo discards all changes of the instance, then restores the
+// baseline if its name is not empty. The instance must be unmounted.
+func (i *Instance) RollbackTo(baseline string) error {
+	if baseline != "" && !validBaselineName(baseline) {
+		return ErrInvalidBaseline
+	}
+	if i.Mounted() {
+		return ErrBusy
+	}
+	if baseline != "" {
+		if _, err := os.Stat(i.BaselineDir(baseline)); os.IsNotExist(err) {
+			return ErrBaselineNotFound
+		}
+	}
+	if err := i.FileSystem().Rollback(); err != nil {
+		return err
+	}
+	if baseline == "" {
+		return nil
+	}
+	return copyTree(i.BaselineDir(baseline), i.diffDir())
+}
+
+// copyTree copies the content of a layer, keeping whiteouts and extended
+// attributes which overlayfs relies on.
+func copyTree(src, dst string) error {
+	output, err := exec.Command("cp", "-a", src+"/.", dst).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(output))
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package instance
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestValidBaselineName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"clean", true},
+		{"gcc-9.3", true},
+		{".hidden", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"a/b", false},
+		{`a\b`, false},
+		{"a b", false},
+		{"a\tb", false},
+		{"a\n", false},
+	}
+	for _, test := range tests {
+		if got := validBaselineName(test.name); got != test.want {
+			t.Errorf("validBaselineName(%q) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestBaselineDotDot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ciel-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	i := &Instance{BasePath: dir, Name: "a"}
+	layer := path.Join(i.Dir(), LayerDirName, "diff")
+	if err := os.MkdirAll(layer, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.RemoveBaseline(".."); err != ErrInvalidBaseline {
+		t.Errorf("RemoveBaseline(..): %v", err)
+	}
+	if err := i.SaveBaseline(".."); err != ErrInvalidBaseline {
+		t.Errorf("SaveBaseline(..): %v", err)
+	}
+	if err := i.RollbackTo(".."); err != ErrInvalidBaseline {
+		t.Errorf("RollbackTo(..): %v", err)
+	}
+	if _, err := os.Stat(layer); err != nil {
+		t.Errorf("layers removed: %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package instance
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/AOSC-Dev/ciel/internal/mounts"
+	"github.com/AOSC-Dev/ciel/systemd-api/nspawn"
+)
+
+const (
+	ConfigFileName = "config.json"
+)
+
+// Config holds settings of a single instance, it is optional.
+type Config struct {
+	// Mounts are bound into the instance, after the ones of the workspace.
+	Mounts mounts.Registry `json:"mounts,omitempty"`
+	// Network is the default network mode, see nspawn.ParseNetwork.
+	Network string `json:"network,omitempty"`
+	// Ports are forwarded to the instance when it boots with a private
+	// network.
+	Ports []string `json:"ports,omitempty"`
+	// Limits are the default resource limits.
+	Limits nspawn.Limits `json:"limits"`
+}
+
+func (i *Instance) ConfigFile() string {
+	return path.Join(i.Dir(), ConfigFileName)
+}
+
+// Config reads the instance configuration, a missing file means defaults.
+func (i *Instance) Config() (*Config, error) {
+	config := &Config{}
+	b, err := ioutil.ReadFile(i.ConfigFile())
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// SaveConfig writes the instance configuration.
+func (i *Instance) SaveConfig(config *Config) error {
+	b, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(i.ConfigFile(), append(b, '\n'), 0644)
+}
+
+// Binds returns the bind mounts of the instance: the ones of the workspace,
+// then its own.
+func (i *Instance) Binds() (mounts.Registry, error) {
+	ciel := i.Parent.GetCiel()
+	r, err := ciel.Mounts()
+	if err != nil {
+		return nil, err
+	}
+	config, err := i.Config()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range config.Mounts {
+		r = append(r, e.Resolve(ciel.GetBasePath()))
+	}
+	return r, nil
+}
+
+// UnmountBinds tears down the bind mounts of the instance.
+func (i *Instance) UnmountBinds() error {
+	binds, err := i.Binds()
+	if err != nil {
+		return err
+	}
+	return binds.Unmount(i.MountPoint())
+}
+
+// Network returns the network of the instance, in the given mode or in its
+// default one if mode is empty, falling back to the one of the workspace.
+func (i *Instance) Network(mode string) (*nspawn.NetworkInfo, error) {
+	config, err := i.Config()
+	if err != nil {
+		return nil, err
+	}
+	if mode == "" {
+		mode = config.Network
+	}
+	if mode == "" {
+		if mode, err = i.Parent.GetCiel().DefaultNetwork(); err != nil {
+			return nil, err
+		}
+	}
+	network, err := nspawn.ParseNetwork(mode)
+	if err != nil {
+		return nil, err
+	}
+	switch network.Mode {
+	case nspawn.NetworkZone, nspawn.NetworkVeth, nspawn.NetworkBridge:
+		network.Ports = config.Ports
+	}
+	return network, nil
+}
+
+// Limits returns the resource limits of the instance, its defaults
+// overridden by the ones set in override.
+func (i *Instance) Limits(override nspawn.Limits) (nspawn.Limits, error) {
+	config, err := i.Config()
+	if err != nil {
+		return nspawn.Limits{}, err
+	}
+	limits := config.Limits.Merge(override)
+	return limits, limits.Check()
+}
@@ -3,13 +3,12 @@ package instance
 import (
 	"context"
 	"errors"
-	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"path"
 	"strings"
 
+	"github.com/AOSC-Dev/ciel/cgroup-api"
 	"github.com/AOSC-Dev/ciel/ipc"
 	"github.com/AOSC-Dev/ciel/overlayfs"
 	"github.com/AOSC-Dev/ciel/proc-api"
@@ -18,14 +17,14 @@ import (
 
 	"github.com/AOSC-Dev/ciel/internal/abstract"
 	"github.com/AOSC-Dev/ciel/internal/container/filesystem"
-
-	d "github.com/AOSC-Dev/ciel/display"
 )
 
 const (
-	LayerDirName         = "layers"
-	SemIdFileSystemMutex = 0x11
-	SemIdRunMutex        = 0x22
+	LayerDirName = "layers"
+
+	FileSystemLockName = "fs.lock"
+	RunLockName        = "run.lock"
+	BuildLockName      = "build.lock"
 )
 
 var (
@@ -36,6 +35,8 @@ type Instance struct {
 	Parent   abstract.Container
 	BasePath string
 	Name     string
+
+	buildLock *ipc.FileLock
 }
 
 func (i *Instance) Init() error {
@@ -55,7 +56,9 @@ func (i *Instance) MountLocal() error {
 	fs := i.FileSystem()
 	CriticalSection := i.FileSystemLock()
 
-	CriticalSection.Lock()
+	if err := CriticalSection.Lock(); err != nil {
+		return err
+	}
 	defer CriticalSection.Unlock()
 
 	if !i.Mounted() {
@@ -69,15 +72,24 @@ func (i *Instance) Mount() error {
 	fs := i.FileSystem()
 	CriticalSection := i.FileSystemLock()
 
-	CriticalSection.Lock()
+	if err := CriticalSection.Lock(); err != nil {
+		return err
+	}
 	defer CriticalSection.Unlock()
 
 	if !i.Mounted() {
 		if err := fs.Mount(false); err != nil {
 			return err
 		}
-		i.Parent.GetCiel().GetTree().MountHandler(i, true)
-		i.Parent.GetCiel().GetOutput().MountHandler(i, true)
+		binds, err := i.Binds()
+		if err == nil {
+			err = binds.Mount(i.MountPoint())
+		}
+		if err != nil {
+			binds.Unmount(i.MountPoint())
+			fs.Unmount()
+			return err
+		}
 	}
 	return nil
 }
@@ -86,41 +98,26 @@ func (i *Instance) Unmount() error {
 	fs := i.FileSystem()
 	CriticalSection := i.FileSystemLock()
 
-	CriticalSection.Lock()
+	if err := CriticalSection.Lock(); err != nil {
+		return err
+	}
 	defer CriticalSection.Unlock()
 
 	var err error
 	if i.Mounted() {
-		i.Parent.GetCiel().GetTree().MountHandler(i, false)
-		i.Parent.GetCiel().GetOutput().MountHandler(i, false)
-		d.ITEM("unmount " + i.Name)
+		if err := i.UnmountBinds(); err != nil {
+			return err
+		}
 		if err := fs.Unmount(); err != nil {
-			d.FAILED_BECAUSE(err.Error())
 			return err
 		}
-		d.OK()
 	} else {
-		d.ITEM("unmount " + i.Name)
-		d.SKIPPED()
 		err = os.ErrNotExist
 	}
-	d.ITEM("remove mount point")
-	tryRemove(i.MountPoint())
+	// left if anything is still mounted below it
+	os.Remove(i.MountPoint())
 	return err
 }
-
-func tryRemove(path string) {
-	err := os.Remove(path)
-	if err == nil {
-		d.OK()
-		return
-	}
-	if os.IsNotExist(err) {
-		d.SKIPPED()
-		return
-	}
-	d.FAILED_BECAUSE(err.Error())
-}
 func (i *Instance) Mounted() bool {
 	return proc.Mounted(i.MountPoint())
 }
@@ -131,7 +128,9 @@ func (i *Instance) Run(ctx context.Context, ctnInfo *nspawn.ContainerInfo, runIn
 
 	CriticalSection := i.RunLock()
 
-	if i.RunningAsExclusiveMode() {
+	if exclusive, err := i.RunningAsExclusiveMode(); err != nil {
+		return -1, err
+	} else if exclusive {
 		return -1, ErrMode
 	}
 
@@ -143,9 +142,18 @@ func (i *Instance) Run(ctx context.Context, ctnInfo *nspawn.ContainerInfo, runIn
 		boot = false
 	}
 
+	if network := ctnInfo.Network; network != nil && !boot && len(network.Ports) != 0 {
+		// ports are forwarded to long-running instances only
+		info := *ctnInfo
+		info.Network = &nspawn.NetworkInfo{Mode: network.Mode, Name: network.Name}
+		ctnInfo = &info
+	}
+
 	if boot {
+		if err := CriticalSection.Lock(); err != nil {
+			return -1, err
+		}
 		var err error
-		CriticalSection.Lock()
 		if !i.Running() {
 			err = nspawn.SystemdNspawnBoot(ctx, machineId, i.MountPoint(), ctnInfo)
 		}
@@ -153,90 +161,103 @@ func (i *Instance) Run(ctx context.Context, ctnInfo *nspawn.ContainerInfo, runIn
 		if err != nil {
 			return -1, err
 		}
-		if !i.RunningAsBootMode() {
+		if booted, err := i.RunningAsBootMode(); err != nil {
+			return -1, err
+		} else if !booted {
 			return -1, ErrMode
 		}
 		if runInfo.UseSystemdRun {
 			return nspawn.SystemdRun(ctx, machineId, runInfo)
 		}
-		return nspawn.MachinectlShell(ctx, machineId, runInfo)
+		return nspawn.MachinectlShell(ctx, machineId, ctnInfo, runInfo)
 	} else {
 		return nspawn.SystemdNspawnRun(ctx, machineId, i.MountPoint(), ctnInfo, runInfo)
 	}
 }
 
+// Stop shuts the container down, if it is running.
 func (i *Instance) Stop(ctx context.Context) error {
-	d.ITEM("stop " + i.Name)
 	if !i.Running() {
-		d.SKIPPED()
 		return nil
 	}
-	var err error
-	if i.RunningAsBootMode() {
-		err = nspawn.MachinectlPowerOff(ctx, i.MachineId())
-	} else {
-		err = nspawn.MachinectlTerminate(ctx, i.MachineId())
+	boot, err := i.RunningAsBootMode()
+	if err == nil && boot {
+		err = nspawn.PowerOff(ctx, i.MachineId())
+	} else if err == nil {
+		err = nspawn.Terminate(ctx, i.MachineId())
 	}
-	d.ERR(err)
 	return err
 }
 
+// Machine returns the machine of the running instance.
+func (i *Instance) Machine() (*machined.Machine, error) {
+	m, err := machined.NewManager()
+	if err != nil {
+		return nil, err
+	}
+	return m.GetMachine(i.MachineId())
+}
+
 func (i *Instance) Running() bool {
-	m := machined.NewManager()
-	_, err := m.GetMachine(i.MachineId())
+	_, err := i.Machine()
 	return err == nil
 }
 
-func (i *Instance) RunningAsBootMode() bool {
-	m := machined.NewManager()
-	machine, err := m.GetMachine(i.MachineId())
-	if err != nil {
-		return false
-	}
-	leader, err := machine.Leader()
+// Leader returns the PID of the init process of the running instance.
+func (i *Instance) Leader() (uint32, error) {
+	machine, err := i.Machine()
 	if err != nil {
-		log.Fatalln(err)
+		return 0, err
 	}
-	host, err := proc.GetParentProcessID(leader)
-	if err != nil {
-		log.Fatalln(err)
-	}
-	cmdline, err := proc.GetCommandLineByPID(host)
+	return machine.Leader()
+}
+
+// CGroup returns the cgroup of the running instance.
+func (i *Instance) CGroup() (*cgroup.Group, error) {
+	leader, err := i.Leader()
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
 	}
-	for _, arg := range cmdline {
-		if arg == "-b" || arg == "--boot" {
-			return true
-		}
-	}
-	return false
+	return cgroup.ScopeOf(leader)
 }
 
-func (i *Instance) RunningAsExclusiveMode() bool {
-	m := machined.NewManager()
-	machine, err := m.GetMachine(i.MachineId())
-	if err != nil {
-		return false
+// runningMode tells whether the instance is running, and whether it is
+// booted, from the command line of systemd-nspawn.
+func (i *Instance) runningMode() (running bool, boot bool, err error) {
+	machine, err := i.Machine()
+	if machined.IsNoSuchMachine(err) {
+		return false, false, nil
+	} else if err != nil {
+		return false, false, err
 	}
 	leader, err := machine.Leader()
 	if err != nil {
-		log.Fatalln(err)
+		return false, false, err
 	}
 	host, err := proc.GetParentProcessID(leader)
 	if err != nil {
-		log.Fatalln(err)
+		return false, false, err
 	}
 	cmdline, err := proc.GetCommandLineByPID(host)
 	if err != nil {
-		log.Fatalln(err)
+		return false, false, err
 	}
 	for _, arg := range cmdline {
 		if arg == "-b" || arg == "--boot" {
-			return false
+			return true, true, nil
 		}
 	}
-	return true
+	return true, false, nil
+}
+
+func (i *Instance) RunningAsBootMode() (bool, error) {
+	running, boot, err := i.runningMode()
+	return running && boot, err
+}
+
+func (i *Instance) RunningAsExclusiveMode() (bool, error) {
+	running, boot, err := i.runningMode()
+	return running && !boot, err
 }
 
 func (i *Instance) Dir() string {
@@ -244,15 +265,26 @@ func (i *Instance) Dir() string {
 }
 
 func (i *Instance) MachineId() string {
-	return fmt.Sprintf("%s-%x", i.Name, ipc.GenFileKey(i.Parent.GetCiel().GetBasePath(), 0))
+	return i.Name + "-" + i.Parent.GetCiel().ID()
 }
 
-func (i *Instance) FileSystemLock() ipc.Mutex {
-	return ipc.NewMutex(i.Dir(), SemIdFileSystemMutex, true)
+// FileSystemLock is held while mounting and unmounting.
+func (i *Instance) FileSystemLock() *ipc.FileLock {
+	return ipc.NewFileLock(path.Join(i.Dir(), FileSystemLockName))
 }
 
-func (i *Instance) RunLock() ipc.Mutex {
-	return ipc.NewMutex(i.Dir(), SemIdRunMutex, true)
+// RunLock is held while starting the container.
+func (i *Instance) RunLock() *ipc.FileLock {
+	return ipc.NewFileLock(path.Join(i.Dir(), RunLockName))
+}
+
+// BuildLock is held during a build, so that builds and rollbacks in other
+// processes do not step on it. It is the same lock for the same Instance.
+func (i *Instance) BuildLock() *ipc.FileLock {
+	if i.buildLock == nil {
+		i.buildLock = ipc.NewFileLock(path.Join(i.Dir(), BuildLockName))
+	}
+	return i.buildLock
 }
 
 func (i *Instance) Shell(user string) (string, error) {
@@ -0,0 +1,195 @@
+package mounts
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/AOSC-Dev/ciel/proc-api"
+)
+
+var (
+	ErrInvalidPropagation = errors.New("invalid mount propagation")
+	ErrInvalidTarget      = errors.New("mount target must be an absolute path")
+)
+
+// Propagations maps propagation types to mount flags.
+var Propagations = map[string]uintptr{
+	"":         0,
+	"private":  syscall.MS_PRIVATE,
+	"slave":    syscall.MS_SLAVE,
+	"shared":   syscall.MS_SHARED,
+	"rprivate": syscall.MS_PRIVATE | syscall.MS_REC,
+	"rslave":   syscall.MS_SLAVE | syscall.MS_REC,
+	"rshared":  syscall.MS_SHARED | syscall.MS_REC,
+}
+
+// Entry is a directory on the host bound into instances.
+type Entry struct {
+	Name        string `json:"name,omitempty"`
+	Source      string `json:"source"` // relative to the workspace, if not absolute
+	Target      string `json:"target"` // path in the instance
+	ReadOnly    bool   `json:"read_only,omitempty"`
+	Create      bool   `json:"create,omitempty"`   // create source if missing
+	Optional    bool   `json:"optional,omitempty"` // skip if source is missing
+	Propagation string `json:"propagation,omitempty"`
+}
+
+// Registry is an ordered list of bind mounts, torn down in reverse order.
+type Registry []Entry
+
+// Errors collects errors of multiple mount points.
+type Errors []error
+
+func (e Errors) Error() string {
+	var s []string
+	for _, err := range e {
+		s = append(s, err.Error())
+	}
+	return strings.Join(s, "; ")
+}
+
+func (e Entry) String() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return e.Target
+}
+
+// Check validates the entry.
+func (e Entry) Check() error {
+	if !path.IsAbs(e.Target) {
+		return &os.PathError{Op: "mount", Path: e.Target, Err: ErrInvalidTarget}
+	}
+	if _, ok := Propagations[e.Propagation]; !ok {
+		return &os.PathError{Op: "mount", Path: e.Target, Err: ErrInvalidPropagation}
+	}
+	return nil
+}
+
+// Resolve makes a relative source relative to base.
+func (e Entry) Resolve(base string) Entry {
+	if !path.IsAbs(e.Source) {
+		e.Source = path.Join(base, e.Source)
+	}
+	return e
+}
+
+// Mount binds the source to the target under root, on top of anything
+// already mounted there.
+func (e Entry) Mount(root string) error {
+	if err := e.Check(); err != nil {
+		return err
+	}
+	if _, err := os.Stat(e.Source); os.IsNotExist(err) {
+		switch {
+		case e.Create:
+			if err := os.MkdirAll(e.Source, 0755); err != nil {
+				return err
+			}
+		case e.Optional:
+			return nil
+		default:
+			return err
+		}
+	}
+	target, err := ResolveInRoot(root, e.Target, true)
+	if err != nil {
+		return err
+	}
+	if err := bindInRoot(root, e.Source, target); err != nil {
+		return err
+	}
+	if e.ReadOnly {
+		flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+		if err := remountInRoot(root, target, flags); err != nil {
+			syscall.Unmount(target, umountNoFollow)
+			return &os.PathError{Op: "remount", Path: target, Err: err}
+		}
+	}
+	if flags := Propagations[e.Propagation]; flags != 0 {
+		if err := remountInRoot(root, target, flags); err != nil {
+			syscall.Unmount(target, umountNoFollow)
+			return &os.PathError{Op: "mount", Path: target, Err: err}
+		}
+	}
+	return nil
+}
+
+// bindInRoot binds source to target, through a file descriptor checked to
+// be inside root.
+func bindInRoot(root, source, target string) error {
+	f, procPath, err := openInRoot(root, target)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := syscall.Mount(source, procPath, "", syscall.MS_BIND, ""); err != nil {
+		return &os.PathError{Op: "mount", Path: target, Err: err}
+	}
+	return nil
+}
+
+// remountInRoot changes flags of the mount at target, opened again to get
+// the mount on top.
+func remountInRoot(root, target string, flags uintptr) error {
+	f, procPath, err := openInRoot(root, target)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return syscall.Mount("", procPath, "", flags, "")
+}
+
+// Unmount unbinds the target under root. Mounts stacked on the target are
+// unbound too if all is true, otherwise only the topmost one is.
+func (e Entry) Unmount(root string, all bool) error {
+	target, err := ResolveInRoot(root, e.Target, false)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(target); os.IsNotExist(err) {
+		return nil
+	}
+	for proc.Mounted(target) {
+		if err := syscall.Unmount(target, syscall.MNT_FORCE|umountNoFollow); err != nil {
+			return &os.PathError{Op: "unmount", Path: target, Err: err}
+		}
+		if !all {
+			break
+		}
+	}
+	return nil
+}
+
+// Mount applies all entries in order, stopping at the first failure.
+func (r Registry) Mount(root string) error {
+	for _, e := range r {
+		if err := e.Mount(root); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unmount tears down all entries in reverse order, going on after failures.
+func (r Registry) Unmount(root string) error {
+	var errs Errors
+	for index := len(r) - 1; index >= 0; index-- {
+		e := r[index]
+		target, err := ResolveInRoot(root, e.Target, false)
+		if err != nil || !proc.Mounted(target) {
+			continue
+		}
+		if err := e.Unmount(root, true); err != nil {
+			errs = append(errs, fmt.Errorf("unmount %s: %w", e, err))
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
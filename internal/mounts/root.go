@@ -0,0 +1,125 @@
+package mounts
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// maxSymlinks is how many symlinks are followed resolving a path, as
+// Linux does.
+const maxSymlinks = 40
+
+// umountNoFollow is UMOUNT_NOFOLLOW, missing in syscall.
+const umountNoFollow = 0x8
+
+var ErrEscaped = errors.New("path escaped the instance")
+
+// ResolveInRoot resolves target inside root as if root were "/": symlinks,
+// which the instance may have planted, are followed without ever leaving
+// root, as openat2(2) does with RESOLVE_IN_ROOT. Missing directories are
+// created if create is true, otherwise the rest of the path is joined as
+// is. The result is absolute.
+func ResolveInRoot(root, target string, create bool) (string, error) {
+	root, err := hostPath(root)
+	if err != nil {
+		return "", err
+	}
+	var current string // resolved so far, relative to root
+	links := 0
+	remaining := target
+	for remaining != "" {
+		var name string
+		if i := strings.IndexByte(remaining, '/'); i >= 0 {
+			name, remaining = remaining[:i], remaining[i+1:]
+		} else {
+			name, remaining = remaining, ""
+		}
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			current = strings.TrimPrefix(path.Dir("/"+current), "/")
+			continue
+		}
+		next := path.Join(current, name)
+		full := path.Join(root, next)
+		fi, err := os.Lstat(full)
+		if os.IsNotExist(err) {
+			if !create {
+				return path.Join(root, next, path.Clean("/"+remaining)), nil
+			}
+			if err := os.Mkdir(full, 0755); err != nil && !os.IsExist(err) {
+				return "", err
+			}
+			fi, err = os.Lstat(full)
+		}
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			links++
+			if links > maxSymlinks {
+				return "", &os.PathError{Op: "resolve", Path: target, Err: syscall.ELOOP}
+			}
+			dest, err := os.Readlink(full)
+			if err != nil {
+				return "", err
+			}
+			if path.IsAbs(dest) {
+				current = ""
+			}
+			remaining = dest + "/" + remaining
+			continue
+		}
+		if !fi.IsDir() {
+			return "", &os.PathError{Op: "resolve", Path: target, Err: syscall.ENOTDIR}
+		}
+		current = next
+	}
+	return path.Join(root, current), nil
+}
+
+// openInRoot opens the directory resolved, checking it is still there and
+// inside root, so that the instance cannot swap a component for a symlink
+// before it is used. The file is to be used through /proc/self/fd.
+func openInRoot(root, resolved string) (*os.File, string, error) {
+	root, err := hostPath(root)
+	if err != nil {
+		return nil, "", err
+	}
+	fd, err := syscall.Open(resolved, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, "", &os.PathError{Op: "open", Path: resolved, Err: err}
+	}
+	f := os.NewFile(uintptr(fd), resolved)
+	procPath := "/proc/self/fd/" + strconv.Itoa(fd)
+	actual, err := os.Readlink(procPath)
+	if err != nil {
+		f.Close()
+		return nil, "", err
+	}
+	if actual != resolved || !(actual == root || strings.HasPrefix(actual, root+"/")) {
+		f.Close()
+		return nil, "", &os.PathError{Op: "open", Path: resolved, Err: ErrEscaped}
+	}
+	return f, procPath, nil
+}
+
+// hostPath makes root absolute, resolving symlinks of the host, which are
+// trusted.
+func hostPath(root string) (string, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(root)
+	if os.IsNotExist(err) {
+		return root, nil
+	}
+	return resolved, err
+}
@@ -0,0 +1,133 @@
+package mounts
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/AOSC-Dev/ciel/proc-api"
+)
+
+// tempRoot returns an instance root inside a temporary directory, with a
+// host directory beside it which instances must not reach.
+func tempRoot(t *testing.T) (dir, root, host string) {
+	dir, err := ioutil.TempDir("", "ciel-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root = path.Join(dir, "root")
+	host = path.Join(dir, "host")
+	for _, p := range []string{path.Join(root, "root"), host} {
+		if err := os.MkdirAll(p, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, root, host
+}
+
+func symlink(t *testing.T, dest, link string) {
+	if err := os.Symlink(dest, link); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolveInRoot(t *testing.T) {
+	dir, root, host := tempRoot(t)
+	defer os.RemoveAll(dir)
+	escape := "../../../../../../../../" + host
+	symlink(t, escape, path.Join(root, "root", "relative"))
+	symlink(t, "/../../.."+host, path.Join(root, "root", "absolute"))
+	symlink(t, "/usr/lib", path.Join(root, "lib"))
+	symlink(t, "loop", path.Join(root, "loop"))
+	if err := ioutil.WriteFile(path.Join(root, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/root/.ccache", "/root/.ccache"},
+		{"/root/../../../etc", "/etc"},
+		{"/root/relative/x", path.Join(host, "x")},
+		{"/root/absolute/x", path.Join(host, "x")},
+		{"/lib/modules", "/usr/lib/modules"},
+	}
+	for _, test := range tests {
+		got, err := ResolveInRoot(root, test.target, true)
+		if err != nil {
+			t.Errorf("%s: %v", test.target, err)
+			continue
+		}
+		if want := path.Join(root, test.want); got != want {
+			t.Errorf("%s: resolved to %s, want %s", test.target, got, want)
+		}
+		if fi, err := os.Lstat(got); err != nil || !fi.IsDir() {
+			t.Errorf("%s: %s not created", test.target, got)
+		}
+	}
+	if list, _ := ioutil.ReadDir(host); len(list) != 0 {
+		t.Errorf("directories created outside the root: %v", list)
+	}
+	for _, target := range []string{"/loop", "/file/x"} {
+		if _, err := ResolveInRoot(root, target, true); err == nil {
+			t.Errorf("%s: resolved", target)
+		}
+	}
+	got, err := ResolveInRoot(root, "/root/missing/x", false)
+	if err != nil || got != path.Join(root, "root/missing/x") {
+		t.Errorf("missing path resolved to %s, %v", got, err)
+	}
+	if _, err := os.Stat(path.Join(root, "root/missing")); err == nil {
+		t.Error("missing path created without create")
+	}
+}
+
+func TestMountSymlinkedTarget(t *testing.T) {
+	dir, root, host := tempRoot(t)
+	defer os.RemoveAll(dir)
+	source := path.Join(dir, "cache")
+	if err := os.Mkdir(source, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(source, "cached"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the instance replaced the cache directory with a symlink to the host
+	symlink(t, "/../../../.."+host, path.Join(root, "root", ".ccache"))
+
+	e := Entry{Name: "ccache", Source: source, Target: "/root/.ccache"}
+	err := e.Mount(root)
+	if err == syscall.EPERM || os.IsPermission(err) || (err != nil && os.Geteuid() != 0) {
+		t.Skip("mounting needs root:", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Unmount(root, true)
+
+	if proc.Mounted(host) {
+		syscall.Unmount(host, 0)
+		t.Fatal("mounted onto the host")
+	}
+	inRoot := path.Join(root, host)
+	if !proc.Mounted(inRoot) {
+		t.Fatalf("%s not mounted", inRoot)
+	}
+	if _, err := os.Stat(path.Join(inRoot, "cached")); err != nil {
+		t.Error("cache not bound:", err)
+	}
+	if err := e.Unmount(root, true); err != nil {
+		t.Fatal(err)
+	}
+	if proc.Mounted(inRoot) {
+		t.Error("still mounted after unmounting")
+	}
+}
@@ -7,8 +7,6 @@ import (
 	"path"
 
 	"github.com/AOSC-Dev/ciel/internal/abstract"
-
-	d "github.com/AOSC-Dev/ciel/display"
 )
 
 // constant definitions for packaging related variables
@@ -18,7 +16,7 @@ const (
 )
 
 // EditSourceList : config function to let user manipulate the apt config inside the container
-func EditSourceList(global bool, i abstract.Instance, c abstract.Container) {
+func EditSourceList(global bool, i abstract.Instance, c abstract.Container) error {
 	var root string
 	if global {
 		root = c.DistDir()
@@ -30,11 +28,11 @@ func EditSourceList(global bool, i abstract.Instance, c abstract.Container) {
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	cmd.Run()
+	return cmd.Run()
 }
 
 // SetTreePath : config function to set the acbs search path
-func SetTreePath(global bool, i abstract.Instance, c abstract.Container, tree string) {
+func SetTreePath(global bool, i abstract.Instance, c abstract.Container, tree string) error {
 	var root string
 	if global {
 		root = c.DistDir()
@@ -43,13 +41,11 @@ func SetTreePath(global bool, i abstract.Instance, c abstract.Container, tree st
 	}
 	config := `[default]` + "\n"
 	config += `location = ` + path.Clean(tree) + "\n"
-	d.ITEM("set tree path")
-	err := ioutil.WriteFile(path.Join(root, "/etc/acbs/forest.conf"), []byte(config), 0644)
-	d.ERR(err)
+	return ioutil.WriteFile(path.Join(root, "/etc/acbs/forest.conf"), []byte(config), 0644)
 }
 
 // DisableDNSSEC : config function to disable DNSSEC service
-func DisableDNSSEC(global bool, i abstract.Instance, c abstract.Container) {
+func DisableDNSSEC(global bool, i abstract.Instance, c abstract.Container) error {
 	var root string
 	if global {
 		root = c.DistDir()
@@ -58,13 +54,11 @@ func DisableDNSSEC(global bool, i abstract.Instance, c abstract.Container) {
 	}
 	config := `[Resolve]` + "\n"
 	config += `DNSSEC=no` + "\n"
-	d.ITEM("disable DNSSEC")
-	err := ioutil.WriteFile(path.Join(root, "/etc/systemd/resolved.conf"), []byte(config), 0644)
-	d.ERR(err)
+	return ioutil.WriteFile(path.Join(root, "/etc/systemd/resolved.conf"), []byte(config), 0644)
 }
 
 // SetMaintainer : config function to let user specify maintainer information
-func SetMaintainer(global bool, i abstract.Instance, c abstract.Container, person string) {
+func SetMaintainer(global bool, i abstract.Instance, c abstract.Container, person string) error {
 	var root string
 	if global {
 		root = c.DistDir()
@@ -76,36 +70,38 @@ func SetMaintainer(global bool, i abstract.Instance, c abstract.Container, perso
 	config += `ABAPMS=` + "\n"
 	config += `MTER="` + person + `"` + "\n"
 	config += `ABINSTALL=dpkg` + "\n"
-	d.ITEM("set maintainer")
-	err := ioutil.WriteFile(path.Join(root, "/usr/lib/autobuild3/etc/autobuild/ab3cfg.sh"), []byte(config), 0644)
-	d.ERR(err)
+	return ioutil.WriteFile(path.Join(root, "/usr/lib/autobuild3/etc/autobuild/ab3cfg.sh"), []byte(config), 0644)
+}
+
+// InitLocalRepo : initialize local repository, with the given suites enabled
+func InitLocalRepo(global bool, i abstract.Instance, c abstract.Container, suites []string) error {
+	return SetLocalRepoSuites(global, i, c, suites)
 }
 
-// InitLocalRepo : initialize local repository
-func InitLocalRepo(global bool, i abstract.Instance, c abstract.Container) {
+// SetLocalRepoSuites : write the local repository configuration for the given suites
+func SetLocalRepoSuites(global bool, i abstract.Instance, c abstract.Container, suites []string) error {
 	var root string
 	if global {
 		root = c.DistDir()
 	} else {
 		root = i.MountPoint()
 	}
-	config := `deb file:///debs /` + "\n"
-	d.ITEM("initialize local repository")
-	err := ioutil.WriteFile(path.Join(root, DefaultRepoConfig), []byte(config), 0644)
-	d.ERR(err)
+	var config string
+	for _, suite := range suites {
+		config += `deb file://` + RepoPath + ` ` + suite + ` main` + "\n"
+	}
+	return ioutil.WriteFile(path.Join(root, DefaultRepoConfig), []byte(config), 0644)
 }
 
 // UnInitLocalRepo : remove local repository (configuration only)
-func UnInitLocalRepo(global bool, i abstract.Instance, c abstract.Container) {
+func UnInitLocalRepo(global bool, i abstract.Instance, c abstract.Container) error {
 	var root string
 	if global {
 		root = c.DistDir()
 	} else {
 		root = i.MountPoint()
 	}
-	d.ITEM("un-initialize local repository")
-	err := os.Remove(path.Join(root, DefaultRepoConfig))
-	d.ERR(err)
+	return os.Remove(path.Join(root, DefaultRepoConfig))
 }
 
 func editor() string {
@@ -0,0 +1,177 @@
+package packaging
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	d "github.com/AOSC-Dev/ciel/display"
+)
+
+const (
+	LogsDirName = "logs"
+
+	LogSuffix      = ".log" // raw output, with escape sequences
+	PlainLogSuffix = ".txt" // the same output, escape sequences stripped
+
+	logTimeFormat  = "20060102-150405"
+	maxLogNameSize = 128
+	// maxLogLine is how much of a line without a newline, such as a progress
+	// bar redrawn with \r, is held for the plain text copy.
+	maxLogLine = 64 * 1024
+)
+
+// LogDir returns the directory holding build logs.
+func (t *Tree) LogDir() string {
+	return path.Join(t.BasePath, LogsDirName)
+}
+
+// BuildLog tees the output of a build into a raw log file and a plain text
+// copy. The raw file is flock(2)-ed while the build is writing to it, so that
+// followers know when to stop.
+type BuildLog struct {
+	Path      string
+	PlainPath string
+
+	mutex sync.Mutex
+	raw   *os.File
+	plain *os.File
+	line  []byte
+}
+
+// NewBuildLog creates log files named after the current time and packages,
+// suffixed with _2, _3 and so on if another build of them started within the
+// same second.
+func (t *Tree) NewBuildLog(packages []string) (*BuildLog, error) {
+	if err := os.MkdirAll(t.LogDir(), 0755); err != nil {
+		return nil, err
+	}
+	base := time.Now().Format(logTimeFormat) + "-" + logName(packages)
+	l := &BuildLog{}
+	var err error
+	for n := 1; ; n++ {
+		name := base
+		if n > 1 {
+			name += "_" + strconv.Itoa(n)
+		}
+		l.Path = path.Join(t.LogDir(), name+LogSuffix)
+		l.PlainPath = path.Join(t.LogDir(), name+PlainLogSuffix)
+		l.raw, err = os.OpenFile(l.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(l.raw.Fd()), syscall.LOCK_EX); err != nil {
+		l.raw.Close()
+		return nil, err
+	}
+	if l.plain, err = os.Create(l.PlainPath); err != nil {
+		l.raw.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func logName(packages []string) string {
+	name := strings.Join(packages, "+")
+	name = strings.NewReplacer("/", "_", " ", "_").Replace(name)
+	if name == "" {
+		name = "unnamed"
+	}
+	if len(name) > maxLogNameSize {
+		name = name[:maxLogNameSize]
+	}
+	return name
+}
+
+func (l *BuildLog) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, err := l.raw.Write(p); err != nil {
+		return 0, err
+	}
+	// escape sequences may be split between writes, strip them line by line
+	l.line = append(l.line, p...)
+	if i := bytes.LastIndexByte(l.line, '\n'); i >= 0 {
+		if _, err := l.plain.WriteString(d.StripEsc(string(l.line[:i+1]))); err != nil {
+			return 0, err
+		}
+		l.line = append(l.line[:0], l.line[i+1:]...)
+	}
+	if len(l.line) > maxLogLine {
+		// flush up to the last redraw, escape sequences are seldom split there
+		i := bytes.LastIndexByte(l.line, '\r')
+		if i < 0 {
+			i = len(l.line) - 1
+		}
+		if _, err := l.plain.WriteString(d.StripEsc(string(l.line[:i+1]))); err != nil {
+			return 0, err
+		}
+		l.line = append(l.line[:0], l.line[i+1:]...)
+	}
+	return len(p), nil
+}
+
+// Close flushes the plain text copy and releases the log.
+func (l *BuildLog) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if len(l.line) != 0 {
+		l.plain.WriteString(d.StripEsc(string(l.line)))
+		l.line = nil
+	}
+	errPlain := l.plain.Close()
+	errRaw := l.raw.Close()
+	if errRaw != nil {
+		return errRaw
+	}
+	return errPlain
+}
+
+// BuildLogs lists raw build logs, oldest first.
+func (t *Tree) BuildLogs() ([]string, error) {
+	list, err := ioutil.ReadDir(t.LogDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var logs []string
+	for _, fi := range list {
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), LogSuffix) {
+			logs = append(logs, path.Join(t.LogDir(), fi.Name()))
+		}
+	}
+	sort.Strings(logs)
+	return logs, nil
+}
+
+// PlainLogPath returns the plain text copy of a raw build log.
+func PlainLogPath(logPath string) string {
+	return strings.TrimSuffix(logPath, LogSuffix) + PlainLogSuffix
+}
+
+// LogWriting reports whether a build is still writing to the log.
+func LogWriting(logPath string) bool {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if err == nil {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		return false
+	}
+	return err == syscall.EWOULDBLOCK
+}
@@ -0,0 +1,58 @@
+package packaging
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestNewBuildLogSameSecond(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ciel-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tree := &Tree{BasePath: dir}
+	seen := map[string]bool{}
+	for n := 0; n < 3; n++ {
+		l, err := tree.NewBuildLog([]string{"foo"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		if seen[l.Path] || seen[l.PlainPath] {
+			t.Fatalf("log %s reused", l.Path)
+		}
+		seen[l.Path], seen[l.PlainPath] = true, true
+	}
+}
+
+func TestBuildLogLongLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ciel-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l, err := (&Tree{BasePath: dir}).NewBuildLog([]string{"foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	progress := []byte("\r[=====>    ] 50%")
+	for written := 0; written <= maxLogLine; written += len(progress) {
+		if _, err := l.Write(progress); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(l.line) > maxLogLine {
+		t.Errorf("%d bytes held without a newline", len(l.line))
+	}
+	plain, err := ioutil.ReadFile(l.PlainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plain) == 0 || !bytes.HasPrefix(l.line, progress[1:]) {
+		t.Error("long line not flushed at a redraw")
+	}
+}
@@ -1,18 +1,41 @@
 package packaging
 
 import (
+	"errors"
+	"io/ioutil"
 	"os"
 	"path"
-	"path/filepath"
-	"syscall"
+	"strings"
 
-	d "github.com/AOSC-Dev/ciel/display"
 	"github.com/AOSC-Dev/ciel/internal/abstract"
-	"github.com/AOSC-Dev/ciel/proc-api"
+	"github.com/AOSC-Dev/ciel/internal/mounts"
 )
 
+// OUTPUT is laid out as an APT repository:
+//
+//	OUTPUT/pool/<suite>/...                  artifacts of each suite
+//	OUTPUT/dists/<suite>/InRelease           generated by ciel-localrepo
+//	OUTPUT/dists/<suite>/main/binary-<arch>/Packages
+//	OUTPUT/.staging/<instance>/...           artifacts of the running build
+//
+// The whole tree is bound to RepoPath inside the instance. Builds drop .debs
+// in OutputPath, bound to the staging directory of the instance, which are
+// moved into the pool of the suite being built once the build exits.
 const (
 	OutputPath = "/debs"
+	RepoPath   = "/repo"
+
+	PoolDirName    = "pool"
+	DistsDirName   = "dists"
+	StagingDirName = ".staging"
+	DefaultSuite   = "stable"
+
+	// LocalRepoLockName is held while refreshing the indices.
+	LocalRepoLockName = ".lock"
+)
+
+var (
+	ErrInvalidSuiteName = errors.New("invalid suite name")
 )
 
 type Tree struct {
@@ -20,51 +43,82 @@ type Tree struct {
 	BasePath string
 }
 
-func (t *Tree) Mount(mountPoint string) {
-	if _, err := os.Stat(t.BasePath); os.IsNotExist(err) {
-		err = os.MkdirAll(t.BasePath, 0755)
-		if err != nil {
-			return
-		}
-	}
-	outputMountPoint := path.Join(mountPoint, OutputPath)
-	os.MkdirAll(outputMountPoint, 0755)
-	if !proc.Mounted(outputMountPoint) {
-		syscall.Mount(t.BasePath, outputMountPoint, "", syscall.MS_BIND, "")
-	}
+// PoolDir returns the directory holding the artifacts of suite.
+func (t *Tree) PoolDir(suite string) string {
+	return path.Join(t.BasePath, PoolDirName, suite)
+}
+
+// SuiteDir returns the directory holding the indices of suite.
+func (t *Tree) SuiteDir(suite string) string {
+	return path.Join(t.BasePath, DistsDirName, suite)
 }
 
-func (t *Tree) Unmount(mountPoint string) {
-	outputMountPoint := path.Join(mountPoint, OutputPath)
-	if _, err := os.Stat(outputMountPoint); os.IsNotExist(err) {
-		return
+// Suites lists all suites which have a pool in OUTPUT.
+func (t *Tree) Suites() ([]string, error) {
+	list, err := ioutil.ReadDir(path.Join(t.BasePath, PoolDirName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
 	}
-	if !proc.Mounted(outputMountPoint) {
-		return
+	var suites []string
+	for _, fi := range list {
+		if fi.IsDir() {
+			suites = append(suites, fi.Name())
+		}
 	}
-	d.ITEM("unmount output")
-	result, err := filepath.Abs(outputMountPoint)
-	if err != nil {
-		d.WARN(err)
-		return
+	return suites, nil
+}
+
+// CheckSuite returns ErrInvalidSuiteName if suite cannot be used as a
+// directory name in OUTPUT or as a suite name in sources.list.
+func CheckSuite(suite string) error {
+	if suite == "" || suite == "." || suite == ".." || strings.ContainsAny(suite, "/\\ \t") {
+		return ErrInvalidSuiteName
 	}
-	for proc.Mounted(result) {
-		err = syscall.Unmount(result, syscall.MNT_FORCE)
-		if err != nil {
-			d.WARN(err)
-			return
+	return nil
+}
+
+// ParseSuites splits a comma-separated suite list, the first one is the
+// suite to put artifacts in, all of them are visible to APT.
+func ParseSuites(s string) ([]string, error) {
+	var suites []string
+	seen := make(map[string]bool)
+	for _, suite := range strings.Split(s, ",") {
+		suite = strings.TrimSpace(suite)
+		if err := CheckSuite(suite); err != nil {
+			return nil, err
 		}
+		if seen[suite] {
+			continue
+		}
+		seen[suite] = true
+		suites = append(suites, suite)
 	}
-	d.WARN(err)
-	d.ITEM("remove output mount point")
-	err = os.Remove(outputMountPoint)
-	d.WARN(err)
+	return suites, nil
 }
 
-func (t *Tree) MountHandler(i abstract.Instance, mount bool) {
-	if mount {
-		t.Mount(i.MountPoint())
-	} else {
-		t.Unmount(i.MountPoint())
+// Mounts binds OUTPUT and the pool of the default suite into instances.
+func (t *Tree) Mounts() mounts.Registry {
+	return mounts.Registry{
+		{Name: "repository", Source: t.BasePath, Target: RepoPath, Create: true},
+		t.SuiteMount(DefaultSuite),
 	}
 }
+
+// SuiteMount binds the pool of suite to the output directory.
+func (t *Tree) SuiteMount(suite string) mounts.Entry {
+	return mounts.Entry{Name: "output", Source: t.PoolDir(suite), Target: OutputPath, Create: true}
+}
+
+// StagingDir returns the directory where builds in instance name drop
+// their artifacts.
+func (t *Tree) StagingDir(name string) string {
+	return path.Join(t.BasePath, StagingDirName, name)
+}
+
+// StagingMount binds the staging directory of instance name to the output
+// directory, builds stack it on top of the pool of the default suite.
+func (t *Tree) StagingMount(name string) mounts.Entry {
+	return mounts.Entry{Name: "output", Source: t.StagingDir(name), Target: OutputPath, Create: true}
+}
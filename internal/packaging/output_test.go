@@ -0,0 +1,34 @@
+package packaging
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSuites(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+		err  error
+	}{
+		{"stable", []string{"stable"}, nil},
+		{"topic,stable", []string{"topic", "stable"}, nil},
+		{" topic , stable ", []string{"topic", "stable"}, nil},
+		{"topic,stable,topic", []string{"topic", "stable"}, nil},
+		{"", nil, ErrInvalidSuiteName},
+		{"topic,", nil, ErrInvalidSuiteName},
+		{"..", nil, ErrInvalidSuiteName},
+		{"a/b", nil, ErrInvalidSuiteName},
+		{"a b", nil, ErrInvalidSuiteName},
+	}
+	for _, test := range tests {
+		got, err := ParseSuites(test.in)
+		if err != test.err {
+			t.Errorf("ParseSuites(%q): error %v, want %v", test.in, err, test.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseSuites(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package packaging
+
+import (
+	"os/exec"
+	"path"
+	"syscall"
+
+	"github.com/AOSC-Dev/ciel/config"
+	"github.com/AOSC-Dev/ciel/ipc"
+)
+
+// LocalRepoPlugin regenerates the indices of the local repository.
+var LocalRepoPlugin = path.Join(config.PluginDir, config.PluginPrefix+"localrepo")
+
+// RefreshLocalRepo regenerates the indices of suite in the repository at
+// repoDir; on the first run, repoDir is the root of the system to import
+// the signing key into instead. It returns the exit status of the plugin.
+func RefreshLocalRepo(repoDir string, suite string, firstRun bool) (int, error) {
+	cmd := exec.Command(LocalRepoPlugin, repoDir, suite)
+	if firstRun {
+		cmd.Env = []string{"CIEL_LR_FIRST=1"}
+	} else {
+		// builds in other instances may be refreshing the same repository
+		CriticalSection := ipc.NewFileLock(path.Join(repoDir, LocalRepoLockName))
+		if err := CriticalSection.Lock(); err != nil {
+			return -1, err
+		}
+		defer CriticalSection.Unlock()
+	}
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.Sys().(syscall.WaitStatus).ExitStatus(), nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
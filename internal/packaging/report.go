@@ -0,0 +1,128 @@
+package packaging
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const ReportSuffix = ".json"
+
+// DistInfo identifies the underlying OS, taken from its os-release(5).
+// The API has a copy of the fields for reports.
+type DistInfo struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	VersionID string `json:"version_id,omitempty"`
+	BuildID   string `json:"build_id,omitempty"`
+}
+
+// Artifact is a package produced by a build.
+type Artifact struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ReportPath returns the default path of the report of a build.
+func ReportPath(logPath string) string {
+	return strings.TrimSuffix(logPath, LogSuffix) + ReportSuffix
+}
+
+// ReadDistInfo reads the os-release(5) of the system at root.
+func ReadDistInfo(root string) DistInfo {
+	var info DistInfo
+	f, err := os.Open(path.Join(root, "/etc/os-release"))
+	if err != nil {
+		f, err = os.Open(path.Join(root, "/usr/lib/os-release"))
+		if err != nil {
+			return info
+		}
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(strings.TrimSpace(scanner.Text()), "=", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		value := strings.Trim(fields[1], `"'`)
+		switch fields[0] {
+		case "ID":
+			info.ID = value
+		case "NAME":
+			info.Name = value
+		case "VERSION":
+			info.Version = value
+		case "VERSION_ID":
+			info.VersionID = value
+		case "BUILD_ID":
+			info.BuildID = value
+		}
+	}
+	return info
+}
+
+// PublishStaged moves the files staged by the builds in instance name into
+// the pool of suite, keeping their paths, and returns the packages among
+// them. Builds in other instances stage theirs apart, so the packages are
+// the ones of the last build in the instance.
+func (t *Tree) PublishStaged(name string, suite string) ([]Artifact, error) {
+	staging := t.StagingDir(name)
+	pool := t.PoolDir(suite)
+	var artifacts []Artifact
+	err := filepath.Walk(staging, func(p string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(staging, p)
+		if err != nil {
+			return err
+		}
+		dst := path.Join(pool, rel)
+		if err := os.MkdirAll(path.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(p, dst); err != nil {
+			return err
+		}
+		if !strings.HasSuffix(p, ".deb") {
+			return nil
+		}
+		sum, err := sha256File(dst)
+		if err != nil {
+			return err
+		}
+		artifacts = append(artifacts, Artifact{Path: dst, Size: info.Size(), SHA256: sum})
+		return nil
+	})
+	sort.Slice(artifacts, func(i, j int) bool {
+		return artifacts[i].Path < artifacts[j].Path
+	})
+	return artifacts, err
+}
+
+func sha256File(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
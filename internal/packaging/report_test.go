@@ -0,0 +1,56 @@
+package packaging
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func stage(t *testing.T, tree *Tree, name string, file string) {
+	p := path.Join(tree.StagingDir(name), file)
+	if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(p, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublishStaged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ciel-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tree := &Tree{BasePath: dir}
+	stage(t, tree, "a", "main/f/foo_1.0_amd64.deb")
+	stage(t, tree, "a", "main/f/foo_1.0_amd64.buildinfo")
+	// built at the same time in another instance
+	stage(t, tree, "b", "main/b/bar_1.0_amd64.deb")
+
+	artifacts, err := tree.PublishStaged("a", DefaultSuite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deb := path.Join(tree.PoolDir(DefaultSuite), "main/f/foo_1.0_amd64.deb")
+	if len(artifacts) != 1 || artifacts[0].Path != deb || artifacts[0].Size != int64(len("main/f/foo_1.0_amd64.deb")) {
+		t.Fatalf("artifacts %+v, want only %s", artifacts, deb)
+	}
+	if _, err := os.Stat(path.Join(tree.PoolDir(DefaultSuite), "main/f/foo_1.0_amd64.buildinfo")); err != nil {
+		t.Error("other staged files not moved into the pool")
+	}
+	if _, err := os.Stat(path.Join(tree.StagingDir("b"), "main/b/bar_1.0_amd64.deb")); err != nil {
+		t.Error("artifacts of another instance published")
+	}
+	if artifacts, err := tree.PublishStaged("a", DefaultSuite); err != nil || len(artifacts) != 0 {
+		t.Errorf("published again: %+v, %v", artifacts, err)
+	}
+}
+
+func TestPublishStagedNothing(t *testing.T) {
+	tree := &Tree{BasePath: "/nonexistent"}
+	if artifacts, err := tree.PublishStaged("a", DefaultSuite); err != nil || artifacts != nil {
+		t.Errorf("PublishStaged() = %+v, %v", artifacts, err)
+	}
+}
@@ -5,8 +5,6 @@ import (
 	"path"
 
 	"github.com/AOSC-Dev/ciel/internal/abstract"
-
-	d "github.com/AOSC-Dev/ciel/display"
 )
 
 const (
@@ -19,31 +17,29 @@ type ToolChain struct {
 	ACBS bool
 }
 
-func DetectToolChain(global bool, i abstract.Instance, c abstract.Container) *ToolChain {
+// DetectToolChain tells which of autobuild3 and acbs are installed.
+func DetectToolChain(global bool, i abstract.Instance, c abstract.Container) (*ToolChain, error) {
 	var root string
 	if global {
 		root = c.DistDir()
 	} else {
 		root = i.MountPoint()
 	}
+	var err error
 	tc := &ToolChain{}
-	d.ITEM("detect autobuild3")
-	tc.AB = exists(root, AB3Path)
-	d.ITEM("detect acbs")
-	tc.ACBS = exists(root, ACBSPath)
-	return tc
+	if tc.AB, err = exists(root, AB3Path); err != nil {
+		return nil, err
+	}
+	if tc.ACBS, err = exists(root, ACBSPath); err != nil {
+		return nil, err
+	}
+	return tc, nil
 }
 
-func exists(root, target string) bool {
+func exists(root, target string) (bool, error) {
 	_, err := os.Stat(path.Join(root, target))
 	if os.IsNotExist(err) {
-		d.FAILED()
-		return false
-	} else if err == nil {
-		d.OK()
-		return true
-	} else {
-		d.FAILED_BECAUSE(err.Error())
-		return false
+		return false, nil
 	}
+	return err == nil, err
 }
@@ -3,44 +3,66 @@ package pkgtree
 import (
 	"os/exec"
 
-	"log"
 	"os"
+	"strings"
 	"syscall"
 
 	"github.com/AOSC-Dev/ciel/internal/abstract"
+	"github.com/AOSC-Dev/ciel/internal/mounts"
+)
+
+const (
+	TreePath = "/tree"
 )
 
 type Tree struct {
 	Parent   abstract.Ciel
 	BasePath string
+	Arch     string // architecture of builds, the host's if empty
+}
+
+// Mounts binds the tree into instances, if it is loaded.
+func (t *Tree) Mounts() mounts.Registry {
+	return mounts.Registry{
+		{Name: "tree", Source: t.BasePath, Target: TreePath, Optional: true},
+	}
 }
 
-func (t *Tree) Clone(remote string) int {
+func (t *Tree) Clone(remote string) (int, error) {
 	cmd := exec.Command("git", "clone", remote, t.BasePath)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	err := cmd.Run()
 	if exitErr, ok := err.(*exec.ExitError); ok {
-		return exitErr.Sys().(syscall.WaitStatus).ExitStatus()
+		return exitErr.Sys().(syscall.WaitStatus).ExitStatus(), nil
 	}
 	if err != nil {
-		log.Fatalln(err)
+		return -1, err
 	}
-	return 0
+	return 0, nil
 }
 
-func (t *Tree) Pull() int {
+func (t *Tree) Pull() (int, error) {
 	cmd := exec.Command("git", "-C", t.BasePath, "pull", "--rebase")
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	err := cmd.Run()
 	if exitErr, ok := err.(*exec.ExitError); ok {
-		return exitErr.Sys().(syscall.WaitStatus).ExitStatus()
+		return exitErr.Sys().(syscall.WaitStatus).ExitStatus(), nil
 	}
 	if err != nil {
-		log.Fatalln(err)
+		return -1, err
+	}
+	return 0, nil
+}
+
+// Commit returns the commit the tree is currently at.
+func (t *Tree) Commit() (string, error) {
+	output, err := exec.Command("git", "-C", t.BasePath, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", err
 	}
-	return 0
+	return strings.TrimSpace(string(output)), nil
 }
@@ -0,0 +1,322 @@
+package pkgtree
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	GroupsDirName = "groups"
+	SpecFileName  = "spec"
+)
+
+var (
+	ErrNotFound = errors.New("package not found in tree")
+)
+
+// Package is a package in the tree, with the names of packages it needs to
+// be built against.
+type Package struct {
+	Name  string   // name used to request the build
+	Dir   string   // directory in the tree
+	Names []string // package names it produces
+	Deps  []string
+}
+
+// DepVars are variables in defines listing dependencies, in build order.
+var DepVars = []string{"PKGDEP", "BUILDDEP"}
+
+// goArchs maps Go architectures to the ones of AOSC OS, which instances
+// run natively.
+var goArchs = map[string]string{
+	"386":      "i486",
+	"amd64":    "amd64",
+	"arm":      "armv7hf",
+	"arm64":    "arm64",
+	"loong64":  "loongarch64",
+	"mips64le": "loongson3",
+	"ppc64":    "ppc64",
+	"ppc64le":  "ppc64el",
+	"riscv64":  "riscv64",
+}
+
+// HostArch returns the architecture of AOSC OS matching the host.
+func HostArch() string {
+	if arch, ok := goArchs[runtime.GOARCH]; ok {
+		return arch
+	}
+	return runtime.GOARCH
+}
+
+// arch returns the architecture dependencies are read for.
+func (t *Tree) arch() string {
+	if t.Arch != "" {
+		return t.Arch
+	}
+	return HostArch()
+}
+
+// archValue returns the value of key in defines, overridden by KEY__ARCH
+// as autobuild does.
+func archValue(vars map[string]string, key string, arch string) string {
+	if value, ok := vars[key+"__"+strings.ToUpper(arch)]; ok {
+		return value
+	}
+	return vars[key]
+}
+
+// Expand resolves a list of requests into package names, expanding groups
+// (groups/NAME) into the packages they contain.
+func (t *Tree) Expand(requests []string) ([]string, error) {
+	var names []string
+	for _, req := range requests {
+		if !strings.HasPrefix(req, GroupsDirName+"/") {
+			names = append(names, req)
+			continue
+		}
+		list, err := ReadList(path.Join(t.BasePath, req))
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, list...)
+	}
+	return names, nil
+}
+
+// ReadList reads package names from a file, one per line, ignoring empty
+// lines and '#' comments.
+func ReadList(p string) ([]string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		names = append(names, strings.Fields(line)...)
+	}
+	return names, scanner.Err()
+}
+
+// Find looks up a package by its name, or by SECTION/NAME.
+func (t *Tree) Find(name string) (*Package, error) {
+	var dir string
+	if strings.Contains(name, "/") {
+		dir = path.Join(t.BasePath, name)
+	} else {
+		matches, _ := filepath.Glob(path.Join(t.BasePath, "*", name, SpecFileName))
+		if len(matches) == 0 {
+			return nil, ErrNotFound
+		}
+		dir = path.Dir(matches[0])
+	}
+	if _, err := os.Stat(path.Join(dir, SpecFileName)); err != nil {
+		return nil, ErrNotFound
+	}
+	pkg := &Package{Name: name, Dir: dir}
+	defines, _ := filepath.Glob(path.Join(dir, "*", "defines"))
+	for _, p := range defines {
+		vars, err := readDefines(p)
+		if err != nil {
+			return nil, err
+		}
+		if vars["PKGNAME"] != "" {
+			pkg.Names = append(pkg.Names, vars["PKGNAME"])
+		}
+		for _, v := range DepVars {
+			pkg.Deps = append(pkg.Deps, parseDeps(archValue(vars, v, t.arch()))...)
+		}
+	}
+	if len(pkg.Names) == 0 {
+		pkg.Names = []string{path.Base(dir)}
+	}
+	return pkg, nil
+}
+
+// readDefines extracts plain assignments from an autobuild defines file,
+// which is a shell script. Quoted values may span multiple lines.
+func readDefines(p string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	vars := make(map[string]string)
+	lines := strings.Split(string(b), "\n")
+	for n := 0; n < len(lines); n++ {
+		line := strings.TrimSpace(lines[n])
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 || strings.HasPrefix(line, "#") || strings.ContainsAny(line[:eq], " \t$") {
+			continue
+		}
+		key, value := line[:eq], line[eq+1:]
+		if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, `'`) {
+			quote := value[:1]
+			value = value[1:]
+			for !strings.Contains(value, quote) && n+1 < len(lines) {
+				n++
+				value += " " + strings.TrimSpace(lines[n])
+			}
+			if i := strings.Index(value, quote); i >= 0 {
+				value = value[:i]
+			}
+		}
+		value = strings.Replace(value, "\\", " ", -1)
+		vars[key] = value
+	}
+	return vars, nil
+}
+
+// parseDeps strips version constraints off a dependency list.
+func parseDeps(s string) []string {
+	var deps []string
+	for _, dep := range strings.Fields(s) {
+		if i := strings.IndexAny(dep, "<>=|"); i >= 0 {
+			dep = dep[:i]
+		}
+		if dep != "" {
+			deps = append(deps, dep)
+		}
+	}
+	return deps
+}
+
+// Order sorts packages so that each one comes after the packages among them
+// it depends on, keeping the requested order otherwise. Packages in a
+// dependency cycle are returned in requested order, listed in cycle; the
+// ones depending on a cycle are listed in blocked, in dependency order.
+func Order(packages []*Package) (ordered []*Package, cycle []*Package, blocked []*Package) {
+	provider := make(map[string]int)
+	for i, pkg := range packages {
+		for _, name := range pkg.Names {
+			provider[name] = i
+		}
+	}
+	deps := make([][]int, len(packages))
+	for i, pkg := range packages {
+		for _, dep := range pkg.Deps {
+			if j, ok := provider[dep]; ok && j != i {
+				deps[i] = append(deps[i], j)
+			}
+		}
+	}
+
+	done := make([]bool, len(packages))
+	// sorted takes packages whose dependencies are done, until none is.
+	sorted := func() (list []*Package) {
+		for {
+			progress := false
+			for i, pkg := range packages {
+				if done[i] || !allDone(done, deps[i]) {
+					continue
+				}
+				done[i] = true
+				list = append(list, pkg)
+				progress = true
+				break
+			}
+			if !progress {
+				return list
+			}
+		}
+	}
+	ordered = sorted()
+	if len(ordered) == len(packages) {
+		return ordered, nil, nil
+	}
+
+	inCycle := cycles(deps)
+	for i, pkg := range packages {
+		if inCycle[i] {
+			done[i] = true
+			cycle = append(cycle, pkg)
+		}
+	}
+	blocked = sorted()
+	return ordered, cycle, blocked
+}
+
+func allDone(done []bool, list []int) bool {
+	for _, j := range list {
+		if !done[j] {
+			return false
+		}
+	}
+	return true
+}
+
+// cycles tells which nodes of a dependency graph are in a cycle, finding
+// strongly connected components with Tarjan's algorithm.
+func cycles(deps [][]int) []bool {
+	var (
+		index   = make([]int, len(deps))
+		lowLink = make([]int, len(deps))
+		onStack = make([]bool, len(deps))
+		stack   []int
+		next    = 1
+		result  = make([]bool, len(deps))
+	)
+	var visit func(v int)
+	visit = func(v int) {
+		index[v], lowLink[v] = next, next
+		next++
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range deps[v] {
+			if index[w] == 0 {
+				visit(w)
+				if lowLink[w] < lowLink[v] {
+					lowLink[v] = lowLink[w]
+				}
+			} else if onStack[w] && index[w] < lowLink[v] {
+				lowLink[v] = index[w]
+			}
+		}
+		if lowLink[v] != index[v] {
+			return
+		}
+		var component []int
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			component = append(component, w)
+			if w == v {
+				break
+			}
+		}
+		if len(component) > 1 {
+			for _, w := range component {
+				result[w] = true
+			}
+		}
+	}
+	for v := range deps {
+		if index[v] == 0 {
+			visit(v)
+		}
+	}
+	return result
+}
+
+// DependsOn reports whether pkg needs any package produced by other.
+func (pkg *Package) DependsOn(other *Package) bool {
+	for _, dep := range pkg.Deps {
+		for _, name := range other.Names {
+			if dep == name {
+				return true
+			}
+		}
+	}
+	return false
+}
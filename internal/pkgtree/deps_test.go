@@ -0,0 +1,155 @@
+package pkgtree
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func names(list []*Package) string {
+	var s []string
+	for _, pkg := range list {
+		s = append(s, pkg.Name)
+	}
+	return strings.Join(s, " ")
+}
+
+// packages builds packages from "name:dep,dep" specs.
+func packages(specs ...string) []*Package {
+	var list []*Package
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 2)
+		pkg := &Package{Name: parts[0], Names: []string{parts[0]}}
+		if len(parts) == 2 && parts[1] != "" {
+			pkg.Deps = strings.Split(parts[1], ",")
+		}
+		list = append(list, pkg)
+	}
+	return list
+}
+
+func TestOrder(t *testing.T) {
+	tests := []struct {
+		name                    string
+		specs                   []string
+		ordered, cycle, blocked string
+	}{
+		{"independent", []string{"a", "b", "c"}, "a b c", "", ""},
+		{"dependency first", []string{"a:b", "b"}, "b a", "", ""},
+		{"chain", []string{"a:b", "b:c", "c"}, "c b a", "", ""},
+		{"outside dependency", []string{"a:x", "b"}, "a b", "", ""},
+		{"self dependency", []string{"a:a", "b"}, "a b", "", ""},
+		{"cycle", []string{"a:b", "b:a", "c"}, "c", "a b", ""},
+		{"blocked", []string{"d:a", "a:b", "b:a", "c"}, "c", "a b", "d"},
+		{"blocked chain", []string{"e:d", "d:a", "a:b", "b:a"}, "", "a b", "d e"},
+		{"two cycles", []string{"a:b", "b:a", "c:d", "d:c", "e:c"}, "", "a b c d", "e"},
+	}
+	for _, test := range tests {
+		ordered, cycle, blocked := Order(packages(test.specs...))
+		if got := names(ordered); got != test.ordered {
+			t.Errorf("%s: ordered %q, want %q", test.name, got, test.ordered)
+		}
+		if got := names(cycle); got != test.cycle {
+			t.Errorf("%s: cycle %q, want %q", test.name, got, test.cycle)
+		}
+		if got := names(blocked); got != test.blocked {
+			t.Errorf("%s: blocked %q, want %q", test.name, got, test.blocked)
+		}
+	}
+}
+
+func TestParseDeps(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"a b", []string{"a", "b"}},
+		{"a>=1.0 b<<2 c==3", []string{"a", "b", "c"}},
+		{"a|b c", []string{"a", "c"}},
+		{"  a\tb  ", []string{"a", "b"}},
+	}
+	for _, test := range tests {
+		if got := parseDeps(test.in); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseDeps(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func writeFile(t *testing.T, file string, content string) {
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadDefines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ciel-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "defines")
+	writeFile(t, file, `PKGNAME=foo
+PKGSEC=utils
+# PKGDEP="commented"
+PKGDEP="a b>=1.0 \
+        c"
+BUILDDEP='d'
+PKGDEP__AMD64="a e"
+PKGDES="$PKGNAME is $PKGSEC"
+export X=1
+`)
+	want := map[string]string{
+		"PKGNAME":       "foo",
+		"PKGSEC":        "utils",
+		"PKGDEP":        "a b>=1.0   c",
+		"BUILDDEP":      "d",
+		"PKGDEP__AMD64": "a e",
+		"PKGDES":        "$PKGNAME is $PKGSEC",
+	}
+	got, err := readDefines(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readDefines() = %q, want %q", got, want)
+	}
+}
+
+func TestFindArch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ciel-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile(t, path.Join(dir, "utils", "foo", SpecFileName), "VER=1.0\n")
+	writeFile(t, path.Join(dir, "utils", "foo", "autobuild", "defines"), `PKGNAME=foo
+PKGDEP="a b"
+PKGDEP__ARM64="a c"
+BUILDDEP__AMD64="d"
+`)
+	tests := []struct {
+		arch string
+		deps []string
+	}{
+		{"amd64", []string{"a", "b", "d"}},
+		{"arm64", []string{"a", "c"}},
+		{"riscv64", []string{"a", "b"}},
+	}
+	for _, test := range tests {
+		tree := &Tree{BasePath: dir, Arch: test.arch}
+		pkg, err := tree.Find("foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(pkg.Deps, test.deps) {
+			t.Errorf("%s: deps %q, want %q", test.arch, pkg.Deps, test.deps)
+		}
+	}
+}
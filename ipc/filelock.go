@@ -0,0 +1,146 @@
+package ipc
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	LockRetryInterval = 100 * time.Millisecond
+)
+
+var ErrLockTimeout = errors.New("timed out waiting for the lock")
+
+// FileLock is an exclusive flock(2) on a file. It is held through an open
+// file, so it is released when the holder dies. The holder writes its PID
+// and command line into the file, for others to tell who holds it.
+type FileLock struct {
+	Path string
+	f    *os.File
+}
+
+// Holder is the process holding a lock.
+type Holder struct {
+	PID     int
+	Command string
+}
+
+func (h *Holder) String() string {
+	return strconv.Itoa(h.PID) + " (" + h.Command + ")"
+}
+
+func NewFileLock(p string) *FileLock {
+	return &FileLock{Path: p}
+}
+
+func (l *FileLock) open() error {
+	if l.f != nil {
+		return nil
+	}
+	f, err := os.OpenFile(l.Path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	l.f = f
+	return nil
+}
+
+func (l *FileLock) flock(how int) error {
+	if err := l.open(); err != nil {
+		return err
+	}
+	for {
+		err := syscall.Flock(int(l.f.Fd()), how)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// Lock waits until the lock is acquired.
+func (l *FileLock) Lock() error {
+	if err := l.flock(syscall.LOCK_EX); err != nil {
+		return err
+	}
+	l.record()
+	return nil
+}
+
+// TryLock acquires the lock if it is free. It returns false without an
+// error if another process holds it.
+func (l *FileLock) TryLock() (bool, error) {
+	err := l.flock(syscall.LOCK_EX | syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	l.record()
+	return true, nil
+}
+
+// LockTimeout waits at most timeout for the lock, a zero timeout means
+// trying only once. It fails with ErrLockTimeout if the lock is still held
+// by another process then, and at once on any other error.
+func (l *FileLock) LockTimeout(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := l.TryLock()
+		if err != nil {
+			return err
+		} else if ok {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return ErrLockTimeout
+		}
+		time.Sleep(LockRetryInterval)
+	}
+}
+
+func (l *FileLock) Unlock() {
+	if l.f == nil {
+		return
+	}
+	l.f.Truncate(0)
+	l.f.Close()
+	l.f = nil
+}
+
+func (l *FileLock) record() {
+	l.f.Truncate(0)
+	fmt.Fprintf(l.f, "%d\n%s\n", os.Getpid(), strings.Join(os.Args, " "))
+	l.f.Sync()
+}
+
+// Holder returns the process holding the lock, or nil if it is free. It
+// only reads what the holder recorded, as taking the lock even shared
+// would make others fail to take it meanwhile. A holder that died without
+// unlocking is taken as gone.
+func (l *FileLock) Holder() (*Holder, error) {
+	b, err := ioutil.ReadFile(l.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	lines := strings.SplitN(string(b), "\n", 3)
+	pid, err := strconv.Atoi(lines[0])
+	if err != nil || pid <= 0 {
+		return nil, nil
+	}
+	if err := syscall.Kill(pid, 0); err == syscall.ESRCH {
+		return nil, nil
+	}
+	holder := &Holder{PID: pid, Command: "?"}
+	if len(lines) > 1 && lines[1] != "" {
+		holder.Command = lines[1]
+	}
+	return holder, nil
+}
@@ -0,0 +1,77 @@
+package ipc
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestFileLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ciel-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := path.Join(dir, "lock")
+	a, b := NewFileLock(p), NewFileLock(p)
+
+	if holder, err := a.Holder(); err != nil || holder != nil {
+		t.Fatalf("holder of a missing lock %v, %v", holder, err)
+	}
+	if ok, err := a.TryLock(); !ok || err != nil {
+		t.Fatalf("lock not taken: %v", err)
+	}
+	defer a.Unlock()
+	holder, err := b.Holder()
+	if err != nil || holder == nil || holder.PID != os.Getpid() {
+		t.Fatalf("holder %v, %v, want %d", holder, err, os.Getpid())
+	}
+	// looking at the holder must not get in the way of contenders
+	if ok, err := b.TryLock(); ok || err != nil {
+		t.Fatalf("held lock taken again: %v, %v", ok, err)
+	}
+	if err := b.LockTimeout(0); err != ErrLockTimeout {
+		t.Fatalf("held lock: %v, want %v", err, ErrLockTimeout)
+	}
+	a.Unlock()
+	if holder, err := b.Holder(); err != nil || holder != nil {
+		t.Fatalf("holder of a free lock %v, %v", holder, err)
+	}
+	if err := b.LockTimeout(0); err != nil {
+		t.Fatal(err)
+	}
+	b.Unlock()
+}
+
+func TestFileLockError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ciel-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l := NewFileLock(path.Join(dir, "missing", "lock"))
+	if ok, err := l.TryLock(); ok || err == nil {
+		t.Fatalf("lock in a missing directory: %v, %v", ok, err)
+	}
+	if err := l.LockTimeout(time.Minute); err == nil || err == ErrLockTimeout {
+		t.Fatalf("lock in a missing directory: %v", err)
+	}
+}
+
+func TestHolderGone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ciel-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := path.Join(dir, "lock")
+	// recorded by a process killed while holding the lock
+	if err := ioutil.WriteFile(p, []byte("2147483647\nciel build\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if holder, err := NewFileLock(p).Holder(); err != nil || holder != nil {
+		t.Fatalf("holder %v, %v, want none", holder, err)
+	}
+}
@@ -6,8 +6,6 @@ import (
 	"path/filepath"
 	"strings"
 	"syscall"
-
-	d "github.com/AOSC-Dev/ciel/display"
 )
 
 type Instance struct {
@@ -75,24 +73,20 @@ func (i *Instance) Unmount() error {
 	return err
 }
 
+// Rollback empties the diff dir, the top layer.
 func (i *Instance) Rollback() error {
-	d.ITEM("get diff dir")
 	layers := i.Layers
 	dir := layers[len(layers)-1]
-	d.Println(d.C(d.WHITE, dir))
-
-	d.ITEM("clean diff dir")
 	fi, err := ioutil.ReadDir(dir)
-	if err == nil {
-		for _, f := range fi {
-			err = os.RemoveAll(filepath.Join(dir, f.Name()))
-		}
-		d.ERR(err)
-	} else if os.IsNotExist(err) {
-		d.SKIPPED()
-	} else {
-		d.FAILED_BECAUSE(err.Error())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
 		return err
 	}
+	for _, f := range fi {
+		if err := os.RemoveAll(filepath.Join(dir, f.Name())); err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -0,0 +1,418 @@
+package ciel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AOSC-Dev/ciel/internal/mounts"
+	"github.com/AOSC-Dev/ciel/internal/packaging"
+	"github.com/AOSC-Dev/ciel/internal/pkgtree"
+	"github.com/AOSC-Dev/ciel/systemd-api/nspawn"
+)
+
+var (
+	ErrHermeticNoBoot = errors.New("hermetic builds need to boot the instance")
+	ErrNetworkAccess  = errors.New("the instance tried to reach the network while compiling")
+)
+
+// RefreshLocalRepo regenerates the indices of suite in the repository at
+// repoDir; on the first run, repoDir is the root of the system to import
+// the signing key into instead. It returns the exit status of the plugin.
+func RefreshLocalRepo(repoDir string, suite string, firstRun bool) (int, error) {
+	return packaging.RefreshLocalRepo(repoDir, suite, firstRun)
+}
+
+// Builder runs acbs-build in an instance, putting artifacts into the first
+// suite and keeping the local repository up to date.
+type Builder struct {
+	Workspace *Workspace
+	Instance  *Instance
+	Suites    []string
+	Network   string // network mode, empty for the one of the instance
+	Limits    Limits // overriding the limits of the instance
+	NoBoot    bool
+
+	// Clean and CleanAfter roll the instance back to the underlying OS, or
+	// to Baseline if set, before and after each build.
+	Clean      bool
+	CleanAfter bool
+	Baseline   string
+
+	// Hermetic fetches sources and installs build dependencies with
+	// FetchNetwork, or Network if empty, then compiles in the instance
+	// rebooted without network.
+	Hermetic     bool
+	FetchNetwork string
+
+	// Wait is how long to wait for the instance if another process holds
+	// it.
+	Wait time.Duration
+
+	// Builds are connected to these streams, besides being logged in
+	// OUTPUT/logs.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Notify receives the steps of each build, with either an error or a
+	// detail; nil to ignore them.
+	Notify func(step string, err error, detail string)
+
+	// Trace receives the command lines run in the instance, nil to ignore
+	// them.
+	Trace func(args []string)
+
+	usingLocalRepo bool
+	debsBind       *mounts.Entry
+}
+
+// NewBuilder returns a builder for the instance, with the defaults from
+// the configuration of the workspace.
+func (w *Workspace) NewBuilder(inst *Instance) (*Builder, error) {
+	config, err := w.ciel.Config()
+	if err != nil {
+		return nil, err
+	}
+	return &Builder{
+		Workspace:    w,
+		Instance:     inst,
+		Suites:       []string{packaging.DefaultSuite},
+		Clean:        config.Build.Clean,
+		CleanAfter:   config.Build.CleanAfter,
+		Baseline:     config.Build.Baseline,
+		Hermetic:     config.Build.Hermetic,
+		FetchNetwork: config.Build.FetchNetwork,
+	}, nil
+}
+
+func (b *Builder) Suite() string { return b.Suites[0] }
+
+// CheckSuite returns ErrInvalidSuite if suite cannot be the name of a suite.
+func CheckSuite(suite string) error {
+	return packaging.CheckSuite(suite)
+}
+
+// Lock takes the instance for the builder, rolling back an instance which
+// is held by another process is refused.
+func (b *Builder) Lock() error {
+	if b.Hermetic && b.NoBoot {
+		return ErrHermeticNoBoot
+	}
+	if err := b.Instance.Lock(b.Wait); err != nil {
+		return err
+	}
+	if b.Clean || b.CleanAfter {
+		exclusive, err := b.Instance.inst.RunningAsExclusiveMode()
+		if err != nil || exclusive {
+			b.Instance.Unlock()
+		}
+		if err != nil {
+			return err
+		} else if exclusive {
+			return ErrBusy
+		}
+	}
+	return nil
+}
+
+// Unlock releases the instance.
+func (b *Builder) Unlock() {
+	b.Instance.Unlock()
+}
+
+// cleanRoom rolls the instance back, leaving OUTPUT and TREE alone as they
+// are unbound along with the instance.
+func (b *Builder) cleanRoom() error {
+	b.Teardown()
+	if err := b.Instance.Rollback(b.Baseline); err != nil {
+		return err
+	}
+	return b.Setup()
+}
+
+// Setup mounts the instance, binds its staging directory to the output
+// directory, and points the local repository to the suites.
+func (b *Builder) Setup() error {
+	inst := b.Instance.inst
+	output := b.Workspace.ciel.Output()
+	if err := inst.Mount(); err != nil {
+		return err
+	}
+
+	bind := output.StagingMount(inst.Name)
+	if err := bind.Mount(inst.MountPoint()); err != nil {
+		return err
+	}
+	b.debsBind = &bind
+	aptConfigPath := path.Join(inst.MountPoint(), packaging.DefaultRepoConfig)
+	if _, err := os.Stat(aptConfigPath); err == nil {
+		b.usingLocalRepo = true
+	}
+	if b.usingLocalRepo {
+		if err := packaging.SetLocalRepoSuites(false, inst, inst.Parent, b.Suites); err != nil {
+			return err
+		}
+		for _, s := range b.Suites {
+			if _, err := os.Stat(path.Join(output.SuiteDir(s), "InRelease")); err != nil {
+				os.MkdirAll(output.PoolDir(s), 0755)
+				RefreshLocalRepo(output.BasePath, s, false)
+			}
+		}
+	}
+	return nil
+}
+
+// Teardown unbinds the staging directory from the output directory.
+func (b *Builder) Teardown() error {
+	if b.debsBind == nil {
+		return nil
+	}
+	err := b.debsBind.Unmount(b.Instance.Root(), false)
+	b.debsBind = nil
+	return err
+}
+
+// refreshLocalRepo regenerates the indices of the suite built into.
+func (b *Builder) refreshLocalRepo() {
+	if !b.usingLocalRepo {
+		return
+	}
+	exitStatus, err := RefreshLocalRepo(b.Workspace.ciel.Output().BasePath, b.Suite(), false)
+	if err == nil && exitStatus != 0 {
+		err = fmt.Errorf("ciel-localrepo exited with status %d", exitStatus)
+	}
+	b.note("refresh local repository", err, "OK")
+}
+
+func (b *Builder) note(step string, err error, detail string) {
+	if b.Notify != nil {
+		b.Notify(step, err, detail)
+	}
+}
+
+// Build runs a single acbs-build invocation, logging its output and
+// collecting the artifacts.
+func (b *Builder) Build(ctx context.Context, args []string) *Report {
+	inst := b.Instance.inst
+	output := b.Workspace.ciel.Output()
+	report := &Report{
+		Packages: args,
+		Instance: inst.Name,
+		Suite:    b.Suite(),
+		Hermetic: b.Hermetic,
+		Dist:     DistInfo(packaging.ReadDistInfo(inst.Parent.DistDir())),
+	}
+	fail := func(step string, err error) *Report {
+		b.note(step, err, "")
+		report.ExitStatus = -1
+		report.Error = err.Error()
+		return report
+	}
+	if b.Clean {
+		if err := b.cleanRoom(); err != nil {
+			return fail("roll back before building", err)
+		}
+	}
+	if b.CleanAfter {
+		defer func() {
+			err := b.cleanRoom()
+			b.note("roll back after building", err, "OK")
+		}()
+	}
+	report.TreeCommit, _ = b.Workspace.ciel.Tree().Commit()
+	// left by a build which was interrupted
+	if _, err := output.PublishStaged(inst.Name, b.Suite()); err != nil {
+		return fail("publish staged artifacts", err)
+	}
+
+	buildLog, err := output.NewBuildLog(args)
+	if err != nil {
+		return fail("create build log", err)
+	}
+	report.Log = buildLog.Path
+	opts := RunOptions{
+		Network: b.Network,
+		Limits:  b.Limits,
+		NoBoot:  b.NoBoot,
+		Stdin:   b.Stdin,
+		Stdout:  teeLog(b.Stdout, buildLog),
+		Stderr:  teeLog(b.Stderr, buildLog),
+		Trace:   b.Trace,
+	}
+
+	report.StartTime = time.Now()
+	meter := b.Instance.StartMeter()
+	var exitStatus int
+	if b.Hermetic {
+		exitStatus, err = b.hermeticBuild(ctx, args, opts)
+	} else {
+		opts.Command = `acbs-build ` + strings.Join(args, " ")
+		exitStatus, err = b.Instance.Run(ctx, opts)
+	}
+	usage := meter.Stop()
+	report.EndTime = time.Now()
+	report.Usage = &usage
+	report.ExitStatus = exitStatus
+	if err != nil {
+		b.note("build", err, "")
+		fmt.Fprintln(buildLog, err)
+		report.Error = err.Error()
+	}
+	buildLog.Close()
+	b.note("build log", nil, buildLog.Path)
+
+	artifacts, err := output.PublishStaged(inst.Name, b.Suite())
+	report.Artifacts = newArtifacts(artifacts)
+	for index := range report.Artifacts {
+		if rel, err := filepath.Rel(output.BasePath, report.Artifacts[index].Path); err == nil {
+			report.Artifacts[index].Path = rel
+		}
+	}
+	b.note("collect artifacts", err, strconv.Itoa(len(report.Artifacts))+" package(s)")
+	if exitStatus == 0 {
+		b.refreshLocalRepo()
+	}
+	return report
+}
+
+func teeLog(w io.Writer, buildLog io.Writer) io.Writer {
+	if w == nil {
+		return buildLog
+	}
+	return io.MultiWriter(w, buildLog)
+}
+
+// hermeticInstance is what hermetic builds need of an instance.
+type hermeticInstance interface {
+	Run(ctx context.Context, opts RunOptions) (int, error)
+	Stop(ctx context.Context) error
+	outNoRoutes() (uint64, error)
+}
+
+// hermeticBuild builds in two phases. Online, with FetchNetwork, it fetches
+// the sources and installs the build dependencies, which acbs would
+// otherwise install while building. Then it reboots the instance without
+// network to compile, failing if anything in it tries to reach the network.
+func (b *Builder) hermeticBuild(ctx context.Context, args []string, opts RunOptions) (int, error) {
+	deps, err := b.buildDeps(args)
+	if err != nil {
+		return -1, err
+	}
+	if b.FetchNetwork != "" {
+		opts.Network = b.FetchNetwork
+	}
+	return hermeticBuild(ctx, b.Instance, args, deps, opts)
+}
+
+func hermeticBuild(ctx context.Context, inst hermeticInstance, args []string, deps []string, opts RunOptions) (int, error) {
+	packages := strings.Join(args, " ")
+
+	fmt.Fprintln(opts.Stdout, "ciel: fetching sources")
+	opts.Command = `acbs-build -g ` + packages
+	exitStatus, err := inst.Run(ctx, opts)
+	if err != nil || exitStatus != 0 {
+		return exitStatus, err
+	}
+	if len(deps) != 0 {
+		fmt.Fprintln(opts.Stdout, "ciel: installing build dependencies")
+		opts.Command = `apt-get install -y --no-install-recommends ` + strings.Join(deps, " ")
+		exitStatus, err = inst.Run(ctx, opts)
+		if err != nil || exitStatus != 0 {
+			return exitStatus, err
+		}
+	}
+
+	// the network of an instance is set when it boots
+	if err := inst.Stop(ctx); err != nil {
+		return -1, err
+	}
+	defer inst.Stop(context.Background())
+	opts.Network = nspawn.NetworkNone
+	opts.Command = "true"
+	if _, err := inst.Run(ctx, opts); err != nil {
+		return -1, err
+	}
+	before, err := inst.outNoRoutes()
+	if err != nil {
+		return -1, err
+	}
+
+	fmt.Fprintln(opts.Stdout, "ciel: compiling without network")
+	opts.Command = `acbs-build ` + packages
+	exitStatus, err = inst.Run(ctx, opts)
+	if err != nil {
+		return exitStatus, err
+	}
+	// Without network, the instance has routes for loopback only, so any
+	// attempt to go further fails for lack of a route and is counted, be
+	// it a connection, a datagram or a ping. Connections to services in
+	// the instance itself are allowed.
+	after, err := inst.outNoRoutes()
+	if err != nil {
+		return -1, err
+	}
+	if after > before {
+		return -1, ErrNetworkAccess
+	}
+	return exitStatus, nil
+}
+
+// buildDeps returns the dependencies of the requested packages, leaving out
+// the ones they produce themselves.
+func (b *Builder) buildDeps(args []string) ([]string, error) {
+	var requests []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			requests = append(requests, arg)
+		}
+	}
+	tree := b.Workspace.ciel.Tree()
+	names, err := tree.Expand(requests)
+	if err != nil {
+		return nil, err
+	}
+	var packages []*pkgtree.Package
+	produced := make(map[string]bool)
+	for _, name := range names {
+		pkg, err := tree.Find(name)
+		if err == pkgtree.ErrNotFound {
+			// acbs-build reports it
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		packages = append(packages, pkg)
+		for _, name := range pkg.Names {
+			produced[name] = true
+		}
+	}
+	var deps []string
+	seen := make(map[string]bool)
+	for _, pkg := range packages {
+		for _, dep := range pkg.Deps {
+			if !produced[dep] && !seen[dep] {
+				seen[dep] = true
+				deps = append(deps, dep)
+			}
+		}
+	}
+	return deps, nil
+}
+
+// SaveReport writes the report to reportPath, or beside the build log.
+func (b *Builder) SaveReport(report *Report, reportPath string) error {
+	if reportPath == "" {
+		reportPath = packaging.ReportPath(report.Log)
+	}
+	err := report.Save(reportPath)
+	b.note("save build report", err, reportPath)
+	return err
+}
@@ -0,0 +1,147 @@
+package ciel
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/AOSC-Dev/ciel/systemd-api/nspawn"
+)
+
+// fakeInstance records what a hermetic build runs, and bumps the counter
+// of packets without routes when a command matches leak.
+type fakeInstance struct {
+	calls    []string
+	network  string
+	counter  uint64
+	leak     string
+	failures map[string]int
+}
+
+func (f *fakeInstance) Run(ctx context.Context, opts RunOptions) (int, error) {
+	if opts.Network != "" {
+		f.network = opts.Network
+	}
+	f.calls = append(f.calls, f.network+": "+opts.Command)
+	if f.leak != "" && strings.HasPrefix(opts.Command, f.leak) {
+		f.counter++
+	}
+	return f.failures[opts.Command], nil
+}
+
+func (f *fakeInstance) Stop(ctx context.Context) error {
+	f.calls = append(f.calls, "stop")
+	f.network = ""
+	return nil
+}
+
+func (f *fakeInstance) outNoRoutes() (uint64, error) {
+	f.calls = append(f.calls, "count")
+	return f.counter, nil
+}
+
+func TestHermeticBuildPhases(t *testing.T) {
+	f := &fakeInstance{}
+	opts := RunOptions{Network: nspawn.NetworkHost, Stdout: ioutil.Discard}
+	exitStatus, err := hermeticBuild(context.Background(), f, []string{"foo"}, []string{"bar", "baz"}, opts)
+	if err != nil || exitStatus != 0 {
+		t.Fatalf("exit status %d, %v", exitStatus, err)
+	}
+	want := []string{
+		"host: acbs-build -g foo",
+		"host: apt-get install -y --no-install-recommends bar baz",
+		"stop",
+		"none: true",
+		"count",
+		"none: acbs-build foo",
+		"count",
+		"stop",
+	}
+	if !reflect.DeepEqual(f.calls, want) {
+		t.Errorf("calls:\n%s\nwant:\n%s", strings.Join(f.calls, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestHermeticBuildNoDeps(t *testing.T) {
+	f := &fakeInstance{}
+	opts := RunOptions{Stdout: ioutil.Discard}
+	hermeticBuild(context.Background(), f, []string{"foo"}, nil, opts)
+	for _, call := range f.calls {
+		if strings.Contains(call, "apt-get") {
+			t.Errorf("installed dependencies without any: %s", call)
+		}
+	}
+}
+
+func TestHermeticBuildFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		leak       string
+		failures   map[string]int
+		exitStatus int
+		err        error
+		offline    bool
+	}{
+		{"fetch fails", "", map[string]int{"acbs-build -g foo": 2}, 2, nil, false},
+		{"dependencies fail", "", map[string]int{"apt-get install -y --no-install-recommends bar": 100}, 100, nil, false},
+		{"compile fails", "", map[string]int{"acbs-build foo": 1}, 1, nil, true},
+		{"network accessed", "acbs-build foo", nil, -1, ErrNetworkAccess, true},
+		{"network accessed while fetching", "acbs-build -g", nil, 0, nil, true},
+	}
+	for _, test := range tests {
+		f := &fakeInstance{leak: test.leak, failures: test.failures}
+		opts := RunOptions{Stdout: ioutil.Discard}
+		exitStatus, err := hermeticBuild(context.Background(), f, []string{"foo"}, []string{"bar"}, opts)
+		if exitStatus != test.exitStatus || err != test.err {
+			t.Errorf("%s: %d, %v, want %d, %v", test.name, exitStatus, err, test.exitStatus, test.err)
+		}
+		compiled := false
+		for _, call := range f.calls {
+			if call == "none: acbs-build foo" {
+				compiled = true
+			}
+		}
+		if compiled != test.offline {
+			t.Errorf("%s: compiled offline %v, want %v", test.name, compiled, test.offline)
+		}
+	}
+}
+
+func TestBuildDeps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ciel-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defines := map[string]string{
+		"foo": "PKGNAME=foo\nPKGDEP=\"bar libc\"\nBUILDDEP=\"cmake\"\n",
+		"bar": "PKGNAME=bar\nPKGDEP=\"libc\"\n",
+	}
+	for name, content := range defines {
+		pkgDir := path.Join(dir, "TREE", "utils", name)
+		if err := os.MkdirAll(path.Join(pkgDir, "autobuild"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		ioutil.WriteFile(path.Join(pkgDir, "spec"), nil, 0644)
+		ioutil.WriteFile(path.Join(pkgDir, "autobuild", "defines"), []byte(content), 0644)
+	}
+	b := &Builder{Workspace: New(dir)}
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"foo"}, []string{"bar", "libc", "cmake"}},
+		{[]string{"foo", "bar"}, []string{"libc", "cmake"}},
+		{[]string{"-c", "missing", "bar"}, []string{"libc"}},
+	}
+	for _, test := range tests {
+		deps, err := b.buildDeps(test.args)
+		if err != nil || !reflect.DeepEqual(deps, test.want) {
+			t.Errorf("buildDeps(%q) = %q, %v, want %q", test.args, deps, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package ciel
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"time"
+
+	"github.com/AOSC-Dev/ciel/cgroup-api"
+	"github.com/AOSC-Dev/ciel/internal/container/instance"
+	"github.com/AOSC-Dev/ciel/ipc"
+	"github.com/AOSC-Dev/ciel/proc-api"
+	"github.com/AOSC-Dev/ciel/systemd-api/nspawn"
+)
+
+// Instance is a container in the workspace, layered on the underlying OS.
+type Instance struct {
+	w    *Workspace
+	inst *instance.Instance
+}
+
+// Limits restricts the resources of an instance, zero values mean no
+// limit.
+type Limits struct {
+	Memory   string  `json:"memory,omitempty"`    // as in 8G, a percentage or infinity
+	CPUs     float64 `json:"cpus,omitempty"`      // in CPUs
+	TasksMax uint64  `json:"tasks_max,omitempty"` // number of tasks
+	IOWeight uint64  `json:"io_weight,omitempty"` // 1 to 10000
+}
+
+// Check validates the limits.
+func (l Limits) Check() error {
+	return nspawn.Limits(l).Check()
+}
+
+// Merge returns the limits, overridden by the ones set in o.
+func (l Limits) Merge(o Limits) Limits {
+	return Limits(nspawn.Limits(l).Merge(nspawn.Limits(o)))
+}
+
+// Empty reports whether no limit is set.
+func (l Limits) Empty() bool {
+	return l == Limits{}
+}
+
+func (l Limits) String() string {
+	return nspawn.Limits(l).String()
+}
+
+// RunOptions describes a command to run in an instance.
+type RunOptions struct {
+	// Command is a command line run by the login shell of root, Args is a
+	// command run directly. Without either of them, an interactive shell
+	// is opened.
+	Command string
+	Args    []string
+
+	Network string // network mode, empty for the one of the instance
+	Limits  Limits // overriding the limits of the instance
+	NoBoot  bool   // run without booting the instance
+
+	// Trace receives the command lines run to start the command, nil to
+	// ignore them.
+	Trace func(args []string)
+
+	// The standard streams of the process are used if all of them are nil.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+func (i *Instance) Name() string { return i.inst.Name }
+
+// Root is the root directory of the instance, while it is mounted.
+func (i *Instance) Root() string { return i.inst.MountPoint() }
+
+func (i *Instance) Mounted() bool { return i.inst.Mounted() }
+func (i *Instance) Running() bool { return i.inst.Running() }
+
+// Mount mounts the file system and bind mounts of the instance.
+func (i *Instance) Mount() error {
+	return i.inst.Mount()
+}
+
+// Unmount stops the instance if it is running, then unmounts it if it is
+// mounted.
+func (i *Instance) Unmount() error {
+	err := i.inst.Unmount()
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Network returns the network mode the instance runs with, mode if it is
+// given, or else the one of the instance or of the workspace.
+func (i *Instance) Network(mode string) (string, error) {
+	network, err := i.inst.Network(mode)
+	if err != nil {
+		return "", err
+	}
+	return network.Mode, nil
+}
+
+// Stop shuts the instance down.
+func (i *Instance) Stop(ctx context.Context) error {
+	return i.inst.Stop(ctx)
+}
+
+// Lock takes the instance for building or rolling back, waiting at most
+// wait if another process holds it, then failing with ErrBusy.
+func (i *Instance) Lock(wait time.Duration) error {
+	err := i.inst.BuildLock().LockTimeout(wait)
+	if err == ipc.ErrLockTimeout {
+		return ErrBusy
+	}
+	return err
+}
+
+func (i *Instance) Unlock() {
+	i.inst.BuildLock().Unlock()
+}
+
+// Run runs a command in the instance, mounting it first, and returns the
+// exit status of the command.
+func (i *Instance) Run(ctx context.Context, opts RunOptions) (int, error) {
+	inst := i.inst
+	if err := inst.Mount(); err != nil {
+		return -1, err
+	}
+	network, err := inst.Network(opts.Network)
+	if err != nil {
+		return -1, err
+	}
+	limits, err := inst.Limits(nspawn.Limits(opts.Limits))
+	if err != nil {
+		return -1, err
+	}
+	ctnInfo := &nspawn.ContainerInfo{
+		Init:       !opts.NoBoot,
+		Properties: limits.Properties(),
+		Network:    network,
+		Trace:      opts.Trace,
+	}
+
+	args := opts.Args
+	if len(args) == 0 {
+		rootShell, err := inst.Shell("root")
+		if err != nil {
+			return -1, err
+		}
+		args = []string{rootShell}
+		if opts.Command != "" {
+			args = append(args, "--login", "-c", opts.Command)
+		}
+	}
+	runInfo := &nspawn.RunInfo{
+		App:  args[0],
+		Args: args[1:],
+		// interactive shells are opened with machinectl, for the terminal
+		UseSystemdRun: opts.Command != "" || len(opts.Args) != 0,
+	}
+	if opts.Stdin != nil || opts.Stdout != nil || opts.Stderr != nil {
+		runInfo.StdDev = &nspawn.StdDevInfo{
+			Stdin:  opts.Stdin,
+			Stdout: opts.Stdout,
+			Stderr: opts.Stderr,
+		}
+	}
+	return inst.Run(ctx, ctnInfo, runInfo)
+}
+
+// outNoRoutes counts packets the running instance failed to send for lack
+// of a route.
+func (i *Instance) outNoRoutes() (uint64, error) {
+	leader, err := i.inst.Leader()
+	if err != nil {
+		return 0, err
+	}
+	return proc.OutNoRoutes(leader)
+}
+
+// StartMeter starts measuring the resource usage of the instance.
+func (i *Instance) StartMeter() *Meter {
+	return &Meter{cgroup.StartMeter(i.inst.CGroup)}
+}
+
+// Commit merges the changes in the instance into the underlying OS,
+// unmounting the instance first.
+func (i *Instance) Commit() error {
+	if i.inst.Mounted() {
+		if err := i.inst.Unmount(); err != nil {
+			return err
+		}
+	}
+	return i.inst.FileSystem().Merge()
+}
+
+// Rollback discards the changes in the instance, back to the underlying OS
+// or to a baseline if it is not empty. The instance is unmounted first.
+func (i *Instance) Rollback(baseline string) error {
+	if i.inst.Mounted() {
+		if err := i.inst.Unmount(); err != nil {
+			return err
+		}
+	}
+	return i.inst.RollbackTo(baseline)
+}
+
+func (i *Instance) Baselines() ([]string, error) {
+	return i.inst.Baselines()
+}
+
+// SaveBaseline saves the changes in the instance as a baseline, unmounting
+// the instance first.
+func (i *Instance) SaveBaseline(name string) error {
+	if i.inst.Mounted() {
+		if err := i.inst.Unmount(); err != nil {
+			return err
+		}
+	}
+	return i.inst.SaveBaseline(name)
+}
+
+// CheckBaseline returns ErrInvalidBaseline if name cannot be a baseline.
+func CheckBaseline(name string) error {
+	return instance.CheckBaseline(name)
+}
+
+func (i *Instance) RemoveBaseline(name string) error {
+	return i.inst.RemoveBaseline(name)
+}
+
+func copyLocalLayer(from, to *instance.Instance) error {
+	src := path.Join(from.Dir(), instance.LayerDirName, "local") + "/."
+	dst := path.Join(to.Dir(), instance.LayerDirName, "local")
+	output, err := exec.Command("cp", "-a", src, dst).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(output))
+	}
+	return nil
+}
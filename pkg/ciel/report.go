@@ -0,0 +1,99 @@
+package ciel
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/AOSC-Dev/ciel/cgroup-api"
+	"github.com/AOSC-Dev/ciel/internal/packaging"
+)
+
+// Report describes the result of a build, for machines to consume.
+type Report struct {
+	Packages   []string   `json:"packages"`
+	Instance   string     `json:"instance"`
+	Suite      string     `json:"suite"`
+	Hermetic   bool       `json:"hermetic,omitempty"`
+	Dist       DistInfo   `json:"dist"`
+	TreeCommit string     `json:"tree_commit"`
+	StartTime  time.Time  `json:"start_time"`
+	EndTime    time.Time  `json:"end_time"`
+	ExitStatus int        `json:"exit_status"`
+	Error      string     `json:"error,omitempty"`
+	Artifacts  []Artifact `json:"artifacts"`
+	Log        string     `json:"log"`
+	// Usage is the resource usage of the instance during the build.
+	Usage *Usage `json:"usage,omitempty"`
+}
+
+// DistInfo identifies the underlying OS, taken from its os-release(5).
+type DistInfo struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	VersionID string `json:"version_id,omitempty"`
+	BuildID   string `json:"build_id,omitempty"`
+}
+
+// Artifact is a package produced by a build.
+type Artifact struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Usage is the resource usage of an instance while a command runs in it.
+type Usage struct {
+	PeakMemory   uint64  `json:"peak_memory_bytes"`
+	CPUSeconds   float64 `json:"cpu_seconds"`
+	IOReadBytes  uint64  `json:"io_read_bytes"`
+	IOWriteBytes uint64  `json:"io_write_bytes"`
+	WallSeconds  float64 `json:"wall_seconds"`
+}
+
+// Meter measures the resource usage of an instance.
+type Meter struct {
+	meter *cgroup.Meter
+}
+
+// Stop ends the measurement and returns the usage.
+func (m *Meter) Stop() Usage {
+	return Usage(m.meter.Stop())
+}
+
+func newArtifacts(list []packaging.Artifact) []Artifact {
+	var artifacts []Artifact
+	for _, a := range list {
+		artifacts = append(artifacts, Artifact(a))
+	}
+	return artifacts
+}
+
+// Save writes the report to p as indented JSON.
+func (r *Report) Save(p string) error {
+	return saveJSON(r, p)
+}
+
+// SaveReports writes the reports of a build queue to p as a JSON array.
+func SaveReports(reports []*Report, p string) error {
+	if reports == nil {
+		reports = []*Report{}
+	}
+	return saveJSON(reports, p)
+}
+
+func saveJSON(v interface{}, p string) error {
+	if dir := path.Dir(p); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, append(b, '\n'), 0644)
+}
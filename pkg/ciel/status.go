@@ -0,0 +1,244 @@
+package ciel
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/AOSC-Dev/ciel/internal/container/instance"
+	"github.com/AOSC-Dev/ciel/ipc"
+	"github.com/AOSC-Dev/ciel/systemd-api/machined"
+	"github.com/AOSC-Dev/ciel/systemd-api/nspawn"
+)
+
+// Modes of a running instance.
+const (
+	BootModeBoot      = "boot"      // booted with systemd
+	BootModeExclusive = "exclusive" // running a single command without booting
+)
+
+// WorkspaceStatus is the state of a workspace, as printed by ciel doctor
+// -format json. Fields are only ever added to it.
+type WorkspaceStatus struct {
+	Dir       string      `json:"dir"`
+	Version   int         `json:"version"`
+	ID        string      `json:"id"`
+	Dist      LayerStatus `json:"dist"` // the underlying OS
+	Instances []Status    `json:"instances"`
+	// StrayMachines are registered under the workspace with no instance.
+	StrayMachines []string `json:"stray_machines"`
+	Errors        []string `json:"errors,omitempty"`
+}
+
+// Status is the state of an instance, as printed by ciel list -format json.
+// Fields are only ever added to it.
+type Status struct {
+	Name    string `json:"name"`
+	Mounted bool   `json:"mounted"`
+	Running bool   `json:"running"`
+	// BootMode is BootModeBoot or BootModeExclusive while running.
+	BootMode string `json:"boot_mode,omitempty"`
+	// Network and Limits are the defaults of the instance.
+	Network string         `json:"network,omitempty"`
+	Limits  Limits         `json:"limits"`
+	Locks   []LockStatus   `json:"locks"`
+	Layers  []LayerStatus  `json:"layers"`
+	Machine *MachineStatus `json:"machine,omitempty"`
+	// Errors are the ones met while inspecting the instance, the fields
+	// concerned are left empty.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// LockStatus is a lock of an instance: "file" is held while mounting,
+// "run" while starting the container and "build" by builds.
+type LockStatus struct {
+	Name   string  `json:"name"`
+	Holder *Holder `json:"holder,omitempty"` // nil if not held
+}
+
+type Holder struct {
+	PID     int    `json:"pid"`
+	Command string `json:"command"`
+}
+
+// LayerStatus is a directory of the overlay file system, with the absolute
+// path. Size is the total size of the files in it, in bytes, or -1 if not
+// measured.
+type LayerStatus struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// MachineStatus is the machine registered for a running instance.
+type MachineStatus struct {
+	Name  string `json:"name"`
+	State string `json:"state"` // "opening", "running" or "closing"
+	Class string `json:"class"`
+	Root  string `json:"root"`
+	// System is the state of the system manager in the instance, as
+	// "running" or "degraded", empty if not asked or unreachable.
+	System string `json:"system,omitempty"`
+}
+
+// StatusOptions selects the slower parts of a status.
+type StatusOptions struct {
+	Sizes  bool // measure the layers
+	System bool // ask the system manager in the instance for its state
+}
+
+// Status inspects the workspace and all its instances.
+func (w *Workspace) Status(opts StatusOptions) (*WorkspaceStatus, error) {
+	version, err := w.ciel.WorkspaceVersion()
+	if err != nil {
+		return nil, err
+	}
+	list, err := w.Instances()
+	if err != nil {
+		return nil, err
+	}
+	c := w.ciel.Container()
+	s := &WorkspaceStatus{
+		Dir:           absPath(w.Dir()),
+		Version:       version,
+		ID:            w.ciel.ID(),
+		Dist:          LayerStatus{Name: "dist", Path: absPath(c.DistDir()), Size: -1},
+		Instances:     []Status{},
+		StrayMachines: []string{},
+	}
+	if opts.Sizes {
+		if s.Dist.Size, err = diskUsage(s.Dist.Path); err != nil {
+			s.Errors = append(s.Errors, err.Error())
+		}
+	}
+	for _, inst := range list {
+		s.Instances = append(s.Instances, inst.Status(opts))
+	}
+	if stray, err := c.StrayMachines(); err != nil {
+		s.Errors = append(s.Errors, err.Error())
+	} else if stray != nil {
+		s.StrayMachines = stray
+	}
+	return s, nil
+}
+
+// Status inspects the instance, errors are recorded in the status.
+func (i *Instance) Status(opts StatusOptions) Status {
+	inst := i.inst
+	s := Status{
+		Name:    inst.Name,
+		Mounted: inst.Mounted(),
+		Locks:   []LockStatus{},
+		Layers:  []LayerStatus{},
+	}
+	addErr := func(err error) {
+		s.Errors = append(s.Errors, err.Error())
+	}
+
+	if config, err := inst.Config(); err != nil {
+		addErr(err)
+	} else {
+		s.Network = config.Network
+		s.Limits = Limits(config.Limits)
+	}
+
+	for _, l := range []struct {
+		name string
+		lock *ipc.FileLock
+	}{
+		{"file", inst.FileSystemLock()},
+		{"run", inst.RunLock()},
+		{"build", inst.BuildLock()},
+	} {
+		ls := LockStatus{Name: l.name}
+		if holder, err := l.lock.Holder(); err != nil {
+			addErr(err)
+		} else if holder != nil {
+			ls.Holder = &Holder{PID: holder.PID, Command: holder.Command}
+		}
+		s.Locks = append(s.Locks, ls)
+	}
+
+	for _, name := range []string{"local", "diff"} {
+		layer := LayerStatus{
+			Name: name,
+			Path: absPath(path.Join(inst.Dir(), instance.LayerDirName, name)),
+			Size: -1,
+		}
+		if opts.Sizes {
+			size, err := diskUsage(layer.Path)
+			if err != nil {
+				addErr(err)
+			}
+			layer.Size = size
+		}
+		s.Layers = append(s.Layers, layer)
+	}
+
+	machine, err := inst.Machine()
+	if machined.IsNoSuchMachine(err) {
+		return s
+	} else if err != nil {
+		addErr(err)
+		return s
+	}
+	s.Running = true
+	if boot, err := inst.RunningAsBootMode(); err != nil {
+		addErr(err)
+	} else if boot {
+		s.BootMode = BootModeBoot
+	} else {
+		s.BootMode = BootModeExclusive
+	}
+	m := &MachineStatus{Name: inst.MachineId()}
+	m.State, _ = machine.State()
+	m.Class, _ = machine.Class()
+	m.Root, _ = machine.RootDirectory()
+	if opts.System {
+		m.System = nspawn.MachineStatus(context.Background(), m.Name)
+	}
+	s.Machine = m
+	return s
+}
+
+func absPath(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
+
+// diskUsage sums the sizes of the files in a directory.
+func diskUsage(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// CacheStatus is a cache shared by all instances, as printed by ciel cache
+// stats -format json.
+type CacheStatus struct {
+	Name   string `json:"name"`
+	Dir    string `json:"dir"`
+	Target string `json:"target"` // mount point in instances
+	Files  int    `json:"files"`
+	Size   int64  `json:"size"`
+	Error  string `json:"error,omitempty"`
+}
+
+// LogStatus is a build log in OUTPUT/logs, as printed by ciel logs -format
+// json.
+type LogStatus struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Building bool   `json:"building"` // still being written
+}
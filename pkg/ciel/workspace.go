@@ -0,0 +1,272 @@
+// Package ciel is the Go API of Ciel, for tools embedding it. The ciel
+// command is built on top of it. Nothing is printed: failures are returned
+// as errors, and the steps of builds are reported to Builder.Notify.
+package ciel
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/AOSC-Dev/ciel/internal/ciel"
+	"github.com/AOSC-Dev/ciel/internal/container"
+	"github.com/AOSC-Dev/ciel/internal/container/instance"
+	"github.com/AOSC-Dev/ciel/internal/packaging"
+	"github.com/AOSC-Dev/ciel/internal/pkgtree"
+)
+
+var (
+	ErrNotWorkspace    = ciel.ErrNotCiel
+	ErrNoInstName      = container.ErrNoInstName
+	ErrInvalidInstName = container.ErrInvalidInstName
+	ErrInstExists      = errors.New("instance already exists")
+	ErrBusy            = instance.ErrBusy
+	ErrInvalidBaseline = instance.ErrInvalidBaseline
+	ErrInvalidSuite    = packaging.ErrInvalidSuiteName
+)
+
+// ErrNewerVersion is returned for workspaces written by a newer Ciel.
+type ErrNewerVersion struct {
+	Version int
+}
+
+func (e ErrNewerVersion) Error() string {
+	return ciel.ErrNewerVersion{Version: e.Version}.Error()
+}
+
+// ErrOlderVersion is returned for workspaces which need migrating.
+type ErrOlderVersion struct {
+	Pending []Migration
+}
+
+func (e ErrOlderVersion) Error() string {
+	return ciel.ErrOlderVersion{}.Error()
+}
+
+// ErrNoSuchInstance is returned for instances not in the workspace.
+type ErrNoSuchInstance struct {
+	Name string
+}
+
+func (e ErrNoSuchInstance) Error() string {
+	return container.ErrNoSuchInstance{Name: e.Name}.Error()
+}
+
+// apiError converts the errors of the internal packages which callers of
+// the API may check for.
+func apiError(err error) error {
+	switch e := err.(type) {
+	case ciel.ErrNewerVersion:
+		return ErrNewerVersion{Version: e.Version}
+	case ciel.ErrOlderVersion:
+		return ErrOlderVersion{Pending: newMigrations(e.Pending)}
+	case container.ErrNoSuchInstance:
+		return ErrNoSuchInstance{Name: e.Name}
+	}
+	return err
+}
+
+// Migration upgrades a workspace from version From to From+1.
+type Migration struct {
+	From    int
+	Summary string
+
+	m ciel.Migration
+}
+
+func (m Migration) String() string { return m.m.String() }
+
+func newMigrations(list []ciel.Migration) []Migration {
+	var migrations []Migration
+	for _, m := range list {
+		migrations = append(migrations, Migration{From: m.From, Summary: m.Summary, m: m})
+	}
+	return migrations
+}
+
+// Workspace is a Ciel work directory.
+type Workspace struct {
+	ciel *ciel.Ciel
+}
+
+// New returns the workspace in dir without checking it.
+func New(dir string) *Workspace {
+	return &Workspace{&ciel.Ciel{BasePath: dir}}
+}
+
+// Open returns the workspace in dir. If it needs migrating, the workspace
+// is returned along with ErrOlderVersion.
+func Open(dir string) (*Workspace, error) {
+	w := New(dir)
+	return w, w.Check()
+}
+
+// Init creates a workspace in dir.
+func Init(dir string) (*Workspace, error) {
+	w := New(dir)
+	if err := w.ciel.Init(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Workspace) Dir() string { return w.ciel.BasePath }
+
+// Check tells whether the workspace is usable, it is ErrOlderVersion if it
+// needs migrating.
+func (w *Workspace) Check() error {
+	return apiError(w.ciel.Check())
+}
+
+func (w *Workspace) PendingMigrations() ([]Migration, error) {
+	list, err := w.ciel.PendingMigrations()
+	if err != nil {
+		return nil, apiError(err)
+	}
+	return newMigrations(list), nil
+}
+
+// CheckMigration tells whether a migration can be applied now, without
+// changing anything.
+func (w *Workspace) CheckMigration(m Migration) error {
+	return m.m.Check(w.ciel)
+}
+
+// Migrate applies a migration, returning the directory of the backup.
+func (w *Workspace) Migrate(m Migration) (string, error) {
+	return w.ciel.Migrate(m.m)
+}
+
+// Instances returns all instances of the workspace.
+func (w *Workspace) Instances() ([]*Instance, error) {
+	list, err := w.ciel.Container().GetAll()
+	if err != nil {
+		return nil, err
+	}
+	var instList []*Instance
+	for _, inst := range list {
+		instList = append(instList, &Instance{w, inst})
+	}
+	return instList, nil
+}
+
+// Instance returns an existing instance.
+func (w *Workspace) Instance(name string) (*Instance, error) {
+	c := w.ciel.Container()
+	if err := c.CheckInst(name); err != nil {
+		return nil, apiError(err)
+	}
+	return &Instance{w, c.Instance(name)}, nil
+}
+
+// AddInstance creates an instance, it is not mounted.
+func (w *Workspace) AddInstance(name string) (*Instance, error) {
+	c := w.ciel.Container()
+	if name == "" {
+		return nil, ErrNoInstName
+	}
+	if c.InstExists(name) {
+		return nil, ErrInstExists
+	}
+	if err := c.AddInst(name); err != nil {
+		return nil, err
+	}
+	return &Instance{w, c.Instance(name)}, nil
+}
+
+// CloneInstance creates an instance with the local layer, holding the
+// configuration made by 'ciel config', and the settings of another one,
+// except forwarded ports which cannot be shared.
+func (w *Workspace) CloneInstance(from *Instance, name string) (*Instance, error) {
+	inst, err := w.AddInstance(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := copyLocalLayer(from.inst, inst.inst); err != nil {
+		return inst, err
+	}
+	config, err := from.inst.Config()
+	if err != nil {
+		return inst, err
+	}
+	config.Ports = nil
+	return inst, inst.inst.SaveConfig(config)
+}
+
+// RemoveInstance stops and unmounts an instance, then deletes it.
+func (w *Workspace) RemoveInstance(name string) error {
+	inst, err := w.Instance(name)
+	if err != nil {
+		return err
+	}
+	if err := inst.Unmount(); err != nil {
+		return err
+	}
+	return w.ciel.Container().DelInst(name)
+}
+
+// Package is a package in the tree.
+type Package struct {
+	Name  string   // name used to request the build
+	Dir   string   // directory in the tree
+	Names []string // package names it produces
+	Deps  []string
+}
+
+// DependsOn reports whether pkg needs any package produced by other.
+func (pkg *Package) DependsOn(other *Package) bool {
+	a, b := pkgtree.Package(*pkg), pkgtree.Package(*other)
+	return a.DependsOn(&b)
+}
+
+func newPackages(list []*pkgtree.Package) []*Package {
+	var packages []*Package
+	for _, pkg := range list {
+		p := Package(*pkg)
+		packages = append(packages, &p)
+	}
+	return packages
+}
+
+// QueuePlan is the order to build requested packages in.
+type QueuePlan struct {
+	Ordered []*Package // in dependency order
+	Cycle   []*Package // in a dependency cycle, in requested order
+	Blocked []*Package // depending on the cycle, in dependency order
+}
+
+// Queue returns all packages of the plan, in the order to build them.
+func (p *QueuePlan) Queue() []*Package {
+	var queue []*Package
+	queue = append(queue, p.Ordered...)
+	queue = append(queue, p.Cycle...)
+	return append(queue, p.Blocked...)
+}
+
+// PlanQueue looks up the requested packages and groups in the tree, and
+// sorts them in dependency order.
+func (w *Workspace) PlanQueue(requests []string) (*QueuePlan, error) {
+	tree := w.ciel.Tree()
+	names, err := tree.Expand(requests)
+	if err != nil {
+		return nil, err
+	}
+	var packages []*pkgtree.Package
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		pkg, err := tree.Find(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		packages = append(packages, pkg)
+	}
+	ordered, cycle, blocked := pkgtree.Order(packages)
+	return &QueuePlan{
+		Ordered: newPackages(ordered),
+		Cycle:   newPackages(cycle),
+		Blocked: newPackages(blocked),
+	}, nil
+}
@@ -0,0 +1,52 @@
+package ciel
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/AOSC-Dev/ciel/internal/ciel"
+	"github.com/AOSC-Dev/ciel/internal/container"
+	"github.com/AOSC-Dev/ciel/internal/pkgtree"
+)
+
+func TestAPIError(t *testing.T) {
+	pending := []ciel.Migration{{From: 2, Summary: "two"}, {From: 3, Summary: "three"}}
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{nil, nil},
+		{ErrBusy, ErrBusy},
+		{ciel.ErrNewerVersion{Version: 9}, ErrNewerVersion{Version: 9}},
+		{container.ErrNoSuchInstance{Name: "a"}, ErrNoSuchInstance{Name: "a"}},
+	}
+	for _, test := range tests {
+		if got := apiError(test.err); got != test.want {
+			t.Errorf("apiError(%#v) = %#v, want %#v", test.err, got, test.want)
+		}
+	}
+
+	older, ok := apiError(ciel.ErrOlderVersion{Pending: pending}).(ErrOlderVersion)
+	if !ok || len(older.Pending) != 2 {
+		t.Fatalf("older version converted to %#v", older)
+	}
+	for index, m := range older.Pending {
+		if m.From != pending[index].From || m.Summary != pending[index].Summary || m.String() != pending[index].String() {
+			t.Errorf("migration %d converted to %#v", index, m)
+		}
+	}
+}
+
+func TestPackageDependsOn(t *testing.T) {
+	list := newPackages([]*pkgtree.Package{
+		{Name: "a", Names: []string{"a", "a-dev"}},
+		{Name: "b", Names: []string{"b"}, Deps: []string{"a-dev"}},
+	})
+	a, b := list[0], list[1]
+	if !reflect.DeepEqual(*b, Package{Name: "b", Names: []string{"b"}, Deps: []string{"a-dev"}}) {
+		t.Errorf("package converted to %#v", *b)
+	}
+	if !b.DependsOn(a) || a.DependsOn(b) {
+		t.Error("wrong dependency between a and b")
+	}
+}
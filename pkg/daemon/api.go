@@ -0,0 +1,122 @@
+// Package daemon serves a workspace over a Unix socket, with a JSON API on
+// HTTP, and provides its client.
+//
+//	GET  /v1/instances    []InstanceStatus
+//	POST /v1/run          RunRequest, streams Frames as JSON lines
+//	GET  /v1/builds       []Build
+//	POST /v1/builds       BuildRequest, returns the queued Build
+//	GET  /v1/builds/ID    Build, with the report once finished
+//	GET  /v1/events       streams Events as JSON lines
+//
+// Errors are returned as Error with a 4xx or 5xx status.
+//
+// Commands and builds run as root in the instances, with directories of the
+// host bind mounted, so members of the group of the server are as powerful
+// as root: only trusted users should be members. Besides root, clients may
+// only run instances whose network mode, asked for or configured, is none,
+// and may not override their limits. Subscribers of events not keeping up
+// are disconnected, and should poll their builds then. Finished builds are
+// forgotten beyond the last KeepBuilds of them.
+package daemon
+
+import (
+	"time"
+
+	"github.com/AOSC-Dev/ciel/pkg/ciel"
+)
+
+const (
+	APIPrefix = "/v1"
+
+	// DefaultSocketName is relative to the workspace.
+	DefaultSocketName = ".ciel/daemon.sock"
+)
+
+// InstanceStatus is the state of an instance, without the sizes of its
+// layers.
+type InstanceStatus = ciel.Status
+
+// RunRequest runs a command line in the login shell of root, or a command
+// with its arguments, in an instance.
+type RunRequest struct {
+	Instance string      `json:"instance"`
+	Command  string      `json:"command,omitempty"`
+	Args     []string    `json:"args,omitempty"`
+	Network  string      `json:"network,omitempty"`
+	Limits   ciel.Limits `json:"limits"`
+	NoBoot   bool        `json:"no_boot,omitempty"`
+}
+
+// Frame is a chunk of output of a run, or the end of it with the exit
+// status.
+type Frame struct {
+	Stream     string `json:"stream,omitempty"` // "stdout" or "stderr"
+	Data       string `json:"data,omitempty"`
+	Exited     bool   `json:"exited,omitempty"`
+	ExitStatus int    `json:"exit_status,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// BuildRequest queues a build, unset options take the defaults from the
+// configuration of the workspace.
+type BuildRequest struct {
+	Instance   string      `json:"instance"`
+	Packages   []string    `json:"packages"`
+	Suites     []string    `json:"suites,omitempty"`
+	Network    string      `json:"network,omitempty"`
+	Limits     ciel.Limits `json:"limits"`
+	NoBoot     bool        `json:"no_boot,omitempty"`
+	Clean      *bool       `json:"clean,omitempty"`
+	CleanAfter *bool       `json:"clean_after,omitempty"`
+	Baseline   *string     `json:"baseline,omitempty"`
+	Hermetic   *bool       `json:"hermetic,omitempty"`
+}
+
+// Statuses of builds.
+const (
+	BuildQueued    = "queued"
+	BuildRunning   = "running"
+	BuildSucceeded = "succeeded"
+	BuildFailed    = "failed"
+)
+
+type Build struct {
+	ID         int          `json:"id"`
+	Request    BuildRequest `json:"request"`
+	UID        uint32       `json:"uid"` // of the client queuing it
+	Status     string       `json:"status"`
+	QueuedTime time.Time    `json:"queued_time"`
+	Report     *ciel.Report `json:"report,omitempty"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// Types of events.
+const (
+	EventBuildQueued   = "build.queued"
+	EventBuildStarted  = "build.started"
+	EventBuildStep     = "build.step"
+	EventBuildFinished = "build.finished"
+	EventRunStarted    = "run.started"
+	EventRunFinished   = "run.finished"
+)
+
+type Event struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Instance string    `json:"instance,omitempty"`
+	Build    int       `json:"build,omitempty"`
+	UID      uint32    `json:"uid"`
+	// Step, Detail and Error are for steps of builds, and the ends of runs
+	// and builds.
+	Step   string `json:"step,omitempty"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Error struct {
+	Message string `json:"error"`
+}
+
+func (e Error) Error() string {
+	return e.Message
+}
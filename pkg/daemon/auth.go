@@ -0,0 +1,87 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+var ErrNoCredentials = errors.New("no credentials of the peer")
+
+type credKey struct{}
+
+// peerCred returns the credentials of the process at the other end of a
+// Unix socket.
+func peerCred(c net.Conn) (*syscall.Ucred, error) {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cred, credErr
+}
+
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	cred, err := peerCred(c)
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, credKey{}, cred)
+}
+
+func credOf(ctx context.Context) *syscall.Ucred {
+	cred, _ := ctx.Value(credKey{}).(*syscall.Ucred)
+	return cred
+}
+
+// privileged tells whether the peer is root or the user running the
+// daemon.
+func privileged(cred *syscall.Ucred) bool {
+	return cred != nil && (cred.Uid == 0 || int(cred.Uid) == os.Geteuid())
+}
+
+// authorized tells whether the peer may use the daemon: root, the user
+// running the daemon, and members of the group of the server.
+func (s *Server) authorized(cred *syscall.Ucred) bool {
+	if cred == nil {
+		return false
+	}
+	if privileged(cred) {
+		return true
+	}
+	if s.GID < 0 {
+		return false
+	}
+	if int(cred.Gid) == s.GID {
+		return true
+	}
+	u, err := user.LookupId(strconv.Itoa(int(cred.Uid)))
+	if err != nil {
+		return false
+	}
+	groups, err := u.GroupIds()
+	if err != nil {
+		return false
+	}
+	for _, group := range groups {
+		if group == strconv.Itoa(s.GID) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// Client talks to a daemon over its socket.
+type Client struct {
+	http *http.Client
+}
+
+func NewClient(socket string) *Client {
+	var dialer net.Dialer
+	return &Client{http: &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		},
+	}}
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, in interface{}) (*http.Response, error) {
+	var body io.Reader
+	if in != nil {
+		buf, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequest(method, "http://ciel"+APIPrefix+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		var e Error
+		if json.NewDecoder(resp.Body).Decode(&e) != nil || e.Message == "" {
+			e.Message = resp.Status
+		}
+		return nil, e
+	}
+	return resp, nil
+}
+
+func (c *Client) call(method string, path string, in interface{}, out interface{}) error {
+	resp, err := c.do(context.Background(), method, path, in)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) Instances() ([]InstanceStatus, error) {
+	var list []InstanceStatus
+	err := c.call(http.MethodGet, "/instances", nil, &list)
+	return list, err
+}
+
+// Run runs a command and copies its output to the writers, returning the
+// exit status of the command.
+func (c *Client) Run(ctx context.Context, req RunRequest, stdout io.Writer, stderr io.Writer) (int, error) {
+	resp, err := c.do(ctx, http.MethodPost, "/run", req)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var f Frame
+		if err := dec.Decode(&f); err != nil {
+			if err == io.EOF {
+				err = errors.New("connection to the daemon closed during the run")
+			}
+			return -1, err
+		}
+		switch {
+		case f.Exited:
+			if f.Error != "" {
+				return f.ExitStatus, errors.New(f.Error)
+			}
+			return f.ExitStatus, nil
+		case f.Stream == "stderr":
+			io.WriteString(stderr, f.Data)
+		default:
+			io.WriteString(stdout, f.Data)
+		}
+	}
+}
+
+func (c *Client) QueueBuild(req BuildRequest) (*Build, error) {
+	var b Build
+	if err := c.call(http.MethodPost, "/builds", req, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (c *Client) Builds() ([]Build, error) {
+	var builds []Build
+	err := c.call(http.MethodGet, "/builds", nil, &builds)
+	return builds, err
+}
+
+func (c *Client) Build(id int) (*Build, error) {
+	var b Build
+	if err := c.call(http.MethodGet, "/builds/"+strconv.Itoa(id), nil, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// Events calls fn with each event until it returns false or ctx is done.
+// The subscription is in place once Events calls ready, if not nil.
+func (c *Client) Events(ctx context.Context, ready func(), fn func(Event) bool) error {
+	resp, err := c.do(ctx, http.MethodGet, "/events", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if ready != nil {
+		ready()
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return err
+		}
+		if !fn(e) {
+			return nil
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,571 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/AOSC-Dev/ciel/pkg/ciel"
+	"github.com/AOSC-Dev/ciel/systemd-api/nspawn"
+)
+
+const (
+	// QueueSize is the number of builds which may wait for each instance.
+	QueueSize = 64
+	// DefaultWait is how long a build or a run waits for an instance held
+	// by another process.
+	DefaultWait = time.Hour
+	// KeepBuilds is the number of finished builds remembered, older ones
+	// are forgotten.
+	KeepBuilds = 256
+)
+
+var (
+	ErrQueueFull     = errors.New("build queue of the instance is full")
+	ErrNoSuchBuild   = errors.New("no such build")
+	ErrNetworkDenied = errors.New("only root may run instances with a network mode other than none")
+	ErrLimitsDenied  = errors.New("only root may override the limits of instances")
+)
+
+// Server serves a workspace. Builds are queued for each instance, builds in
+// different instances run at once.
+type Server struct {
+	Workspace *ciel.Workspace
+	// GID is the group allowed to use the daemon besides root, -1 for none.
+	GID int
+	// Wait is how long a build or a run waits for an instance held by
+	// another process, such as a build started with ciel build.
+	Wait time.Duration
+
+	events broker
+
+	mutex    sync.Mutex
+	builds   []*Build // in order of IDs
+	lastID   int
+	finished int // builds finished and kept
+	queues   map[string]chan *Build
+}
+
+func NewServer(w *ciel.Workspace, gid int) *Server {
+	return &Server{
+		Workspace: w,
+		GID:       gid,
+		Wait:      DefaultWait,
+		events:    broker{subs: make(map[chan Event]bool)},
+		queues:    make(map[string]chan *Build),
+	}
+}
+
+// Listen creates the socket, replacing a stale one, which the group may
+// connect to.
+func Listen(socket string, gid int) (net.Listener, error) {
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	mode := os.FileMode(0600)
+	if gid >= 0 {
+		mode = 0660
+		if err := os.Chown(socket, -1, gid); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	if err := os.Chmod(socket, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// HTTPServer returns the HTTP server to serve on a listener from Listen.
+func (s *Server) HTTPServer() *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(APIPrefix+"/instances", s.handleInstances)
+	mux.HandleFunc(APIPrefix+"/run", s.handleRun)
+	mux.HandleFunc(APIPrefix+"/builds", s.handleBuilds)
+	mux.HandleFunc(APIPrefix+"/builds/", s.handleBuild)
+	mux.HandleFunc(APIPrefix+"/events", s.handleEvents)
+	return &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !s.authorized(credOf(r.Context())) {
+				writeError(w, http.StatusForbidden, errors.New("permission denied"))
+				return
+			}
+			mux.ServeHTTP(w, r)
+		}),
+		ConnContext: connContext,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, Error{err.Error()})
+}
+
+// statusOf maps errors of the workspace to HTTP statuses.
+func statusOf(err error) int {
+	switch err.(type) {
+	case ciel.ErrNoSuchInstance:
+		return http.StatusNotFound
+	}
+	switch err {
+	case ciel.ErrNoInstName, ciel.ErrInvalidBaseline, ciel.ErrInvalidSuite, nspawn.ErrInvalidNetwork:
+		return http.StatusBadRequest
+	case ErrNetworkDenied, ErrLimitsDenied:
+		return http.StatusForbidden
+	case ErrNoSuchBuild:
+		return http.StatusNotFound
+	case ciel.ErrBusy, ErrQueueFull:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	return false
+}
+
+func (s *Server) handleInstances(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	list, err := s.Workspace.Instances()
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	statuses := []InstanceStatus{}
+	for _, inst := range list {
+		statuses = append(statuses, inst.Status(ciel.StatusOptions{}))
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// frameWriter sends output of a run as frames of a stream.
+type frameWriter struct {
+	mutex   *sync.Mutex
+	enc     *json.Encoder
+	flusher http.Flusher
+	stream  string
+}
+
+func (fw *frameWriter) Write(p []byte) (int, error) {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+	if err := fw.enc.Encode(Frame{Stream: fw.stream, Data: string(p)}); err != nil {
+		return 0, err
+	}
+	if fw.flusher != nil {
+		fw.flusher.Flush()
+	}
+	return len(p), nil
+}
+
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req RunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Command == "" && len(req.Args) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("no command to run"))
+		return
+	}
+	inst, err := s.Workspace.Instance(req.Instance)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	cred := credOf(r.Context())
+	if err := checkNetwork(cred, inst, req.Network); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	if err := checkLimits(cred, req.Limits); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	// not while a build or a rollback holds the instance
+	if err := inst.Lock(s.Wait); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	defer inst.Unlock()
+	uid := cred.Uid
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	var mutex sync.Mutex
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	s.events.publish(Event{Type: EventRunStarted, Instance: inst.Name(), UID: uid, Detail: runLine(req)})
+	exitStatus, err := inst.Run(r.Context(), ciel.RunOptions{
+		Command: req.Command,
+		Args:    req.Args,
+		Network: req.Network,
+		Limits:  req.Limits,
+		NoBoot:  req.NoBoot,
+		Stdin:   strings.NewReader(""),
+		Stdout:  &frameWriter{&mutex, enc, flusher, "stdout"},
+		Stderr:  &frameWriter{&mutex, enc, flusher, "stderr"},
+	})
+	end := Frame{Exited: true, ExitStatus: exitStatus}
+	if err != nil {
+		end.Error = err.Error()
+	}
+	mutex.Lock()
+	enc.Encode(end)
+	mutex.Unlock()
+	s.events.publish(Event{
+		Type:     EventRunFinished,
+		Instance: inst.Name(),
+		UID:      uid,
+		Detail:   "exit status " + strconv.Itoa(exitStatus),
+		Error:    end.Error,
+	})
+}
+
+func runLine(req RunRequest) string {
+	if req.Command != "" {
+		return req.Command
+	}
+	return strings.Join(req.Args, " ")
+}
+
+func (s *Server) handleBuilds(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		s.mutex.Lock()
+		builds := []Build{}
+		for _, b := range s.builds {
+			builds = append(builds, *b)
+		}
+		s.mutex.Unlock()
+		writeJSON(w, http.StatusOK, builds)
+		return
+	}
+
+	var req BuildRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(req.Packages) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("no packages to build"))
+		return
+	}
+	for _, suite := range req.Suites {
+		if err := ciel.CheckSuite(suite); err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+	}
+	if req.Baseline != nil && *req.Baseline != "" {
+		if err := ciel.CheckBaseline(*req.Baseline); err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+	}
+	inst, err := s.Workspace.Instance(req.Instance)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	cred := credOf(r.Context())
+	if err := checkNetwork(cred, inst, req.Network); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	if err := checkLimits(cred, req.Limits); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	b, err := s.enqueue(req, cred.Uid)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, b)
+}
+
+func (s *Server) handleBuild(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, APIPrefix+"/builds/"))
+	if err != nil {
+		writeError(w, statusOf(ErrNoSuchBuild), ErrNoSuchBuild)
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, b := range s.builds {
+		if b.ID == id {
+			writeJSON(w, http.StatusOK, b)
+			return
+		}
+	}
+	writeError(w, statusOf(ErrNoSuchBuild), ErrNoSuchBuild)
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	ch := s.events.subscribe()
+	defer s.events.unsubscribe(ch)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// enqueue queues a build for its instance, starting the worker of the
+// instance if needed.
+func (s *Server) enqueue(req BuildRequest, uid uint32) (Build, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	q, ok := s.queues[req.Instance]
+	if !ok {
+		q = make(chan *Build, QueueSize)
+		s.queues[req.Instance] = q
+		go s.worker(q)
+	}
+	b := &Build{
+		ID:         s.lastID + 1,
+		Request:    req,
+		UID:        uid,
+		Status:     BuildQueued,
+		QueuedTime: time.Now(),
+	}
+	select {
+	case q <- b:
+	default:
+		return Build{}, ErrQueueFull
+	}
+	s.lastID = b.ID
+	s.builds = append(s.builds, b)
+	s.events.publish(Event{Type: EventBuildQueued, Instance: req.Instance, Build: b.ID, UID: uid,
+		Detail: strings.Join(req.Packages, " ")})
+	return *b, nil
+}
+
+func (s *Server) worker(q chan *Build) {
+	for b := range q {
+		s.setStatus(b, BuildRunning, nil, nil)
+		s.events.publish(Event{Type: EventBuildStarted, Instance: b.Request.Instance, Build: b.ID, UID: b.UID})
+		report, err := s.build(b)
+		status := BuildSucceeded
+		if err != nil || report.ExitStatus != 0 {
+			status = BuildFailed
+		}
+		if err == nil && report.Error != "" {
+			err = errors.New(report.Error)
+		}
+		s.setStatus(b, status, report, err)
+		e := Event{Type: EventBuildFinished, Instance: b.Request.Instance, Build: b.ID, UID: b.UID, Detail: status}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		s.events.publish(e)
+	}
+}
+
+func (s *Server) setStatus(b *Build, status string, report *ciel.Report, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b.Status = status
+	b.Report = report
+	if err != nil {
+		b.Error = err.Error()
+	}
+	if status == BuildSucceeded || status == BuildFailed {
+		s.finished++
+		s.forget()
+	}
+}
+
+// forget drops the oldest finished builds beyond KeepBuilds, builds queued
+// or running are kept.
+func (s *Server) forget() {
+	if s.finished <= KeepBuilds {
+		return
+	}
+	kept := s.builds[:0]
+	for _, b := range s.builds {
+		if s.finished > KeepBuilds && (b.Status == BuildSucceeded || b.Status == BuildFailed) {
+			s.finished--
+			continue
+		}
+		kept = append(kept, b)
+	}
+	for index := len(kept); index < len(s.builds); index++ {
+		s.builds[index] = nil
+	}
+	s.builds = kept
+}
+
+// networkInstance is what checkNetwork needs of an instance.
+type networkInstance interface {
+	Network(mode string) (string, error)
+}
+
+// checkNetwork lets root run instances with any network mode, and members
+// of the group only without network, whether the mode is asked for or comes
+// from the configuration of the instance or the workspace.
+func checkNetwork(cred *syscall.Ucred, inst networkInstance, network string) error {
+	if privileged(cred) {
+		return nil
+	}
+	mode, err := inst.Network(network)
+	if err != nil {
+		return err
+	}
+	if mode != nspawn.NetworkNone {
+		return ErrNetworkDenied
+	}
+	return nil
+}
+
+// checkLimits lets only root override the limits of instances.
+func checkLimits(cred *syscall.Ucred, limits ciel.Limits) error {
+	if limits.Empty() || privileged(cred) {
+		return nil
+	}
+	return ErrLimitsDenied
+}
+
+func (s *Server) build(b *Build) (*ciel.Report, error) {
+	req := b.Request
+	inst, err := s.Workspace.Instance(req.Instance)
+	if err != nil {
+		return nil, err
+	}
+	builder, err := s.Workspace.NewBuilder(inst)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Suites) != 0 {
+		builder.Suites = req.Suites
+	}
+	builder.Network = req.Network
+	builder.Limits = req.Limits
+	builder.NoBoot = req.NoBoot
+	builder.Wait = s.Wait
+	if req.Clean != nil {
+		builder.Clean = *req.Clean
+	}
+	if req.CleanAfter != nil {
+		builder.CleanAfter = *req.CleanAfter
+	}
+	if req.Baseline != nil {
+		builder.Baseline = *req.Baseline
+	}
+	if req.Hermetic != nil {
+		builder.Hermetic = *req.Hermetic
+	}
+	builder.Notify = func(step string, err error, detail string) {
+		e := Event{Type: EventBuildStep, Instance: req.Instance, Build: b.ID, UID: b.UID, Step: step, Detail: detail}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		s.events.publish(e)
+	}
+
+	if err := builder.Lock(); err != nil {
+		return nil, err
+	}
+	defer builder.Unlock()
+	if err := builder.Setup(); err != nil {
+		return nil, err
+	}
+	defer builder.Teardown()
+	report := builder.Build(context.Background(), req.Packages)
+	if report.Log != "" {
+		builder.SaveReport(report, "")
+	}
+	return report, nil
+}
+
+// broker delivers events to subscribers. The ones not keeping up are
+// closed rather than missing events, such as the end of a build, unaware.
+type broker struct {
+	mutex sync.Mutex
+	subs  map[chan Event]bool
+}
+
+func (b *broker) subscribe() chan Event {
+	ch := make(chan Event, 64)
+	b.mutex.Lock()
+	b.subs[ch] = true
+	b.mutex.Unlock()
+	return ch
+}
+
+func (b *broker) unsubscribe(ch chan Event) {
+	b.mutex.Lock()
+	delete(b.subs, ch)
+	b.mutex.Unlock()
+}
+
+func (b *broker) publish(e Event) {
+	e.Time = time.Now()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
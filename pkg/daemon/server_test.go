@@ -0,0 +1,114 @@
+package daemon
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/AOSC-Dev/ciel/pkg/ciel"
+)
+
+// configuredInstance is an instance whose network mode is configured.
+type configuredInstance string
+
+func (i configuredInstance) Network(mode string) (string, error) {
+	if mode == "" {
+		return string(i), nil
+	}
+	return mode, nil
+}
+
+func member() *syscall.Ucred {
+	cred := &syscall.Ucred{Uid: uint32(os.Geteuid()) + 1}
+	if cred.Uid == 0 {
+		cred.Uid = 1
+	}
+	return cred
+}
+
+func TestCheckNetwork(t *testing.T) {
+	root := &syscall.Ucred{Uid: 0}
+	member := member()
+	tests := []struct {
+		cred    *syscall.Ucred
+		inst    configuredInstance
+		network string
+		want    error
+	}{
+		{root, "none", "", nil},
+		{root, "none", "host", nil},
+		{root, "host", "", nil},
+		{member, "none", "", nil},
+		{member, "none", "none", nil},
+		{member, "none", "host", ErrNetworkDenied},
+		{member, "none", "veth", ErrNetworkDenied},
+		{member, "host", "", ErrNetworkDenied},
+		{member, "host", "none", nil},
+	}
+	for _, test := range tests {
+		if got := checkNetwork(test.cred, test.inst, test.network); got != test.want {
+			t.Errorf("checkNetwork(uid %d, instance with %s, %q) = %v, want %v",
+				test.cred.Uid, test.inst, test.network, got, test.want)
+		}
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	root := &syscall.Ucred{Uid: 0}
+	if err := checkLimits(root, ciel.Limits{Memory: "1G"}); err != nil {
+		t.Errorf("limits of root denied: %v", err)
+	}
+	if err := checkLimits(member(), ciel.Limits{}); err != nil {
+		t.Errorf("no limits denied: %v", err)
+	}
+	if err := checkLimits(member(), ciel.Limits{Memory: "1G"}); err != ErrLimitsDenied {
+		t.Errorf("limits of a member: %v, want %v", err, ErrLimitsDenied)
+	}
+}
+
+func TestBrokerSlowSubscriber(t *testing.T) {
+	b := broker{subs: make(map[chan Event]bool)}
+	slow := b.subscribe()
+	defer b.unsubscribe(slow)
+	for i := 0; i <= cap(slow); i++ {
+		b.publish(Event{Type: EventBuildStep, Build: 1})
+	}
+	b.publish(Event{Type: EventBuildFinished, Build: 1})
+	n := 0
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-slow:
+			if !ok {
+				if n != cap(slow) {
+					t.Errorf("%d events before closing, want %d", n, cap(slow))
+				}
+				return
+			}
+			n++
+		case <-timeout:
+			t.Fatal("slow subscriber neither fed nor closed")
+		}
+	}
+}
+
+func TestForget(t *testing.T) {
+	s := NewServer(nil, -1)
+	running := &Build{Status: BuildRunning}
+	s.builds = []*Build{running}
+	for id := 1; id <= KeepBuilds+2; id++ {
+		b := &Build{ID: id, Status: BuildQueued}
+		s.builds = append(s.builds, b)
+		s.setStatus(b, BuildSucceeded, nil, nil)
+	}
+	if len(s.builds) != KeepBuilds+1 || s.finished != KeepBuilds {
+		t.Fatalf("%d builds kept, %d finished, want %d", len(s.builds), s.finished, KeepBuilds)
+	}
+	if s.builds[0] != running {
+		t.Errorf("running build forgotten")
+	}
+	if s.builds[1].ID != 3 {
+		t.Errorf("oldest finished build kept is %d, want 3", s.builds[1].ID)
+	}
+}
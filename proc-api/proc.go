@@ -3,7 +3,6 @@ package proc
 import (
 	"bytes"
 	"io/ioutil"
-	"log"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -32,8 +31,13 @@ func GetCommandLineByPID(pid uint32) ([]string, error) {
 	return strings.Split(string(b), string('\x00')), nil
 }
 
+// Mounted reports whether target is a mount point, it is false if the mount
+// table cannot be read.
 func Mounted(target string) bool {
 	a, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return false
+	}
 	s := string(a)
 	list := strings.Split(s, "\n")
 	absPath, _ := filepath.Abs(target)
@@ -47,8 +51,51 @@ func Mounted(target string) bool {
 			return true
 		}
 	}
+	return false
+}
+
+// OutNoRoutes returns the number of IPv4 and IPv6 packets the network
+// namespace of the process failed to send for lack of a route, which is
+// how connection attempts fail without network.
+func OutNoRoutes(pid uint32) (uint64, error) {
+	b, err := ioutil.ReadFile(procPath(pid) + "/net/snmp")
 	if err != nil {
-		log.Panicln(err)
+		return 0, err
 	}
-	return false
+	var count uint64
+	var header []string
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "Ip:" {
+			continue
+		}
+		if header == nil {
+			header = fields
+			continue
+		}
+		for index, name := range header {
+			if name == "OutNoRoutes" && index < len(fields) {
+				count, err = strconv.ParseUint(fields[index], 10, 64)
+				if err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+	b, err = ioutil.ReadFile(procPath(pid) + "/net/snmp6")
+	if err != nil {
+		// IPv6 may be disabled
+		return count, nil
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "Ip6OutNoRoutes" {
+			n, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			count += n
+		}
+	}
+	return count, nil
 }
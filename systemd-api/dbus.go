@@ -1,28 +1,40 @@
 package systemd
 
 import (
-	"log"
+	"fmt"
 	"os"
 	"strconv"
+	"sync"
 
 	"github.com/godbus/dbus/v5"
 )
 
-var Conn *dbus.Conn
+var (
+	conn      *dbus.Conn
+	connMutex sync.Mutex
+)
 
-func init() {
-	conn, err := dbus.SystemBusPrivate()
+// Conn returns the connection to the system bus, connecting on first use.
+// A failed attempt is retried on the next call.
+func Conn() (*dbus.Conn, error) {
+	connMutex.Lock()
+	defer connMutex.Unlock()
+	if conn != nil {
+		return conn, nil
+	}
+	c, err := dbus.SystemBusPrivate()
 	if err != nil {
-		log.Panicln(err)
+		return nil, fmt.Errorf("system bus: %w", err)
 	}
 	authMethods := []dbus.Auth{dbus.AuthExternal(strconv.Itoa(os.Getuid()))}
-	err = conn.Auth(authMethods)
-	if err != nil {
-		log.Panicln(err)
+	if err := c.Auth(authMethods); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("system bus: %w", err)
 	}
-	err = conn.Hello()
-	if err != nil {
-		log.Panicln(err)
+	if err := c.Hello(); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("system bus: %w", err)
 	}
-	Conn = conn
+	conn = c
+	return conn, nil
 }
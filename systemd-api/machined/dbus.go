@@ -7,6 +7,10 @@ import (
 
 const Dest = "org.freedesktop.machine1"
 
-func Object(path dbus.ObjectPath) dbus.BusObject {
-	return systemd.Conn.Object(Dest, path)
+func Object(path dbus.ObjectPath) (dbus.BusObject, error) {
+	conn, err := systemd.Conn()
+	if err != nil {
+		return nil, err
+	}
+	return conn.Object(Dest, path), nil
 }
@@ -10,6 +10,22 @@ type Machine struct {
 
 const MachineInterface = "org.freedesktop.machine1.Machine"
 
+// States of machines.
+const (
+	StateOpening = "opening"
+	StateRunning = "running"
+	StateClosing = "closing"
+)
+
+// NewMachine returns the machine at the object path, as in MachineInfo.
+func NewMachine(path dbus.ObjectPath) (*Machine, error) {
+	obj, err := Object(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Machine{obj}, nil
+}
+
 func (m Machine) Leader() (uint32, error) {
 	v, err := m.GetProperty(".Leader")
 	if err != nil {
@@ -18,6 +34,39 @@ func (m Machine) Leader() (uint32, error) {
 	return v.(uint32), err
 }
 
+func (m Machine) Name() (string, error) {
+	return m.stringProperty(".Name")
+}
+
+// State is one of StateOpening, StateRunning and StateClosing.
+func (m Machine) State() (string, error) {
+	return m.stringProperty(".State")
+}
+
+// Class is either "container" or "vm".
+func (m Machine) Class() (string, error) {
+	return m.stringProperty(".Class")
+}
+
+func (m Machine) RootDirectory() (string, error) {
+	return m.stringProperty(".RootDirectory")
+}
+
+// Unit is the scope unit of the machine.
+func (m Machine) Unit() (string, error) {
+	return m.stringProperty(".Unit")
+}
+
+// Terminate kills all processes of the machine and unregisters it.
+func (m Machine) Terminate() error {
+	return m.Obj.Call(MachineInterface+".Terminate", 0).Err
+}
+
+// Kill sends a signal to the leader or all processes of the machine.
+func (m Machine) Kill(who string, signal int32) error {
+	return m.Obj.Call(MachineInterface+".Kill", 0, who, signal).Err
+}
+
 func (m Machine) GetProperty(name string) (value interface{}, err error) {
 	v, err := m.Obj.GetProperty(MachineInterface + name)
 	if err != nil {
@@ -25,3 +74,12 @@ func (m Machine) GetProperty(name string) (value interface{}, err error) {
 	}
 	return v.Value(), err
 }
+
+func (m Machine) stringProperty(name string) (string, error) {
+	v, err := m.GetProperty(name)
+	if err != nil {
+		return "", err
+	}
+	s, _ := v.(string)
+	return s, nil
+}
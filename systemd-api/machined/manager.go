@@ -1,6 +1,9 @@
 package machined
 
 import (
+	"os"
+	"syscall"
+
 	"github.com/godbus/dbus/v5"
 )
 
@@ -12,14 +15,79 @@ type Manager struct {
 
 const ManagerInterface = "org.freedesktop.machine1.Manager"
 
+// ErrNoSuchMachine is the name of the D-Bus error for unknown machines.
+const ErrNoSuchMachine = "org.freedesktop.machine1.NoSuchMachine"
+
+// Who to send signals to, in KillMachine.
+const (
+	KillLeader = "leader"
+	KillAll    = "all"
+)
+
+// MachineInfo is an entry of ListMachines.
+type MachineInfo struct {
+	Name    string
+	Class   string
+	Service string
+	Path    dbus.ObjectPath
+}
+
 func (m Manager) GetMachine(name string) (machine *Machine, err error) {
 	result := m.Obj.Call(ManagerInterface+".GetMachine", 0, name)
 	if result.Err != nil {
 		return nil, result.Err
 	}
-	return &Machine{Object(result.Body[0].(dbus.ObjectPath))}, nil
+	return NewMachine(result.Body[0].(dbus.ObjectPath))
 }
 
-func NewManager() *Manager {
-	return &Manager{Object(ManagerPath)}
+// ListMachines returns all machines registered.
+func (m Manager) ListMachines() ([]MachineInfo, error) {
+	var list []MachineInfo
+	err := m.Obj.Call(ManagerInterface+".ListMachines", 0).Store(&list)
+	return list, err
+}
+
+// TerminateMachine kills all processes of the machine and unregisters it.
+func (m Manager) TerminateMachine(name string) error {
+	return m.Obj.Call(ManagerInterface+".TerminateMachine", 0, name).Err
+}
+
+// KillMachine sends a signal to the leader or all processes of the machine.
+func (m Manager) KillMachine(name string, who string, signal int32) error {
+	return m.Obj.Call(ManagerInterface+".KillMachine", 0, name, who, signal).Err
+}
+
+// OpenMachinePTY allocates a pseudo terminal in the machine. The master is
+// returned non-blocking, with the path of the slave in the machine.
+func (m Manager) OpenMachinePTY(name string) (master *os.File, slave string, err error) {
+	var fd dbus.UnixFD
+	err = m.Obj.Call(ManagerInterface+".OpenMachinePTY", 0, name).Store(&fd, &slave)
+	if err != nil {
+		return nil, "", err
+	}
+	syscall.CloseOnExec(int(fd))
+	if err := syscall.SetNonblock(int(fd), true); err != nil {
+		syscall.Close(int(fd))
+		return nil, "", err
+	}
+	return os.NewFile(uintptr(fd), "pty"), slave, nil
+}
+
+// IsNoSuchMachine reports whether err means the machine is not registered.
+func IsNoSuchMachine(err error) bool {
+	if dbusErr, ok := err.(dbus.Error); ok {
+		return dbusErr.Name == ErrNoSuchMachine
+	}
+	if dbusErr, ok := err.(*dbus.Error); ok {
+		return dbusErr.Name == ErrNoSuchMachine
+	}
+	return false
+}
+
+func NewManager() (*Manager, error) {
+	obj, err := Object(ManagerPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{obj}, nil
 }
@@ -0,0 +1,130 @@
+package machined
+
+import (
+	"context"
+	"errors"
+
+	"github.com/AOSC-Dev/ciel/systemd-api"
+	"github.com/godbus/dbus/v5"
+)
+
+var ErrWatchClosed = errors.New("machine watcher is closed")
+
+// Event is a machine registered or removed.
+type Event struct {
+	Name string
+	Path dbus.ObjectPath
+	New  bool // false if removed
+}
+
+// Watcher delivers MachineNew and MachineRemoved signals of machined.
+type Watcher struct {
+	C <-chan Event
+
+	conn    *dbus.Conn
+	signals chan *dbus.Signal
+	events  chan Event
+	done    chan struct{}
+}
+
+func matchOptions() []dbus.MatchOption {
+	return []dbus.MatchOption{
+		dbus.WithMatchInterface(ManagerInterface),
+		dbus.WithMatchObjectPath(ManagerPath),
+	}
+}
+
+// Watch subscribes to machines being registered and removed. The watcher
+// must be closed after use.
+func (m Manager) Watch() (*Watcher, error) {
+	conn, err := systemd.Conn()
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.AddMatchSignal(matchOptions()...); err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		conn:    conn,
+		signals: make(chan *dbus.Signal, 16),
+		events:  make(chan Event),
+		done:    make(chan struct{}),
+	}
+	w.C = w.events
+	conn.Signal(w.signals)
+	go w.run()
+	return w, nil
+}
+
+func (w *Watcher) run() {
+	defer close(w.events)
+	for {
+		var sig *dbus.Signal
+		select {
+		case <-w.done:
+			return
+		case sig = <-w.signals:
+		}
+		if sig.Path != ManagerPath || len(sig.Body) < 2 {
+			continue
+		}
+		var e Event
+		switch sig.Name {
+		case ManagerInterface + ".MachineNew":
+			e.New = true
+		case ManagerInterface + ".MachineRemoved":
+		default:
+			continue
+		}
+		e.Name, _ = sig.Body[0].(string)
+		e.Path, _ = sig.Body[1].(dbus.ObjectPath)
+		select {
+		case <-w.done:
+			return
+		case w.events <- e:
+		}
+	}
+}
+
+// Close unsubscribes.
+func (w *Watcher) Close() {
+	w.conn.RemoveSignal(w.signals)
+	w.conn.RemoveMatchSignal(matchOptions()...)
+	close(w.done)
+}
+
+// WaitRegistered waits until the machine is registered.
+func (m Manager) WaitRegistered(ctx context.Context, name string) error {
+	return m.wait(ctx, name, true)
+}
+
+// WaitRemoved waits until the machine is gone.
+func (m Manager) WaitRemoved(ctx context.Context, name string) error {
+	return m.wait(ctx, name, false)
+}
+
+func (m Manager) wait(ctx context.Context, name string, registered bool) error {
+	w, err := m.Watch()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	// subscribed before checking, so that no change is missed
+	_, err = m.GetMachine(name)
+	if registered && err == nil || !registered && IsNoSuchMachine(err) {
+		return nil
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e, ok := <-w.C:
+			if !ok {
+				return ErrWatchClosed
+			}
+			if e.Name == name && e.New == registered {
+				return nil
+			}
+		}
+	}
+}
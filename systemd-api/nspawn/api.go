@@ -4,12 +4,12 @@ import (
 	"bytes"
 	"context"
 	"errors"
-	"log"
 	"os"
 	"os/exec"
-	"strings"
 	"syscall"
 	"time"
+
+	"github.com/AOSC-Dev/ciel/systemd-api/machined"
 )
 
 var BootableFiles = []string{
@@ -20,6 +20,11 @@ var BootableFiles = []string{
 
 const PowerOffTimeout = 30 * time.Second
 
+var ErrNoMachineId = errors.New("no machineId specified")
+
+// SigPowerOff makes systemd shut down, it is SIGRTMIN+4 of glibc.
+const SigPowerOff = 34 + 4
+
 type ErrCancelled struct {
 	reason string
 }
@@ -28,18 +33,37 @@ func (e ErrCancelled) Error() string {
 	return "cancelled: " + e.reason
 }
 
+// ErrSignaled means the command is killed by a signal.
+type ErrSignaled struct {
+	Signal syscall.Signal
+}
+
+func (e ErrSignaled) Error() string {
+	return "killed by signal: " + e.Signal.String()
+}
+
 func SystemdNspawnRun(ctx context.Context, machineId string, dir string, ctnInfo *ContainerInfo, runInfo *RunInfo) (int, error) {
-	a := nspawnArgs(machineId, dir, ctnInfo, runInfo)
+	a, err := nspawnArgs(machineId, dir, ctnInfo, runInfo)
+	if err != nil {
+		return -1, err
+	}
 	cmd := exec.CommandContext(ctx, "systemd-nspawn", a...)
+	ctnInfo.trace(cmd.Args)
 	setCmdStdDev(cmd, runInfo.StdDev)
 
-	err := cmd.Run()
-	waitUntilShutdown(ctx, machineId)
+	err = cmd.Run()
+	waitCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	waitUntilShutdown(waitCtx, machineId)
 	return unpackExecErr(err)
 }
 func SystemdNspawnBoot(ctx context.Context, machineId string, dir string, ctnInfo *ContainerInfo) error {
-	a := nspawnArgs(machineId, dir, ctnInfo, nil)
+	a, err := nspawnArgs(machineId, dir, ctnInfo, nil)
+	if err != nil {
+		return err
+	}
 	cmd := exec.CommandContext(ctx, "systemd-nspawn", a...)
+	ctnInfo.trace(cmd.Args)
 
 	var debug = true
 	_, e := os.Lstat("/tmp/ciel.debug")
@@ -47,8 +71,9 @@ func SystemdNspawnBoot(ctx context.Context, machineId string, dir string, ctnInf
 		debug = false
 	}
 
-	var err error
-	waitCtx, cancelFunc := context.WithCancel(context.Background())
+	exited := make(chan error, 1)
+	waitCtx, cancelFunc := context.WithCancel(ctx)
+	defer cancelFunc()
 	go func() {
 		errBuf := &bytes.Buffer{}
 		cmd.Stderr = errBuf
@@ -57,91 +82,82 @@ func SystemdNspawnBoot(ctx context.Context, machineId string, dir string, ctnInf
 		}
 		cmd.Run()
 		output := errBuf.String()
-		err = ErrCancelled{reason: string(output)}
+		exited <- ErrCancelled{reason: string(output)}
 		cancelFunc()
 	}()
-	cancelled := waitUntilRunningOrDegraded(waitCtx, machineId)
-	if cancelled {
+	bootCtx, cancel := context.WithTimeout(waitCtx, BootTimeout)
+	defer cancel()
+	err = waitUntilRunningOrDegraded(bootCtx, machineId)
+	if _, ok := err.(ErrTimeout); ok {
+		Terminate(context.Background(), machineId)
 		return err
 	}
-	return nil
-}
-
-func SystemdRun(ctx context.Context, machineId string, runInfo *RunInfo) (int, error) {
-	a := runArgs(machineId, runInfo)
-	cmd := exec.CommandContext(ctx, "systemd-run", a...)
-	setCmdStdDev(cmd, runInfo.StdDev)
-
-	err := cmd.Run()
-	defer func() {
-		// shutting down...
-		if !MachineRunning(MachineStatus(ctx, machineId)) {
-			waitUntilShutdown(ctx, machineId)
+	if err != nil {
+		select {
+		case exitErr := <-exited:
+			return exitErr
+		default:
+			return err
 		}
-	}()
-	return unpackExecErr(err)
+	}
+	return nil
 }
 
-func MachinectlShell(ctx context.Context, machineId string, runInfo *RunInfo) (int, error) {
+func MachinectlShell(ctx context.Context, machineId string, ctnInfo *ContainerInfo, runInfo *RunInfo) (int, error) {
 	a := msArgs(machineId, runInfo)
 	cmd := exec.CommandContext(ctx, "machinectl", a...)
+	ctnInfo.trace(cmd.Args)
 	setCmdStdDev(cmd, runInfo.StdDev)
 
 	err := cmd.Run()
-	defer func() {
-		// shutting down...
-		if !MachineRunning(MachineStatus(ctx, machineId)) {
-			waitUntilShutdown(ctx, machineId)
-		}
-	}()
-	// FIXME: machinectl shell cannot return the exit status of executable
+	defer waitShutdownAfterCommand(machineId)
+	// machinectl shell cannot return the exit status of the executable,
+	// use SystemdRun for commands.
 	return unpackExecErr(err)
 }
 
-func MachinectlTerminate(ctx context.Context, machineId string) error {
-	err := machinectlTerminate(ctx, machineId)
-	waitUntilShutdown(ctx, machineId)
-	return err
-}
-
-func MachinectlPowerOff(ctx context.Context, machineId string) error {
-	a := []string{
-		"poweroff",
-		"--quiet",
-		machineId,
+// Terminate kills all processes of the container, and waits until it is
+// gone.
+func Terminate(ctx context.Context, machineId string) error {
+	m, err := machined.NewManager()
+	if err != nil {
+		return err
 	}
-	cmd := exec.CommandContext(ctx, "machinectl", a...)
-	output, err := cmd.CombinedOutput()
-	if _, ok := err.(*exec.ExitError); ok {
-		return errors.New(strings.TrimSpace(string(output)))
+	err = m.TerminateMachine(machineId)
+	if machined.IsNoSuchMachine(err) {
+		return nil
 	} else if err != nil {
 		return err
 	}
-	waitCtx, _ := context.WithTimeout(ctx, PowerOffTimeout)
-	if waitUntilShutdown(waitCtx, machineId) { // cancelled
-		machinectlTerminate(context.Background(), machineId)
-	}
-	return nil
+	waitCtx, cancel := context.WithTimeout(ctx, ShutdownTimeout)
+	defer cancel()
+	return waitUntilShutdown(waitCtx, machineId)
 }
 
-func machinectlTerminate(ctx context.Context, machineId string) error {
-	a := []string{
-		"terminate",
-		"--quiet",
-		machineId,
+// PowerOff asks the init of the container to shut down, and terminates the
+// container if it does not within PowerOffTimeout.
+func PowerOff(ctx context.Context, machineId string) error {
+	m, err := machined.NewManager()
+	if err != nil {
+		return err
 	}
-	cmd := exec.CommandContext(ctx, "machinectl", a...)
-	output, err := cmd.CombinedOutput()
-	if _, ok := err.(*exec.ExitError); ok {
-		return errors.New(strings.TrimSpace(string(output)))
-	} else {
+	err = m.KillMachine(machineId, machined.KillLeader, SigPowerOff)
+	if machined.IsNoSuchMachine(err) {
+		return nil
+	} else if err != nil {
 		return err
 	}
+	waitCtx, cancel := context.WithTimeout(ctx, PowerOffTimeout)
+	defer cancel()
+	if waitUntilShutdown(waitCtx, machineId) != nil {
+		return Terminate(context.Background(), machineId)
+	}
+	return nil
 }
 
-func nspawnArgs(machineId string, dir string, ctnInfo *ContainerInfo, runInfo *RunInfo) []string {
+func nspawnArgs(machineId string, dir string, ctnInfo *ContainerInfo, runInfo *RunInfo) ([]string, error) {
 	if machineId == "" {
-		log.Panicln("no machineId specified")
+		return nil, ErrNoMachineId
 	}
 
 	a := []string{
@@ -160,10 +176,7 @@ func nspawnArgs(machineId string, dir string, ctnInfo *ContainerInfo, runInfo *R
 		a = append(a, "--boot")
 	}
 	if ctnInfo.Network != nil {
-		netInfo := ctnInfo.Network
-		if netInfo.Zone != "" {
-			a = append(a, "--network-zone="+netInfo.Zone)
-		}
+		a = append(a, ctnInfo.Network.args()...)
 	}
 
 	a = append(a, "--")
@@ -175,22 +188,7 @@ func nspawnArgs(machineId string, dir string, ctnInfo *ContainerInfo, runInfo *R
 		a = append(a, runInfo.Args...)
 	}
 
-	return a
-}
-
-func runArgs(machineId string, runInfo *RunInfo) []string {
-	a := []string{
-		"--quiet",
-		"--wait",
-		"--pty",
-		"--uid=root", // for login sessions
-		"--send-sighup",
-		"-M", machineId,
-	}
-	a = append(a, "--")
-	a = append(a, runInfo.App)
-	a = append(a, runInfo.Args...)
-	return a
+	return a, nil
 }
 
 func msArgs(machineId string, runInfo *RunInfo) []string {
@@ -218,7 +216,11 @@ func setCmdStdDev(cmd *exec.Cmd, stdDev *StdDevInfo) {
 
 func unpackExecErr(err error) (int, error) {
 	if exitErr, ok := err.(*exec.ExitError); ok {
-		return exitErr.Sys().(syscall.WaitStatus).ExitStatus(), nil
+		status := exitErr.Sys().(syscall.WaitStatus)
+		if status.Signaled() {
+			return 128 + int(status.Signal()), ErrSignaled{Signal: status.Signal()}
+		}
+		return status.ExitStatus(), nil
 	}
 	if err != nil {
 		return -1, err
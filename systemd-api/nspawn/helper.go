@@ -2,10 +2,10 @@ package nspawn
 
 import (
 	"context"
+	"fmt"
 	"os"
-	"os/exec"
 	"path"
-	"strings"
+	"time"
 )
 
 func IsBootable(p string) bool {
@@ -18,15 +18,16 @@ func IsBootable(p string) bool {
 	return false
 }
 
+// MachineStatus returns the state of the system manager in the container,
+// as "running", or an empty string if it cannot be reached.
 func MachineStatus(ctx context.Context, machineId string) string {
-	a := []string{
-		"is-system-running",
-		"-M", machineId,
+	bus, _, err := machineBus(machineId)
+	if err != nil {
+		return ""
 	}
-	cmd := exec.CommandContext(ctx, "systemctl", a...)
-	cmd.Env = dedupEnv(append(os.Environ(), "LC_ALL=C"))
-	output, _ := cmd.CombinedOutput()
-	return strings.TrimSpace(string(output))
+	defer bus.Close()
+	state, _ := bus.Manager().SystemState(ctx)
+	return state
 }
 
 func MachineRunning(status string) bool {
@@ -40,11 +41,6 @@ func MachineRunning(status string) bool {
 	}
 }
 
-func MachineDead(status string) bool {
-	switch status {
-	case "Failed to connect to bus: Host is down":
-		return true
-	default:
-		return false
-	}
+func transientUnitName() string {
+	return fmt.Sprintf("ciel-run-%d-%d.service", os.Getpid(), time.Now().UnixNano())
 }
@@ -0,0 +1,110 @@
+package nspawn
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidMemory   = errors.New("invalid memory limit, expecting a size like 8G, a percentage or infinity")
+	ErrInvalidCPUs     = errors.New("invalid CPU limit, expecting a positive number of CPUs")
+	ErrInvalidIOWeight = errors.New("invalid IO weight, expecting 1 to 10000")
+)
+
+// Limits restricts the resources of a container, zero values mean no limit.
+type Limits struct {
+	Memory   string  `json:"memory,omitempty"`    // MemoryMax=, as in 8G
+	CPUs     float64 `json:"cpus,omitempty"`      // CPUQuota=, in CPUs
+	TasksMax uint64  `json:"tasks_max,omitempty"` // TasksMax=
+	IOWeight uint64  `json:"io_weight,omitempty"` // IOWeight=, 1 to 10000
+}
+
+// Check validates the limits.
+func (l Limits) Check() error {
+	if l.Memory != "" && !validSize(l.Memory) {
+		return ErrInvalidMemory
+	}
+	if l.CPUs < 0 {
+		return ErrInvalidCPUs
+	}
+	if l.IOWeight > 10000 {
+		return ErrInvalidIOWeight
+	}
+	return nil
+}
+
+func validSize(s string) bool {
+	if s == "infinity" {
+		return true
+	}
+	if strings.HasSuffix(s, "%") {
+		n, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		return err == nil && n > 0 && n <= 100
+	}
+	if i := len(s) - 1; i > 0 && strings.ContainsRune("KMGT", rune(s[i])) {
+		s = s[:i]
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	return err == nil && n > 0
+}
+
+// Merge returns the limits, overridden by the ones set in o.
+func (l Limits) Merge(o Limits) Limits {
+	if o.Memory != "" {
+		l.Memory = o.Memory
+	}
+	if o.CPUs != 0 {
+		l.CPUs = o.CPUs
+	}
+	if o.TasksMax != 0 {
+		l.TasksMax = o.TasksMax
+	}
+	if o.IOWeight != 0 {
+		l.IOWeight = o.IOWeight
+	}
+	return l
+}
+
+// Empty reports whether no limit is set.
+func (l Limits) Empty() bool {
+	return l == Limits{}
+}
+
+// Properties returns the limits as properties of the scope unit.
+func (l Limits) Properties() []string {
+	var p []string
+	if l.Memory != "" {
+		p = append(p, "MemoryMax="+l.Memory)
+	}
+	if l.CPUs != 0 {
+		p = append(p, "CPUQuota="+strconv.FormatFloat(l.CPUs*100, 'f', -1, 64)+"%")
+	}
+	if l.TasksMax != 0 {
+		p = append(p, "TasksMax="+strconv.FormatUint(l.TasksMax, 10))
+	}
+	if l.IOWeight != 0 {
+		p = append(p, "IOWeight="+strconv.FormatUint(l.IOWeight, 10))
+	}
+	return p
+}
+
+func (l Limits) String() string {
+	if l.Empty() {
+		return "none"
+	}
+	var s []string
+	if l.Memory != "" {
+		s = append(s, "memory "+l.Memory)
+	}
+	if l.CPUs != 0 {
+		s = append(s, "cpus "+strconv.FormatFloat(l.CPUs, 'f', -1, 64))
+	}
+	if l.TasksMax != 0 {
+		s = append(s, "tasks "+strconv.FormatUint(l.TasksMax, 10))
+	}
+	if l.IOWeight != 0 {
+		s = append(s, "io-weight "+strconv.FormatUint(l.IOWeight, 10))
+	}
+	return strings.Join(s, ", ")
+}
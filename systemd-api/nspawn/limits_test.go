@@ -0,0 +1,55 @@
+package nspawn
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLimitsCheck(t *testing.T) {
+	tests := []struct {
+		limits Limits
+		err    error
+	}{
+		{Limits{}, nil},
+		{Limits{Memory: "8G", CPUs: 2.5, TasksMax: 100, IOWeight: 10000}, nil},
+		{Limits{Memory: "1024"}, nil},
+		{Limits{Memory: "512M"}, nil},
+		{Limits{Memory: "512m"}, ErrInvalidMemory},
+		{Limits{Memory: "50%"}, nil},
+		{Limits{Memory: "100%"}, nil},
+		{Limits{Memory: "infinity"}, nil},
+		{Limits{Memory: "0"}, ErrInvalidMemory},
+		{Limits{Memory: "G"}, ErrInvalidMemory},
+		{Limits{Memory: "8GB"}, ErrInvalidMemory},
+		{Limits{Memory: "-8G"}, ErrInvalidMemory},
+		{Limits{Memory: "0%"}, ErrInvalidMemory},
+		{Limits{Memory: "101%"}, ErrInvalidMemory},
+		{Limits{CPUs: -1}, ErrInvalidCPUs},
+		{Limits{IOWeight: 10001}, ErrInvalidIOWeight},
+	}
+	for _, test := range tests {
+		if err := test.limits.Check(); err != test.err {
+			t.Errorf("%+v: %v, want %v", test.limits, err, test.err)
+		}
+	}
+}
+
+func TestLimitsMerge(t *testing.T) {
+	base := Limits{Memory: "8G", CPUs: 4, TasksMax: 100}
+	got := base.Merge(Limits{CPUs: 2, IOWeight: 500})
+	want := Limits{Memory: "8G", CPUs: 2, TasksMax: 100, IOWeight: 500}
+	if got != want {
+		t.Errorf("Merge() = %+v, want %+v", got, want)
+	}
+}
+
+func TestLimitsProperties(t *testing.T) {
+	got := Limits{Memory: "8G", CPUs: 1.5, TasksMax: 100, IOWeight: 500}.Properties()
+	want := []string{"MemoryMax=8G", "CPUQuota=150%", "TasksMax=100", "IOWeight=500"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Properties() = %q, want %q", got, want)
+	}
+	if p := (Limits{}).Properties(); p != nil {
+		t.Errorf("Properties() of no limits = %q", p)
+	}
+}
@@ -0,0 +1,134 @@
+package nspawn
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Network modes, some of them take an argument as in "zone:NAME".
+const (
+	NetworkHost    = "host"    // share the network of the host
+	NetworkNone    = "none"    // loopback only
+	NetworkZone    = "zone"    // zone:NAME, a bridge shared by containers
+	NetworkVeth    = "veth"    // a virtual Ethernet link to the host
+	NetworkBridge  = "bridge"  // bridge:BRIDGE, a link added to a host bridge
+	NetworkMacVlan = "macvlan" // macvlan:IFACE, a MACVLAN of a host interface
+
+	DefaultZone = "ciel"
+
+	// DefaultNetwork is used when neither the instance nor the workspace
+	// sets a network mode, so builders are offline unless configured
+	// otherwise.
+	DefaultNetwork = NetworkNone
+)
+
+var (
+	ErrInvalidNetwork = errors.New("invalid network mode, expecting host, none, zone:NAME, veth, bridge:BRIDGE or macvlan:IFACE")
+	ErrInvalidPort    = errors.New("invalid port, expecting [tcp:|udp:]HOST[:CONTAINER]")
+	ErrPortNotAllowed = errors.New("ports can be forwarded with zone, veth or bridge networks only")
+)
+
+// NetworkInfo describes the network of a container.
+type NetworkInfo struct {
+	Mode string
+	Name string // zone, bridge or interface name
+
+	// Ports are forwarded from the host to the container, in the format
+	// of --port of systemd-nspawn.
+	Ports []string
+}
+
+// ParseNetwork parses a network mode. An empty string means DefaultNetwork,
+// "true" means the default zone and "false" none, as the network flag used
+// to be a switch.
+func ParseNetwork(s string) (*NetworkInfo, error) {
+	switch s {
+	case "":
+		return &NetworkInfo{Mode: DefaultNetwork}, nil
+	case "false":
+		return &NetworkInfo{Mode: NetworkNone}, nil
+	case "true":
+		return &NetworkInfo{Mode: NetworkZone, Name: DefaultZone}, nil
+	}
+	fields := strings.SplitN(s, ":", 2)
+	n := &NetworkInfo{Mode: fields[0]}
+	if len(fields) == 2 {
+		n.Name = fields[1]
+	}
+	switch n.Mode {
+	case NetworkHost, NetworkNone, NetworkVeth:
+		if len(fields) == 2 {
+			return nil, ErrInvalidNetwork
+		}
+	case NetworkZone, NetworkBridge, NetworkMacVlan:
+		if n.Name == "" {
+			return nil, ErrInvalidNetwork
+		}
+	default:
+		return nil, ErrInvalidNetwork
+	}
+	return n, nil
+}
+
+func (n *NetworkInfo) String() string {
+	if n.Name != "" {
+		return n.Mode + ":" + n.Name
+	}
+	return n.Mode
+}
+
+// Check validates the forwarded ports.
+func (n *NetworkInfo) Check() error {
+	if len(n.Ports) == 0 {
+		return nil
+	}
+	switch n.Mode {
+	case NetworkZone, NetworkVeth, NetworkBridge:
+	default:
+		return ErrPortNotAllowed
+	}
+	for _, port := range n.Ports {
+		if err := CheckPort(port); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckPort validates a port to forward, [tcp:|udp:]HOST[:CONTAINER].
+func CheckPort(port string) error {
+	fields := strings.Split(port, ":")
+	if fields[0] == "tcp" || fields[0] == "udp" {
+		fields = fields[1:]
+	}
+	if len(fields) < 1 || len(fields) > 2 {
+		return ErrInvalidPort
+	}
+	for _, field := range fields {
+		if n, err := strconv.Atoi(field); err != nil || n <= 0 || n > 65535 {
+			return ErrInvalidPort
+		}
+	}
+	return nil
+}
+
+func (n *NetworkInfo) args() []string {
+	var a []string
+	switch n.Mode {
+	case NetworkNone:
+		a = append(a, "--private-network")
+	case NetworkZone:
+		a = append(a, "--network-zone="+n.Name)
+	case NetworkVeth:
+		a = append(a, "--network-veth")
+	case NetworkBridge:
+		a = append(a, "--network-bridge="+n.Name)
+	case NetworkMacVlan:
+		a = append(a, "--network-macvlan="+n.Name)
+	}
+	for _, port := range n.Ports {
+		a = append(a, "--port="+port)
+	}
+	return a
+}
@@ -0,0 +1,61 @@
+package nspawn
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseNetwork(t *testing.T) {
+	tests := []struct {
+		in   string
+		want *NetworkInfo
+	}{
+		{"", &NetworkInfo{Mode: DefaultNetwork}},
+		{"false", &NetworkInfo{Mode: NetworkNone}},
+		{"true", &NetworkInfo{Mode: NetworkZone, Name: DefaultZone}},
+		{"host", &NetworkInfo{Mode: NetworkHost}},
+		{"none", &NetworkInfo{Mode: NetworkNone}},
+		{"veth", &NetworkInfo{Mode: NetworkVeth}},
+		{"zone:test", &NetworkInfo{Mode: NetworkZone, Name: "test"}},
+		{"bridge:br0", &NetworkInfo{Mode: NetworkBridge, Name: "br0"}},
+		{"macvlan:eth0", &NetworkInfo{Mode: NetworkMacVlan, Name: "eth0"}},
+		{"none:x", nil},
+		{"veth:x", nil},
+		{"zone", nil},
+		{"zone:", nil},
+		{"bridge", nil},
+		{"wifi", nil},
+	}
+	for _, test := range tests {
+		got, err := ParseNetwork(test.in)
+		if test.want == nil {
+			if err != ErrInvalidNetwork {
+				t.Errorf("ParseNetwork(%q) = %v, %v, want ErrInvalidNetwork", test.in, got, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseNetwork(%q) = %v, %v, want %v", test.in, got, err, test.want)
+		}
+	}
+}
+
+func TestNetworkCheck(t *testing.T) {
+	tests := []struct {
+		network NetworkInfo
+		err     error
+	}{
+		{NetworkInfo{Mode: NetworkHost}, nil},
+		{NetworkInfo{Mode: NetworkZone, Name: "z", Ports: []string{"80", "tcp:8080:80", "udp:53:53"}}, nil},
+		{NetworkInfo{Mode: NetworkHost, Ports: []string{"80"}}, ErrPortNotAllowed},
+		{NetworkInfo{Mode: NetworkVeth, Ports: []string{"sctp:80"}}, ErrInvalidPort},
+		{NetworkInfo{Mode: NetworkVeth, Ports: []string{"0"}}, ErrInvalidPort},
+		{NetworkInfo{Mode: NetworkVeth, Ports: []string{"1:2:3"}}, ErrInvalidPort},
+		{NetworkInfo{Mode: NetworkVeth, Ports: []string{"65536"}}, ErrInvalidPort},
+	}
+	for _, test := range tests {
+		if err := test.network.Check(); err != test.err {
+			t.Errorf("%v %q: %v, want %v", &test.network, test.network.Ports, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package nspawn
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/AOSC-Dev/ciel/systemd-api/machined"
+)
+
+// ptyDrainTimeout is how long the output of a command is read after it
+// exits, in case a process left behind keeps its terminal open.
+const ptyDrainTimeout = time.Second
+
+// ptyForward copies the standard devices from and to a pseudo terminal in
+// the container, as systemd-run --pty does. Standard error goes to the
+// terminal too.
+type ptyForward struct {
+	path   string // of the slave in the container
+	master *os.File
+	// slave is held open until the command exits, as the master cannot be
+	// read while no one has the slave open.
+	slave *os.File
+
+	input  *os.File // non-blocking duplicate of the standard input
+	output chan struct{}
+
+	tty     int // standard input, if it is a terminal, otherwise -1
+	saved   *syscall.Termios
+	resized chan os.Signal
+}
+
+func openPTY(machineId string, leader uint32) (*ptyForward, error) {
+	m, err := machined.NewManager()
+	if err != nil {
+		return nil, err
+	}
+	master, slavePath, err := m.OpenMachinePTY(machineId)
+	if err != nil {
+		return nil, err
+	}
+	root := "/proc/" + strconv.FormatUint(uint64(leader), 10) + "/root"
+	slave, err := os.OpenFile(path.Join(root, slavePath), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	return &ptyForward{
+		path:   slavePath,
+		master: master,
+		slave:  slave,
+		output: make(chan struct{}),
+		tty:    -1,
+	}, nil
+}
+
+// start copies stdDev, or the standard devices of ciel if it is nil.
+func (f *ptyForward) start(stdDev *StdDevInfo) {
+	var in io.Reader = os.Stdin
+	var out io.Writer = os.Stdout
+	if stdDev != nil {
+		in, out = stdDev.Stdin, stdDev.Stdout
+	}
+	if out == nil {
+		out = ioutil.Discard
+	}
+	if file, ok := in.(*os.File); ok && isTerminal(int(file.Fd())) {
+		f.tty = int(file.Fd())
+		f.saved, _ = makeRaw(f.tty)
+		f.resized = make(chan os.Signal, 1)
+		signal.Notify(f.resized, syscall.SIGWINCH)
+		f.resize()
+		go func() {
+			for range f.resized {
+				f.resize()
+			}
+		}()
+	} else {
+		// input which is not typed is not to be echoed to the output
+		f.control(f.slave, func(fd int) {
+			var t syscall.Termios
+			if ioctl(fd, syscall.TCGETS, unsafe.Pointer(&t)) == nil {
+				t.Lflag &^= syscall.ECHO
+				ioctl(fd, syscall.TCSETS, unsafe.Pointer(&t))
+			}
+		})
+	}
+	if in != nil {
+		go io.Copy(f.master, f.interruptible(in))
+	}
+	go func() {
+		// ends with EIO when the slave is closed by all
+		io.Copy(out, f.master)
+		close(f.output)
+	}()
+}
+
+// interruptible returns in as a file which can be stopped reading in
+// close, if it is one, so that no input is lost after the command exits.
+func (f *ptyForward) interruptible(in io.Reader) io.Reader {
+	file, ok := in.(*os.File)
+	if !ok {
+		return in
+	}
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err != nil {
+		return in
+	}
+	syscall.CloseOnExec(fd)
+	// shared with the original, Fd makes it blocking again in close
+	syscall.SetNonblock(fd, true)
+	f.input = os.NewFile(uintptr(fd), file.Name())
+	return f.input
+}
+
+// close waits for the output of the command, then stops copying and
+// restores the terminal.
+func (f *ptyForward) close() {
+	if f.input != nil {
+		f.input.SetReadDeadline(time.Now())
+		f.input.Fd()
+		f.input.Close()
+	}
+	f.slave.Close()
+	f.master.SetReadDeadline(time.Now().Add(ptyDrainTimeout))
+	<-f.output
+	f.master.Close()
+	if f.resized != nil {
+		signal.Stop(f.resized)
+		close(f.resized)
+	}
+	if f.saved != nil {
+		ioctl(f.tty, syscall.TCSETS, unsafe.Pointer(f.saved))
+	}
+}
+
+// resize sets the size of the terminal in the container to the one of
+// ciel.
+func (f *ptyForward) resize() {
+	var ws winsize
+	if ioctl(f.tty, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)) != nil {
+		return
+	}
+	f.control(f.master, func(fd int) {
+		ioctl(fd, syscall.TIOCSWINSZ, unsafe.Pointer(&ws))
+	})
+}
+
+// control runs fn on the descriptor of the file, without making it
+// blocking as Fd does.
+func (f *ptyForward) control(file *os.File, fn func(fd int)) {
+	rc, err := file.SyscallConn()
+	if err != nil {
+		return
+	}
+	rc.Control(func(fd uintptr) {
+		fn(int(fd))
+	})
+}
+
+type winsize struct {
+	Row    uint16
+	Col    uint16
+	Xpixel uint16
+	Ypixel uint16
+}
+
+func ioctl(fd int, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func isTerminal(fd int) bool {
+	var t syscall.Termios
+	return ioctl(fd, syscall.TCGETS, unsafe.Pointer(&t)) == nil
+}
+
+// makeRaw puts the terminal in raw mode as cfmakeraw(3) does, so that
+// keys reach the container as they are typed. The original mode is
+// returned.
+func makeRaw(fd int) (*syscall.Termios, error) {
+	var t syscall.Termios
+	if err := ioctl(fd, syscall.TCGETS, unsafe.Pointer(&t)); err != nil {
+		return nil, err
+	}
+	saved := t
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB
+	t.Cflag |= syscall.CS8
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, syscall.TCSETS, unsafe.Pointer(&t)); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
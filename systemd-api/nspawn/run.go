@@ -0,0 +1,165 @@
+package nspawn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/AOSC-Dev/ciel/systemd-api/machined"
+	"github.com/AOSC-Dev/ciel/systemd-api/systemd1"
+	"github.com/godbus/dbus/v5"
+)
+
+// Values of ExecMainCode, from waitid(2).
+const (
+	cldExited = 1
+	cldKilled = 2
+	cldDumped = 3
+)
+
+var ErrBusClosed = errors.New("bus of the container is closed")
+
+// SystemdRun runs the command as a transient service in the booted
+// container, through the system manager of the container, on a terminal
+// allocated by machined. The exit status is taken from the service, so
+// that it is the one of the command, or 128+N with ErrSignaled if it is
+// killed by signal N. The service is stopped if ctx is done.
+func SystemdRun(ctx context.Context, machineId string, runInfo *RunInfo) (int, error) {
+	bus, leader, err := machineBus(machineId)
+	if err != nil {
+		return -1, err
+	}
+	defer waitShutdownAfterCommand(machineId)
+	defer bus.Close()
+	m := bus.Manager()
+	if err := m.Subscribe(); err != nil {
+		return -1, err
+	}
+	signals := bus.Signals()
+
+	pty, err := openPTY(machineId, leader)
+	if err != nil {
+		return -1, err
+	}
+	pty.start(runInfo.StdDev)
+	defer pty.close()
+
+	unit := transientUnitName()
+	if _, err := m.StartTransientUnit(unit, systemd1.ModeFail, runProperties(runInfo, pty.path)); err != nil {
+		return -1, err
+	}
+	defer m.ResetFailedUnit(unit)
+	return waitUnit(ctx, bus, unit, signals)
+}
+
+// machineBus connects to the system manager of the container.
+func machineBus(machineId string) (*systemd1.Bus, uint32, error) {
+	m, err := machined.NewManager()
+	if err != nil {
+		return nil, 0, err
+	}
+	machine, err := m.GetMachine(machineId)
+	if err != nil {
+		return nil, 0, err
+	}
+	leader, err := machine.Leader()
+	if err != nil {
+		return nil, 0, err
+	}
+	bus, err := systemd1.DialMachine(leader)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bus, leader, nil
+}
+
+func runProperties(runInfo *RunInfo, tty string) []systemd1.Property {
+	argv := append([]string{runInfo.App}, runInfo.Args...)
+	cmd := systemd1.ExecCommand{Path: runInfo.App, Args: argv}
+	if !path.IsAbs(runInfo.App) {
+		// looked up in the PATH of the container
+		cmd.Path = "/usr/bin/env"
+		cmd.Args = append([]string{"env", "--"}, argv...)
+	}
+	props := []systemd1.Property{
+		systemd1.NewProperty("Description", strings.Join(argv, " ")),
+		systemd1.NewProperty("ExecStart", []systemd1.ExecCommand{cmd}),
+		systemd1.NewProperty("User", "root"), // for login sessions
+		systemd1.NewProperty("SendSIGHUP", true),
+		// the unit is kept until the bus is closed, to read its result
+		systemd1.NewProperty("AddRef", true),
+		systemd1.NewProperty("TTYPath", tty),
+		systemd1.NewProperty("StandardInput", "tty"),
+		systemd1.NewProperty("StandardOutput", "tty"),
+		systemd1.NewProperty("StandardError", "tty"),
+	}
+	if term := os.Getenv("TERM"); term != "" {
+		props = append(props, systemd1.NewProperty("Environment", []string{"TERM=" + term}))
+	}
+	return props
+}
+
+// waitUnit waits until the service exits, and returns its exit status as
+// SystemdRun does.
+func waitUnit(ctx context.Context, bus *systemd1.Bus, name string, signals <-chan *dbus.Signal) (int, error) {
+	m := bus.Manager()
+	unitPath, err := m.GetUnit(name)
+	if err != nil {
+		return -1, err
+	}
+	u := bus.Unit(unitPath)
+	for {
+		// checked on any signal, the changes of the unit among them
+		exited, err := unitExited(u)
+		if err != nil {
+			return -1, err
+		}
+		if exited {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			m.StopUnit(name, systemd1.ModeReplace)
+			return -1, ctx.Err()
+		case _, ok := <-signals:
+			if !ok {
+				return -1, ErrBusClosed
+			}
+		}
+	}
+	code, status, err := u.ExecMain()
+	if err != nil {
+		return -1, err
+	}
+	if code != cldExited && code != cldKilled && code != cldDumped {
+		result, _ := u.Result()
+		return -1, fmt.Errorf("%s did not run: %s", name, result)
+	}
+	return exitStatus(code, status)
+}
+
+// exitStatus converts how the main process of a service ended.
+func exitStatus(code int32, status int32) (int, error) {
+	if code == cldExited {
+		return int(status), nil
+	}
+	return 128 + int(status), ErrSignaled{Signal: syscall.Signal(status)}
+}
+
+// unitExited reports whether the service has run, that is, it is inactive
+// with no job pending.
+func unitExited(u *systemd1.Unit) (bool, error) {
+	job, err := u.Job()
+	if err != nil || job != 0 {
+		return false, err
+	}
+	state, err := u.ActiveState()
+	if err != nil {
+		return false, err
+	}
+	return state == "inactive" || state == "failed", nil
+}
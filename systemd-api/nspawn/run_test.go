@@ -0,0 +1,147 @@
+package nspawn
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/AOSC-Dev/ciel/systemd-api/systemd1"
+	"github.com/godbus/dbus/v5"
+)
+
+func TestRunProperties(t *testing.T) {
+	os.Setenv("TERM", "xterm")
+	tests := []struct {
+		runInfo *RunInfo
+		want    systemd1.ExecCommand
+	}{
+		{
+			&RunInfo{App: "/bin/sh", Args: []string{"-c", "true"}},
+			systemd1.ExecCommand{Path: "/bin/sh", Args: []string{"/bin/sh", "-c", "true"}},
+		},
+		{
+			&RunInfo{App: "acbs-build", Args: []string{"-g", "bash"}},
+			systemd1.ExecCommand{Path: "/usr/bin/env", Args: []string{"env", "--", "acbs-build", "-g", "bash"}},
+		},
+	}
+	for _, test := range tests {
+		props := make(map[string]dbus.Variant)
+		for _, p := range runProperties(test.runInfo, "/dev/pts/0") {
+			props[p.Name] = p.Value
+		}
+		got := props["ExecStart"].Value().([]systemd1.ExecCommand)
+		if !reflect.DeepEqual(got, []systemd1.ExecCommand{test.want}) {
+			t.Errorf("ExecStart of %v = %v, want %v", test.runInfo, got, test.want)
+		}
+		if sig := props["ExecStart"].Signature().String(); sig != "a(sasb)" {
+			t.Errorf("ExecStart has signature %s", sig)
+		}
+		for _, name := range []string{"StandardInput", "StandardOutput", "StandardError"} {
+			if v := props[name].Value(); v != "tty" {
+				t.Errorf("%s = %v", name, v)
+			}
+		}
+		if v := props["TTYPath"].Value(); v != "/dev/pts/0" {
+			t.Errorf("TTYPath = %v", v)
+		}
+		if v := props["AddRef"].Value(); v != true {
+			t.Errorf("AddRef = %v", v)
+		}
+		if v := props["Environment"].Value(); !reflect.DeepEqual(v, []string{"TERM=xterm"}) {
+			t.Errorf("Environment = %v", v)
+		}
+	}
+	if sig := dbus.SignatureOf(runProperties(tests[0].runInfo, "")).String(); sig != "a(sv)" {
+		t.Errorf("properties have signature %s", sig)
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		code, status int32
+		want         int
+		signal       syscall.Signal
+	}{
+		{cldExited, 0, 0, 0},
+		{cldExited, 3, 3, 0},
+		{cldKilled, int32(syscall.SIGTERM), 128 + 15, syscall.SIGTERM},
+		{cldDumped, int32(syscall.SIGSEGV), 128 + 11, syscall.SIGSEGV},
+	}
+	for _, test := range tests {
+		got, err := exitStatus(test.code, test.status)
+		if got != test.want {
+			t.Errorf("exitStatus(%d, %d) = %d, want %d", test.code, test.status, got, test.want)
+		}
+		sig, _ := err.(ErrSignaled)
+		if test.signal != 0 && sig.Signal != test.signal || test.signal == 0 && err != nil {
+			t.Errorf("exitStatus(%d, %d) returns %v", test.code, test.status, err)
+		}
+	}
+}
+
+// hostPTY opens a pseudo terminal of the host, as machined does in the
+// container.
+func hostPTY(t *testing.T) (*ptyForward, *os.File) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skip(err)
+	}
+	var n uint32
+	var unlock int32
+	rc, _ := master.SyscallConn()
+	rc.Control(func(fd uintptr) {
+		ioctl(int(fd), syscall.TIOCSPTLCK, unsafe.Pointer(&unlock))
+		ioctl(int(fd), syscall.TIOCGPTN, unsafe.Pointer(&n))
+	})
+	slavePath := "/dev/pts/" + strconv.Itoa(int(n))
+	slave, err := os.OpenFile(slavePath, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	command, err := os.OpenFile(slavePath, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &ptyForward{path: slavePath, master: master, slave: slave, output: make(chan struct{}), tty: -1}
+	return f, command
+}
+
+func TestPTYForward(t *testing.T) {
+	f, command := hostPTY(t)
+	out := &bytes.Buffer{}
+	f.start(&StdDevInfo{Stdin: strings.NewReader("input\n"), Stdout: out})
+	line := make([]byte, 6)
+	if _, err := io.ReadFull(command, line); err != nil || string(line) != "input\n" {
+		t.Errorf("command reads %q, %v", line, err)
+	}
+	command.Write([]byte("output\n"))
+	command.Close()
+	f.close()
+	// not echoed, with the line ending of terminals
+	if out.String() != "output\r\n" {
+		t.Errorf("output is %q", out.String())
+	}
+}
+
+func TestPTYForwardLeftOpen(t *testing.T) {
+	f, command := hostPTY(t)
+	defer command.Close()
+	out := &bytes.Buffer{}
+	f.start(&StdDevInfo{Stdout: out})
+	command.Write([]byte("output\n"))
+	start := time.Now()
+	f.close()
+	if out.String() != "output\r\n" {
+		t.Errorf("output is %q", out.String())
+	}
+	if elapsed := time.Since(start); elapsed > 2*ptyDrainTimeout {
+		t.Errorf("closing takes %v", elapsed)
+	}
+}
@@ -15,6 +15,16 @@ type ContainerInfo struct {
 	InitArgs   []string
 	Properties []string
 	Network    *NetworkInfo
+
+	// Trace receives the command lines run for the container, nil to
+	// ignore them.
+	Trace func(args []string)
+}
+
+func (c *ContainerInfo) trace(args []string) {
+	if c != nil && c.Trace != nil {
+		c.Trace(args)
+	}
 }
 
 type StdDevInfo struct {
@@ -22,13 +32,3 @@ type StdDevInfo struct {
 	Stdout io.Writer
 	Stderr io.Writer
 }
-
-// TODO: Network Configuration
-// NOTE: NetworkInfo is not used for now.
-type NetworkInfo struct {
-	//Private     bool
-	//IfaceMoveIn []string
-	//MacVlan     []string
-	//IpVlan      []string
-	Zone string
-}
@@ -3,40 +3,138 @@ package nspawn
 import (
 	"context"
 	"time"
+
+	"github.com/AOSC-Dev/ciel/systemd-api/machined"
+	"github.com/AOSC-Dev/ciel/systemd-api/systemd1"
+	"github.com/godbus/dbus/v5"
 )
 
-func waitUntilRunningOrDegraded(ctx context.Context, machineId string) (cancelled bool) {
+const (
+	BootTimeout     = 2 * time.Minute
+	ShutdownTimeout = 30 * time.Second
+
+	// busRetryInterval is how often to try connecting to the system manager
+	// of a container which has not started yet.
+	busRetryInterval = 100 * time.Millisecond
+)
+
+// ErrTimeout means a container does not reach a state in time.
+type ErrTimeout struct {
+	Machine string
+	Waiting string
+}
+
+func (e ErrTimeout) Error() string {
+	return "timed out waiting for " + e.Machine + " to " + e.Waiting
+}
+
+// ErrNotRunning means the system manager of a container finishes booting
+// in a state other than running or degraded, as maintenance.
+type ErrNotRunning struct {
+	Machine string
+	State   string
+}
+
+func (e ErrNotRunning) Error() string {
+	return e.Machine + " is " + e.State + " after booting"
+}
+
+func waitError(ctx context.Context, err error, machineId string, waiting string) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrTimeout{Machine: machineId, Waiting: waiting}
+	}
+	return err
+}
+
+// waitUntilRunningOrDegraded waits until the container is registered, then
+// until its system manager finishes booting.
+func waitUntilRunningOrDegraded(ctx context.Context, machineId string) error {
+	m, err := machined.NewManager()
+	if err != nil {
+		return err
+	}
+	if err := m.WaitRegistered(ctx, machineId); err != nil {
+		return waitError(ctx, err, machineId, "boot")
+	}
+	bus, err := dialBooting(ctx, machineId)
+	if err != nil {
+		return waitError(ctx, err, machineId, "boot")
+	}
+	defer bus.Close()
+	manager := bus.Manager()
+	if err := manager.Subscribe(); err != nil {
+		return err
+	}
+	signals := bus.Signals()
 	for {
-		switch {
-		case MachineRunning(MachineStatus(context.TODO(), machineId)):
-			return false
+		// subscribed before reading, so that the end of booting is not
+		// missed
+		state, err := manager.SystemState(ctx)
+		if err != nil {
+			return err
+		}
+		switch state {
+		case "initializing", "starting":
 		default:
-			if ctx != nil {
-				select {
-				case <-ctx.Done():
-					return true
-				default:
-				}
+			if MachineRunning(state) {
+				return nil
 			}
+			return ErrNotRunning{Machine: machineId, State: state}
+		}
+		if err := waitStartupFinished(ctx, signals); err != nil {
+			return waitError(ctx, err, machineId, "boot")
 		}
-		time.Sleep(50 * time.Millisecond)
 	}
 }
 
-func waitUntilShutdown(ctx context.Context, machineId string) (cancelled bool) {
+// dialBooting connects to the system manager of a container, retrying until
+// its socket is up, which is right after the manager starts.
+func dialBooting(ctx context.Context, machineId string) (*systemd1.Bus, error) {
 	for {
-		switch {
-		case MachineDead(MachineStatus(ctx, machineId)):
-			return false
-		default:
-			if ctx != nil {
-				select {
-				case <-ctx.Done():
-					return true
-				default:
-				}
+		bus, _, err := machineBus(machineId)
+		if err == nil {
+			return bus, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(busRetryInterval):
+		}
+	}
+}
+
+func waitStartupFinished(ctx context.Context, signals <-chan *dbus.Signal) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case sig, ok := <-signals:
+			if !ok {
+				return ErrBusClosed
+			}
+			if sig.Name == systemd1.SignalStartupFinished {
+				return nil
 			}
 		}
-		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// waitUntilShutdown waits until the container is removed.
+func waitUntilShutdown(ctx context.Context, machineId string) error {
+	m, err := machined.NewManager()
+	if err != nil {
+		return err
+	}
+	err = m.WaitRemoved(ctx, machineId)
+	return waitError(ctx, err, machineId, "shut down")
+}
+
+// waitShutdownAfterCommand waits until the container is removed if a
+// command shuts it down.
+func waitShutdownAfterCommand(machineId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if !MachineRunning(MachineStatus(ctx, machineId)) {
+		waitUntilShutdown(ctx, machineId)
 	}
 }
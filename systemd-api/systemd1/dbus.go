@@ -0,0 +1,62 @@
+package systemd1
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const Dest = "org.freedesktop.systemd1"
+
+// PrivateSocket is where the system manager takes connections of root
+// directly, without a bus daemon.
+const PrivateSocket = "/run/systemd/private"
+
+// Bus is a direct connection to the system manager of a container.
+type Bus struct {
+	Conn *dbus.Conn
+}
+
+// MachineSocket is the private socket of the container, seen through the
+// root of its leader.
+func MachineSocket(leader uint32) string {
+	return "/proc/" + strconv.FormatUint(uint64(leader), 10) + "/root" + PrivateSocket
+}
+
+// DialMachine connects to the system manager of the container whose leader
+// is given. The bus must be closed after use.
+func DialMachine(leader uint32) (*Bus, error) {
+	c, err := dbus.Dial("unix:path=" + MachineSocket(leader))
+	if err != nil {
+		return nil, fmt.Errorf("machine bus: %w", err)
+	}
+	// a peer-to-peer connection, so there is no Hello
+	authMethods := []dbus.Auth{dbus.AuthExternal(strconv.Itoa(os.Getuid()))}
+	if err := c.Auth(authMethods); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("machine bus: %w", err)
+	}
+	return &Bus{Conn: c}, nil
+}
+
+func (b *Bus) Manager() *Manager {
+	return &Manager{b.Conn.Object(Dest, ManagerPath)}
+}
+
+func (b *Bus) Unit(path dbus.ObjectPath) *Unit {
+	return &Unit{b.Conn.Object(Dest, path)}
+}
+
+// Signals delivers the signals of the manager, which must be subscribed to
+// with Manager.Subscribe. The channel is closed with the bus.
+func (b *Bus) Signals() <-chan *dbus.Signal {
+	ch := make(chan *dbus.Signal, 16)
+	b.Conn.Signal(ch)
+	return ch
+}
+
+func (b *Bus) Close() error {
+	return b.Conn.Close()
+}
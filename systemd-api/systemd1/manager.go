@@ -0,0 +1,94 @@
+package systemd1
+
+import (
+	"context"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const ManagerPath = "/org/freedesktop/systemd1"
+
+type Manager struct {
+	Obj dbus.BusObject
+}
+
+const ManagerInterface = "org.freedesktop.systemd1.Manager"
+
+// Signals of the manager.
+const (
+	SignalJobRemoved      = ManagerInterface + ".JobRemoved"
+	SignalStartupFinished = ManagerInterface + ".StartupFinished"
+)
+
+// Modes of jobs.
+const (
+	ModeReplace = "replace"
+	ModeFail    = "fail"
+)
+
+// Property is a property of a transient unit.
+type Property struct {
+	Name  string
+	Value dbus.Variant
+}
+
+// NewProperty makes a property of a transient unit.
+func NewProperty(name string, value interface{}) Property {
+	return Property{Name: name, Value: dbus.MakeVariant(value)}
+}
+
+// auxUnit is an auxiliary unit of StartTransientUnit, none are used.
+type auxUnit struct {
+	Name       string
+	Properties []Property
+}
+
+// SystemState is "starting" while booting, then "running", "degraded" and
+// so on, as systemctl is-system-running prints.
+func (m Manager) SystemState(ctx context.Context) (string, error) {
+	var v dbus.Variant
+	err := m.Obj.CallWithContext(ctx, "org.freedesktop.DBus.Properties.Get", 0, ManagerInterface, "SystemState").Store(&v)
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.Value().(string)
+	if !ok {
+		return "", ErrBadProperty
+	}
+	return s, nil
+}
+
+// Subscribe makes the manager send signals to this connection.
+func (m Manager) Subscribe() error {
+	return m.Obj.Call(ManagerInterface+".Subscribe", 0).Err
+}
+
+// StartTransientUnit creates a unit with the properties and starts it.
+func (m Manager) StartTransientUnit(name string, mode string, props []Property) (job dbus.ObjectPath, err error) {
+	err = m.Obj.Call(ManagerInterface+".StartTransientUnit", 0, name, mode, props, []auxUnit{}).Store(&job)
+	return job, err
+}
+
+// GetUnit returns the unit, which must be loaded.
+func (m Manager) GetUnit(name string) (dbus.ObjectPath, error) {
+	var path dbus.ObjectPath
+	err := m.Obj.Call(ManagerInterface+".GetUnit", 0, name).Store(&path)
+	return path, err
+}
+
+func (m Manager) StopUnit(name string, mode string) error {
+	return m.Obj.Call(ManagerInterface+".StopUnit", 0, name, mode).Err
+}
+
+// ResetFailedUnit resets the failed state of the unit, so that it is
+// unloaded.
+func (m Manager) ResetFailedUnit(name string) error {
+	return m.Obj.Call(ManagerInterface+".ResetFailedUnit", 0, name).Err
+}
+
+// ExecCommand is an entry of ExecStart and the like.
+type ExecCommand struct {
+	Path          string
+	Args          []string // including argv[0]
+	IgnoreFailure bool
+}
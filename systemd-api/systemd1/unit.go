@@ -0,0 +1,80 @@
+package systemd1
+
+import (
+	"errors"
+
+	"github.com/godbus/dbus/v5"
+)
+
+type Unit struct {
+	Obj dbus.BusObject
+}
+
+const (
+	UnitInterface    = "org.freedesktop.systemd1.Unit"
+	ServiceInterface = "org.freedesktop.systemd1.Service"
+)
+
+var ErrBadProperty = errors.New("unexpected type of unit property")
+
+// ActiveState is "active", "inactive", "failed" and so on.
+func (u Unit) ActiveState() (string, error) {
+	v, err := u.Obj.GetProperty(UnitInterface + ".ActiveState")
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.Value().(string)
+	if !ok {
+		return "", ErrBadProperty
+	}
+	return s, nil
+}
+
+// Job returns the id of the job pending for the unit, 0 if there is none.
+func (u Unit) Job() (uint32, error) {
+	v, err := u.Obj.GetProperty(UnitInterface + ".Job")
+	if err != nil {
+		return 0, err
+	}
+	job, ok := v.Value().([]interface{})
+	if !ok || len(job) != 2 {
+		return 0, ErrBadProperty
+	}
+	id, ok := job[0].(uint32)
+	if !ok {
+		return 0, ErrBadProperty
+	}
+	return id, nil
+}
+
+// Result is "success" or why the service failed.
+func (u Unit) Result() (string, error) {
+	v, err := u.Obj.GetProperty(ServiceInterface + ".Result")
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.Value().(string)
+	if !ok {
+		return "", ErrBadProperty
+	}
+	return s, nil
+}
+
+// ExecMain returns how the main process of the service ended: code is a
+// CLD_* value of waitid(2), status the exit status or the signal.
+func (u Unit) ExecMain() (code int32, status int32, err error) {
+	c, err := u.Obj.GetProperty(ServiceInterface + ".ExecMainCode")
+	if err != nil {
+		return 0, 0, err
+	}
+	s, err := u.Obj.GetProperty(ServiceInterface + ".ExecMainStatus")
+	if err != nil {
+		return 0, 0, err
+	}
+	code, ok := c.Value().(int32)
+	status, ok2 := s.Value().(int32)
+	if !ok || !ok2 {
+		return 0, 0, ErrBadProperty
+	}
+	return code, status, nil
+}